@@ -0,0 +1,222 @@
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SymlinkMode selects how symbolic links are written to archives.
+type SymlinkMode int
+
+const (
+	// SymlinkKeep stores symbolic links as links, the destination is read
+	// using Readlink.
+	SymlinkKeep SymlinkMode = iota
+	// SymlinkFollow stores the regular file a symbolic link points to.
+	// Links to anything else are skipped.
+	SymlinkFollow
+	// SymlinkSkip leaves symbolic links out of the archive.
+	SymlinkSkip
+)
+
+// ArchiveOptions configures the archive writers. The zero value writes
+// entries without a prefix using the metadata reported by the FileSystem.
+type ArchiveOptions struct {
+	// Prefix is prepended to the name of every entry, if set the root
+	// directory itself is also stored as an entry named Prefix.
+	Prefix string
+	// Mode, if set, is called to override the mode of every entry.
+	Mode     func(path string, mode os.FileMode) os.FileMode
+	Symlinks SymlinkMode
+	// Reproducible replaces all modification times with ModTime and all
+	// owner information with Uid and Gid, so that the same tree always
+	// produces identical archives.
+	Reproducible bool
+	// ModTime is used when Reproducible is set, the zero value means the
+	// Unix epoch.
+	ModTime  time.Time
+	Uid, Gid int
+	// GzipLevel is the compression level used by WriteTarGzip, the zero
+	// value means gzip.DefaultCompression.
+	GzipLevel int
+}
+
+// archiveEntry is a single file prepared for an archive writer.
+type archiveEntry struct {
+	path string // path in the FileSystem
+	name string // name in the archive, directories end with a slash
+	fi   os.FileInfo
+	mode os.FileMode
+	link string // symbolic link destination
+}
+
+// walkArchive calls fn for each entry in the tree at root in lexical order.
+func walkArchive(fs FileSystem, root string, opts *ArchiveOptions, fn func(e archiveEntry) error) error {
+	root = pathpkg.Clean("/" + root)
+	prefix := strings.Trim(opts.Prefix, "/")
+	return Walk(root, fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "archive %s", p)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		name := pathpkg.Join(prefix, rel)
+		if name == "" || name == "." {
+			return nil
+		}
+		e := archiveEntry{path: p, name: name, fi: fi}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			switch opts.Symlinks {
+			case SymlinkSkip:
+				return nil
+			case SymlinkFollow:
+				sfi, err := fs.Stat(p)
+				if err != nil {
+					return errors.Wrapf(err, "archive %s", p)
+				}
+				if !sfi.Mode().IsRegular() {
+					return nil
+				}
+				e.fi = renamedFileInfo(sfi, fi.Name())
+			default:
+				link, err := Readlink(fs, p)
+				if err != nil {
+					return errors.Wrapf(err, "archive %s", p)
+				}
+				e.link = link
+			}
+		}
+		if e.fi.IsDir() {
+			e.name += "/"
+		}
+		e.mode = e.fi.Mode()
+		if opts.Mode != nil {
+			e.mode = opts.Mode(p, e.mode)
+		}
+		return fn(e)
+	})
+}
+
+func (opts *ArchiveOptions) modTime(fi os.FileInfo) time.Time {
+	if !opts.Reproducible {
+		return fi.ModTime()
+	}
+	if opts.ModTime.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+	return opts.ModTime.UTC().Truncate(time.Second)
+}
+
+// WriteTar writes the tree rooted at root in fs to w as a tar archive.
+func WriteTar(w io.Writer, fs FileSystem, root string, opts *ArchiveOptions) error {
+	if opts == nil {
+		opts = &ArchiveOptions{}
+	}
+	tw := tar.NewWriter(w)
+	err := walkArchive(fs, root, opts, func(e archiveEntry) error {
+		hdr, err := tar.FileInfoHeader(modeFileInfo(e.fi, e.mode), e.link)
+		if err != nil {
+			return errors.Wrapf(err, "archive %s", e.path)
+		}
+		hdr.Name = e.name
+		if opts.Reproducible {
+			hdr.ModTime = opts.modTime(e.fi)
+			hdr.AccessTime = time.Time{}
+			hdr.ChangeTime = time.Time{}
+			hdr.Uid, hdr.Gid = opts.Uid, opts.Gid
+			hdr.Uname, hdr.Gname = "", ""
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "archive %s", e.path)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		return copyEntry(tw, fs, e)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(tw.Close(), "archive")
+}
+
+// WriteTarGzip writes the tree rooted at root in fs to w as a gzip
+// compressed tar archive.
+func WriteTarGzip(w io.Writer, fs FileSystem, root string, opts *ArchiveOptions) error {
+	if opts == nil {
+		opts = &ArchiveOptions{}
+	}
+	level := opts.GzipLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return errors.Wrap(err, "archive")
+	}
+	if err := WriteTar(zw, fs, root, opts); err != nil {
+		return err
+	}
+	return errors.Wrap(zw.Close(), "archive")
+}
+
+// WriteZip writes the tree rooted at root in fs to w as a zip archive.
+// Regular files are deflated.
+func WriteZip(w io.Writer, fs FileSystem, root string, opts *ArchiveOptions) error {
+	if opts == nil {
+		opts = &ArchiveOptions{}
+	}
+	zw := zip.NewWriter(w)
+	err := walkArchive(fs, root, opts, func(e archiveEntry) error {
+		hdr, err := zip.FileInfoHeader(modeFileInfo(e.fi, e.mode))
+		if err != nil {
+			return errors.Wrapf(err, "archive %s", e.path)
+		}
+		hdr.Name = e.name
+		hdr.Modified = opts.modTime(e.fi)
+		if e.fi.Mode().IsRegular() {
+			hdr.Method = zip.Deflate
+		} else {
+			hdr.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return errors.Wrapf(err, "archive %s", e.path)
+		}
+		switch {
+		case e.link != "":
+			_, err := io.WriteString(fw, e.link)
+			return errors.Wrapf(err, "archive %s", e.path)
+		case e.fi.Mode().IsRegular():
+			return copyEntry(fw, fs, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(zw.Close(), "archive")
+}
+
+func copyEntry(w io.Writer, fs FileSystem, e archiveEntry) error {
+	f, err := fs.Open(e.path)
+	if err != nil {
+		return errors.Wrapf(err, "archive %s", e.path)
+	}
+	defer f.Close()
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return errors.Wrapf(err, "archive %s", e.path)
+	}
+	if n != e.fi.Size() {
+		return errors.Errorf("archive %s: read %d bytes, expected %d", e.path, n, e.fi.Size())
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var archiveFS = ModeMap(Map(map[string]string{
+	"bin/run.sh":   "#!/bin/sh",
+	"doc/a.txt":    "aaa",
+	"doc/b/c.txt":  "c",
+	"top.txt":      "top",
+	"skip/me.txt":  "skip",
+	"skip/me2.txt": "skip",
+}), map[string]os.FileMode{
+	"bin/run.sh": 0755,
+})
+
+func readTar(t *testing.T, r io.Reader) string {
+	t.Helper()
+	var res []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, fmt.Sprintf("%s %o %d/%d %s %q %q", hdr.Name, hdr.Mode, hdr.Uid, hdr.Gid,
+			hdr.ModTime.UTC().Format(time.RFC3339), hdr.Linkname, data))
+	}
+	return strings.Join(res, "\n")
+}
+
+func TestWriteTar(t *testing.T) {
+	opts := &ArchiveOptions{
+		Prefix:       "pkg-1.0",
+		Reproducible: true,
+		ModTime:      time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		Uid:          1000,
+		Gid:          100,
+		Mode: func(p string, mode os.FileMode) os.FileMode {
+			if p == "/top.txt" {
+				return 0600
+			}
+			return mode
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, archiveFS, "/", opts); err != nil {
+		t.Fatal(err)
+	}
+	want := `pkg-1.0/ 755 1000/100 2018-01-01T00:00:00Z "" ""
+pkg-1.0/bin/ 755 1000/100 2018-01-01T00:00:00Z "" ""
+pkg-1.0/bin/run.sh 755 1000/100 2018-01-01T00:00:00Z "" "#!/bin/sh"
+pkg-1.0/doc/ 755 1000/100 2018-01-01T00:00:00Z "" ""
+pkg-1.0/doc/a.txt 444 1000/100 2018-01-01T00:00:00Z "" "aaa"
+pkg-1.0/doc/b/ 755 1000/100 2018-01-01T00:00:00Z "" ""
+pkg-1.0/doc/b/c.txt 444 1000/100 2018-01-01T00:00:00Z "" "c"
+pkg-1.0/skip/ 755 1000/100 2018-01-01T00:00:00Z "" ""
+pkg-1.0/skip/me.txt 444 1000/100 2018-01-01T00:00:00Z "" "skip"
+pkg-1.0/skip/me2.txt 444 1000/100 2018-01-01T00:00:00Z "" "skip"
+pkg-1.0/top.txt 600 1000/100 2018-01-01T00:00:00Z "" "top"`
+	first := buf.Bytes()
+	if got := readTar(t, bytes.NewReader(first)); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf = bytes.Buffer{}
+	if err := WriteTar(&buf, archiveFS, "/", opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, buf.Bytes()) {
+		t.Fatal("expected reproducible output")
+	}
+}
+
+func TestWriteTarGzipSubtree(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTarGzip(&buf, archiveFS, "/doc", nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `a.txt 444 0/0 1970-01-01T00:00:00Z "" "aaa"
+b/ 755 0/0 1970-01-01T00:00:00Z "" ""
+b/c.txt 444 0/0 1970-01-01T00:00:00Z "" "c"`
+	if got := readTar(t, zr); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &ArchiveOptions{Reproducible: true, Prefix: "x/"}
+	if err := WriteZip(&buf, Exclude(archiveFS, "/skip"), "/", opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, fmt.Sprintf("%s %v %s %q", f.Name, f.Mode(), f.Modified.UTC().Format(time.RFC3339), data))
+	}
+	want := `x/ drwxr-xr-x 1970-01-01T00:00:00Z ""
+x/bin/ drwxr-xr-x 1970-01-01T00:00:00Z ""
+x/bin/run.sh -rwxr-xr-x 1970-01-01T00:00:00Z "#!/bin/sh"
+x/doc/ drwxr-xr-x 1970-01-01T00:00:00Z ""
+x/doc/a.txt -r--r--r-- 1970-01-01T00:00:00Z "aaa"
+x/doc/b/ drwxr-xr-x 1970-01-01T00:00:00Z ""
+x/doc/b/c.txt -r--r--r-- 1970-01-01T00:00:00Z "c"
+x/top.txt -r--r--r-- 1970-01-01T00:00:00Z "top"`
+	if got := strings.Join(res, "\n"); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTarSymlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vfs-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(tmp, "link")); err != nil {
+		t.Skip(err)
+	}
+	for _, tt := range []struct {
+		mode SymlinkMode
+		want string
+	}{
+		{SymlinkKeep, `file 644 0/0 1970-01-01T00:00:00Z "" "file"
+link 777 0/0 1970-01-01T00:00:00Z "file" ""`},
+		{SymlinkFollow, `file 644 0/0 1970-01-01T00:00:00Z "" "file"
+link 644 0/0 1970-01-01T00:00:00Z "" "file"`},
+		{SymlinkSkip, `file 644 0/0 1970-01-01T00:00:00Z "" "file"`},
+	} {
+		var buf bytes.Buffer
+		opts := &ArchiveOptions{Symlinks: tt.mode, Reproducible: true}
+		if err := WriteTar(&buf, OS(tmp), "/", opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTar(t, &buf); got != tt.want {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	pathpkg "path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Checksums maps rooted slash separated paths of regular files to their hex
+// encoded SHA-256 digests.
+type Checksums map[string]string
+
+// ParseChecksums reads checksums in the format written by sha256sum or, if
+// the input starts with "{", a JSON object of paths and digests as written
+// by WriteChecksumsJSON. Paths are made rooted, so "./a" and "a" are both
+// "/a".
+func ParseChecksums(r io.Reader) (Checksums, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return Checksums{}, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing checksums")
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		br.UnreadRune()
+		if c == '{' {
+			return parseChecksumsJSON(br)
+		}
+		return parseChecksumsText(br)
+	}
+}
+
+func parseChecksumsJSON(r io.Reader) (Checksums, error) {
+	var m map[string]string
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "parsing checksums")
+	}
+	c := make(Checksums, len(m))
+	for p, sum := range m {
+		if err := c.add(p, sum); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func parseChecksumsText(r io.Reader) (Checksums, error) {
+	c := Checksums{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+		// sha256sum escapes names containing a backslash or a newline
+		// and marks the line with a leading backslash.
+		escaped := line[0] == '\\'
+		if escaped {
+			line = line[1:]
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+1 >= len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+			return nil, errors.Errorf("checksums line %d: invalid format", n)
+		}
+		sum, name := line[:i], line[i+2:]
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		if err := c.add(name, sum); err != nil {
+			return nil, errors.Wrapf(err, "checksums line %d", n)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "parsing checksums")
+	}
+	return c, nil
+}
+
+func (c Checksums) add(name, sum string) error {
+	sum = strings.ToLower(sum)
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return errors.Errorf("invalid sha256 digest %q for %q", sum, name)
+	}
+	p := pathpkg.Clean("/" + name)
+	if p == "/" {
+		return errors.Errorf("invalid path %q", name)
+	}
+	if prev, ok := c[p]; ok && prev != sum {
+		return errors.Errorf("conflicting digests for %q", name)
+	}
+	c[p] = sum
+	return nil
+}
+
+// paths returns the paths of c in sorted order.
+func (c Checksums) paths() []string {
+	paths := make([]string, 0, len(c))
+	for p := range c {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WriteChecksums writes c in the format of sha256sum with paths relative
+// to the root, sorted by path.
+func WriteChecksums(w io.Writer, c Checksums) error {
+	var buf bytes.Buffer
+	for _, p := range c.paths() {
+		name := p[1:]
+		if strings.ContainsAny(name, "\\\n") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+			buf.WriteByte('\\')
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", c[p], name)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteChecksumsJSON writes c as a JSON object of relative paths and
+// digests.
+func WriteChecksumsJSON(w io.Writer, c Checksums) error {
+	m := make(map[string]string, len(c))
+	for p, sum := range c {
+		m[strings.TrimPrefix(p, "/")] = sum
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// GenerateChecksums walks fs and returns the digests of all regular files.
+// Symbolic links and special files are left out.
+func GenerateChecksums(fs FileSystem) (Checksums, error) {
+	c := Checksums{}
+	err := Walk("/", fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := hashFile(fs, p, HashSHA256)
+		if err != nil {
+			return err
+		}
+		c[p] = hex.EncodeToString(sum)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// VerifyResult lists the paths that failed Verify, sorted by path.
+type VerifyResult struct {
+	// Missing are listed files which do not exist or are not regular
+	// files.
+	Missing []string
+	// Extra are regular files which are not listed.
+	Extra []string
+	// Corrupted are listed files whose contents do not match their
+	// digests.
+	Corrupted []string
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Corrupted) == 0
+}
+
+// Verify checks the regular files of fs against c, hashing the files in
+// parallel. Errors other than missing files stop the verification and are
+// returned.
+func Verify(fs FileSystem, c Checksums) (*VerifyResult, error) {
+	var (
+		res  VerifyResult
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	paths := make(chan string)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				missing, corrupted, err := verifyFile(fs, p, c[p])
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, err)
+				case missing:
+					res.Missing = append(res.Missing, p)
+				case corrupted:
+					res.Corrupted = append(res.Corrupted, p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range c.paths() {
+		paths <- p
+	}
+	close(paths)
+
+	err := Walk("/", fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := c[p]; !ok && fi.Mode().IsRegular() {
+			res.Extra = append(res.Extra, p)
+		}
+		return nil
+	})
+	wg.Wait()
+	if err != nil {
+		return nil, errors.Wrap(err, "verify")
+	}
+	if len(errs) > 0 {
+		return nil, errors.Wrap(errs[0], "verify")
+	}
+	sort.Strings(res.Missing)
+	sort.Strings(res.Corrupted)
+	return &res, nil
+}
+
+func verifyFile(fs FileSystem, p, sum string) (missing, corrupted bool, err error) {
+	fi, err := fs.Stat(p)
+	if os.IsNotExist(err) {
+		return true, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return true, false, nil
+	}
+	got, err := hashFile(fs, p, HashSHA256)
+	if err != nil {
+		return false, false, err
+	}
+	return false, hex.EncodeToString(got) != sum, nil
+}
+
+// Checksummed wraps fs and exposes only the files listed in c and their
+// parent directories. The contents of files read in sequence from the start
+// are hashed and verified once the size the file had when it was opened has
+// been read, or at io.EOF if it is smaller. A mismatch is returned by that
+// Read, by the final Read instead of io.EOF and by Close.
+func Checksummed(fs FileSystem, c Checksums) FileSystem {
+	dirs := map[string]bool{"/": true}
+	for p := range c {
+		for d := pathpkg.Dir(p); !dirs[d]; d = pathpkg.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	return checksumFS{fs: fs, sums: c, dirs: dirs}
+}
+
+type checksumFS struct {
+	fs   FileSystem
+	sums Checksums
+	dirs map[string]bool
+}
+
+func (fs checksumFS) String() string {
+	return fmt.Sprintf("checksummed(%s)", fs.fs.String())
+}
+
+// listed returns an error unless path is a listed file or one of their
+// parent directories.
+func (fs checksumFS) listed(op, path string) (string, error) {
+	path = pathpkg.Clean("/" + path)
+	if _, ok := fs.sums[path]; !ok && !fs.dirs[path] {
+		return "", &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return path, nil
+}
+
+func (fs checksumFS) Open(path string) (ReadSeekCloser, error) {
+	path, err := fs.listed("open", path)
+	if err != nil {
+		return nil, err
+	}
+	sum, ok := fs.sums[path]
+	if !ok {
+		return fs.fs.Open(path)
+	}
+	fi, err := fs.fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	want, _ := hex.DecodeString(sum)
+	return &checksumFile{ReadSeekCloser: f, path: path, want: want, size: fi.Size(), h: sha256.New()}, nil
+}
+
+// Hash implements the Hasher interface, the SHA-256 digests are those
+// listed.
+func (fs checksumFS) Hash(path, algo string) ([]byte, error) {
+	path, err := fs.listed("hash", path)
+	if err != nil {
+		return nil, err
+	}
+	sum, ok := fs.sums[path]
+	if !ok {
+		return nil, &os.PathError{Op: "hash", Path: path, Err: errors.New("not a regular file")}
+	}
+	if algo != HashSHA256 {
+		return nil, &os.PathError{Op: "hash", Path: path, Err: ErrHashUnsupported}
+	}
+	return hex.DecodeString(sum)
+}
+
+func (fs checksumFS) Lstat(path string) (os.FileInfo, error) {
+	path, err := fs.listed("lstat", path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fs.Lstat(path)
+}
+
+func (fs checksumFS) Stat(path string) (os.FileInfo, error) {
+	path, err := fs.listed("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fs.Stat(path)
+}
+
+func (fs checksumFS) ReadDir(path string) ([]os.FileInfo, error) {
+	path, err := fs.listed("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		p := pathpkg.Join(path, fi.Name())
+		if _, ok := fs.sums[p]; ok || fs.dirs[p] {
+			list = append(list, fi)
+		}
+	}
+	return list, nil
+}
+
+// checksumFile hashes the contents while they are read in sequence from
+// the start.
+type checksumFile struct {
+	ReadSeekCloser
+	path   string
+	want   []byte
+	size   int64     // the size when opened
+	h      hash.Hash // nil when the reads are not sequential or done
+	offset int64
+	hashed int64
+	err    error // the hash mismatch, if any
+}
+
+func (f *checksumFile) Read(p []byte) (int, error) {
+	n, err := f.ReadSeekCloser.Read(p)
+	if f.h != nil && f.hashed == f.offset {
+		f.h.Write(p[:n])
+		f.hashed += int64(n)
+	}
+	f.offset += int64(n)
+	if f.h != nil && f.hashed == f.offset && (f.hashed >= f.size || err == io.EOF) {
+		sum := f.h.Sum(nil)
+		f.h = nil
+		if !bytes.Equal(sum, f.want) {
+			f.err = &os.PathError{Op: "read", Path: f.path, Err: errors.Errorf("sha256 mismatch, got %x want %x", sum, f.want)}
+			return n, f.err
+		}
+	}
+	if err == io.EOF && f.err != nil {
+		return n, f.err
+	}
+	return n, err
+}
+
+func (f *checksumFile) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.ReadSeekCloser.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	f.offset = n
+	if n == 0 {
+		// reading from the start verifies the hash again.
+		f.h, f.hashed = sha256.New(), 0
+	}
+	return n, nil
+}
+
+func (f *checksumFile) Close() error {
+	if err := f.ReadSeekCloser.Close(); err != nil {
+		return err
+	}
+	return f.err
+}
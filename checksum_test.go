@@ -0,0 +1,179 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseChecksums(t *testing.T) {
+	want := Checksums{
+		"/a.txt":     sha256Hex("a"),
+		"/dir/b.txt": sha256Hex("b"),
+		`/c\d`:       sha256Hex("c"),
+	}
+	var text, js bytes.Buffer
+	if err := WriteChecksums(&text, want); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "\n\\"+sha256Hex("c")+`  c\\d`+"\n") {
+		t.Fatalf("unexpected escaping:\n%s", text.String())
+	}
+	if err := WriteChecksumsJSON(&js, want); err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{text.String(), js.String()} {
+		got, err := ParseChecksums(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	got, err := ParseChecksums(strings.NewReader(
+		strings.ToUpper(sha256Hex("a")) + " *./a.txt\n\n# comment\n" + sha256Hex("b") + "  dir/b.txt\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, Checksums{"/a.txt": sha256Hex("a"), "/dir/b.txt": sha256Hex("b")}) {
+		t.Fatalf("unexpected checksums %v", got)
+	}
+
+	for _, in := range []string{
+		"abc  a.txt",
+		sha256Hex("a") + " a.txt",
+		sha256Hex("a") + "  .",
+		sha256Hex("a") + "  a\n" + sha256Hex("b") + "  ./a",
+		`{"a": "xyz"}`,
+	} {
+		if _, err := ParseChecksums(strings.NewReader(in)); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestChecksummed(t *testing.T) {
+	m := Map(map[string]string{
+		"a.txt":         "a",
+		"bad.txt":       "good",
+		"dir/b.txt":     "b",
+		"dir/extra.txt": "extra",
+		"unlisted/c":    "c",
+	})
+	c, err := GenerateChecksums(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(c, "/dir/extra.txt")
+	delete(c, "/unlisted/c")
+	c["/bad.txt"] = sha256Hex("bad")
+	c["/missing/d"] = sha256Hex("d")
+
+	fs := Checksummed(m, c)
+	assertIsRegular(t, fs, "/a.txt", "/bad.txt", "/dir/b.txt")
+	assertIsDir(t, fs, "/", "/dir")
+	assertIsNotExist(t, fs, "/dir/extra.txt", "/unlisted", "/unlisted/c", "/missing/d")
+	if _, err := ReadFile(fs, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := Hash(fs, "/bad.txt", HashSHA256); err != nil || hex.EncodeToString(sum) != sha256Hex("bad") {
+		t.Fatalf("expected the listed digest, got %x, %v", sum, err)
+	}
+
+	f, err := fs.Open("/bad.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantErr := "read /bad.txt: sha256 mismatch, got " + sha256Hex("good") + " want " + sha256Hex("bad")
+	if _, err := ioutil.ReadAll(f); err == nil || err.Error() != wantErr {
+		t.Fatalf("expected %q, got %v", wantErr, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil || err.Error() != wantErr {
+		t.Fatalf("expected %q from close, got %v", wantErr, err)
+	}
+
+	// reading exactly the size of the file verifies it without io.EOF.
+	f, err = fs.Open("/bad.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 4)); n != 4 || err == nil || err.Error() != wantErr {
+		t.Fatalf("expected %q, got %v", wantErr, err)
+	}
+	if err := f.Close(); err == nil || err.Error() != wantErr {
+		t.Fatalf("expected %q from close, got %v", wantErr, err)
+	}
+	f, err = fs.Open("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// files not read in sequence are not verified.
+	f, err = fs.Open("/bad.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "od" {
+		t.Fatalf("unexpected read %q, %v", data, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Verify(m, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &VerifyResult{
+		Missing:   []string{"/missing/d"},
+		Extra:     []string{"/dir/extra.txt", "/unlisted/c"},
+		Corrupted: []string{"/bad.txt"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %+v, want %+v", res, want)
+	}
+	if res.OK() {
+		t.Fatal("expected verification to fail")
+	}
+	c, _ = GenerateChecksums(m)
+	if res, err := Verify(m, c); err != nil || !res.OK() {
+		t.Fatalf("unexpected result %+v, %v", res, err)
+	}
+	assertWalk(t, Checksummed(m, c), `dir : /
+file: /a.txt
+data: a
+file: /bad.txt
+data: good
+dir : /dir
+file: /dir/b.txt
+data: b
+file: /dir/extra.txt
+data: extra
+dir : /unlisted
+file: /unlisted/c
+data: c`)
+}
@@ -0,0 +1,264 @@
+// Package cryptfs implements a FileSystem wrapper which transparently
+// decrypts files stored encrypted at rest, and the tooling to write them.
+//
+// File contents are sealed with AES-256-GCM in fixed size segments so
+// seeking only has to decrypt the segment containing the new offset.
+// Optionally file and directory names are encrypted as well.
+package cryptfs // import "github.com/thomasf/vfs/cryptfs"
+
+import (
+	"crypto/cipher"
+	"io"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Options configures both the FileSystem and the writing side, the same
+// options must be used for both.
+type Options struct {
+	// EncryptNames encrypts every file and directory name. Names are
+	// encrypted deterministically, equal names have equal encrypted names.
+	EncryptNames bool
+	// SegmentSize is the plaintext size of a segment when writing, it
+	// defaults to DefaultSegmentSize. Readers use the size in the header.
+	SegmentSize int
+}
+
+// New returns a FileSystem which decrypts the files of fs with key, which
+// must be KeySize bytes. Sizes are read from the file headers. Regular
+// files which are not in the encrypted format and, with EncryptNames, names
+// which can not be decrypted are left out of directory listings.
+func New(fs vfs.FileSystem, key []byte, opts *Options) (vfs.FileSystem, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	k, err := newKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptFS{fs: fs, keys: k, names: opts.EncryptNames}, nil
+}
+
+type cryptFS struct {
+	fs    vfs.FileSystem
+	keys  *keys
+	names bool
+}
+
+func (fs *cryptFS) String() string {
+	return "crypt(" + fs.fs.String() + ")"
+}
+
+// translate returns the path in the underlying FileSystem.
+func (fs *cryptFS) translate(p string) string {
+	p = pathpkg.Clean("/" + p)
+	if !fs.names || p == "/" {
+		return p
+	}
+	elems := strings.Split(p[1:], "/")
+	for i, e := range elems {
+		elems[i] = fs.keys.encryptName(e)
+	}
+	return "/" + strings.Join(elems, "/")
+}
+
+func (fs *cryptFS) Open(p string) (vfs.ReadSeekCloser, error) {
+	f, err := fs.fs.Open(fs.translate(p))
+	if err != nil {
+		return nil, err
+	}
+	r, err := fs.newReader(f)
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	return r, nil
+}
+
+func (fs *cryptFS) Stat(p string) (os.FileInfo, error) {
+	return fs.stat("stat", p, fs.fs.Stat)
+}
+
+func (fs *cryptFS) Lstat(p string) (os.FileInfo, error) {
+	return fs.stat("lstat", p, fs.fs.Lstat)
+}
+
+func (fs *cryptFS) stat(op, p string, f func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	p = pathpkg.Clean("/" + p)
+	fi, err := f(fs.translate(p))
+	if err != nil {
+		return nil, err
+	}
+	name := pathpkg.Base(p)
+	if p == "/" {
+		name = fi.Name()
+	}
+	cfi, err := fs.fileInfo(p, name, fi)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	return cfi, nil
+}
+
+// fileInfo returns the decrypted file info for the entry at p, the size of
+// regular files is read from the header.
+func (fs *cryptFS) fileInfo(p, name string, fi os.FileInfo) (os.FileInfo, error) {
+	cfi := &fileInfo{name: name, size: fi.Size(), mode: fi.Mode(), modTime: fi.ModTime()}
+	if !fi.Mode().IsRegular() {
+		return cfi, nil
+	}
+	f, err := fs.fs.Open(fs.translate(p))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, _, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	cfi.size = h.size
+	return cfi, nil
+}
+
+func (fs *cryptFS) ReadDir(p string) ([]os.FileInfo, error) {
+	p = pathpkg.Clean("/" + p)
+	fis, err := fs.fs.ReadDir(fs.translate(p))
+	if err != nil {
+		return nil, err
+	}
+	var list []os.FileInfo
+	for _, fi := range fis {
+		name := fi.Name()
+		if fs.names {
+			name, err = fs.keys.decryptName(name)
+			if err != nil {
+				continue
+			}
+		}
+		cfi, err := fs.fileInfo(pathpkg.Join(p, name), name, fi)
+		if err == errNotEncrypted {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, cfi)
+	}
+	if fs.names {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	}
+	return list, nil
+}
+
+func (fs *cryptFS) newReader(f vfs.ReadSeekCloser) (*reader, error) {
+	h, hb, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := fs.keys.content(h)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{f: f, h: h, ad: hb, aead: aead, seg: -1}, nil
+}
+
+// reader decrypts one segment at a time.
+type reader struct {
+	f      vfs.ReadSeekCloser
+	h      *header
+	ad     []byte
+	aead   cipher.AEAD
+	offset int64
+	seg    int64 // index of the segment in buf, -1 if none
+	buf    []byte
+	cbuf   []byte
+}
+
+// load decrypts segment i into buf.
+func (r *reader) load(i int64) error {
+	if r.seg == i {
+		return nil
+	}
+	r.seg = -1
+	if _, err := r.f.Seek(r.h.segmentOffset(i), io.SeekStart); err != nil {
+		return err
+	}
+	n := r.h.segmentLen(i) + tagSize
+	if cap(r.cbuf) < n {
+		r.cbuf = make([]byte, n)
+	}
+	cb := r.cbuf[:n]
+	if _, err := io.ReadFull(r.f, cb); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, "reading segment")
+	}
+	buf, err := r.aead.Open(r.buf[:0], segmentNonce(i), cb, r.ad)
+	if err != nil {
+		return errAuth
+	}
+	r.buf, r.seg = buf, i
+	return nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.h.size {
+		if r.h.size == 0 && r.seg != 0 {
+			// authenticate the header of empty files.
+			if err := r.load(0); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	i := r.offset / int64(r.h.segmentSize)
+	if err := r.load(i); err != nil {
+		return 0, err
+	}
+	n := copy(p, r.buf[r.offset-i*int64(r.h.segmentSize):])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.h.size
+	default:
+		return 0, errors.New("cryptfs: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("cryptfs: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	return r.f.Close()
+}
+
+// fileInfo reports the plaintext name and size.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
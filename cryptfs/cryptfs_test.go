@@ -0,0 +1,196 @@
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/vfstest"
+)
+
+var testKey = bytes.Repeat([]byte{7}, KeySize)
+
+var testFiles = map[string]string{
+	"a/b.txt":       "bbb",
+	"a/c/long.txt":  strings.Repeat("0123456789", 10),
+	"exact.txt":     strings.Repeat("x", 32),
+	"empty":         "",
+	"top level.txt": "top",
+}
+
+func encryptTree(t *testing.T, opts *Options) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cryptfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	src := vfs.ModeMap(vfs.Map(testFiles), map[string]os.FileMode{"a/b.txt": 0600})
+	if err := EncryptTree(src, "/", dir, testKey, opts); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFS(t *testing.T) {
+	for _, opts := range []*Options{
+		{SegmentSize: 16},
+		{SegmentSize: 16, EncryptNames: true},
+		nil,
+	} {
+		dir := encryptTree(t, opts)
+		fs, err := New(vfs.OS(dir), testKey, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := vfstest.TestFS(fs, "a/b.txt", "a/c/long.txt", "exact.txt", "empty", "top level.txt"); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range testFiles {
+			data, err := vfs.ReadFile(fs, name)
+			if err != nil || string(data) != content {
+				t.Fatalf("%s: unexpected content %q: %v", name, data, err)
+			}
+			fi, err := fs.Stat(name)
+			if err != nil || fi.Size() != int64(len(content)) {
+				t.Fatalf("%s: unexpected size %v: %v", name, fi, err)
+			}
+		}
+		if fi, err := fs.Stat("/a/b.txt"); err != nil || fi.Mode() != 0600 {
+			t.Fatalf("unexpected mode %v: %v", fi, err)
+		}
+
+		// the plaintext names are only on disk without EncryptNames.
+		_, err = os.Stat(filepath.Join(dir, "a", "b.txt"))
+		if plain := err == nil; opts != nil && plain == opts.EncryptNames {
+			t.Fatalf("EncryptNames %v, plain name on disk %v", opts.EncryptNames, plain)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	dir := encryptTree(t, &Options{SegmentSize: 16})
+	fs, err := New(vfs.OS(dir), testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := testFiles["a/c/long.txt"]
+	f, err := fs.Open("/a/c/long.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, off := range []int64{95, 0, 15, 16, 17, 47, 48, 99, 100} {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil || string(data) != content[off:] {
+			t.Fatalf("offset %d: unexpected content %q: %v", off, data, err)
+		}
+	}
+	if pos, err := f.Seek(-10, io.SeekEnd); err != nil || pos != 90 {
+		t.Fatalf("unexpected position %d: %v", pos, err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	dir := encryptTree(t, &Options{SegmentSize: 16})
+	name := filepath.Join(dir, "a", "c", "long.txt")
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := New(vfs.OS(dir), testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the third segment.
+	tampered := append([]byte(nil), data...)
+	tampered[headerSize+2*(16+tagSize)+3] ^= 1
+	if err := ioutil.WriteFile(name, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Open("/a/c/long.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatalf("expected the first segment to be intact: %v", err)
+	}
+	if _, err := f.Seek(40, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(buf); err != errAuth {
+		t.Fatalf("expected authentication failure, got %v", err)
+	}
+	f.Close()
+
+	// a changed size in the header is detected by every segment.
+	tampered = append([]byte(nil), data...)
+	tampered[23]--
+	if err := ioutil.WriteFile(name, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vfs.ReadFile(fs, "/a/c/long.txt"); err == nil {
+		t.Fatal("expected error for a modified header")
+	}
+
+	other, err := New(vfs.OS(dir), bytes.Repeat([]byte{8}, KeySize), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vfs.ReadFile(other, "/a/b.txt"); err == nil {
+		t.Fatal("expected error with the wrong key")
+	}
+	if _, err := New(vfs.OS(dir), []byte("short"), nil); err == nil {
+		t.Fatal("expected error for a short key")
+	}
+}
+
+func TestForeignFiles(t *testing.T) {
+	dir := encryptTree(t, &Options{EncryptNames: true})
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not encrypted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptName("plain", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, enc), []byte("not encrypted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := New(vfs.OS(dir), testKey, &Options{EncryptNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if got := strings.Join(names, ","); got != "a,empty,exact.txt,top level.txt" {
+		t.Fatalf("unexpected listing %s", got)
+	}
+	if _, err := fs.Stat("/plain"); err == nil {
+		t.Fatal("expected error for a file which is not encrypted")
+	}
+
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, strings.NewReader("too long"), 3, testKey, nil); err == nil {
+		t.Fatal("expected error for input longer than size")
+	}
+	if err := Encrypt(&buf, strings.NewReader("ab"), 3, testKey, nil); err == nil {
+		t.Fatal("expected error for input shorter than size")
+	}
+}
@@ -0,0 +1,192 @@
+package cryptfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The encrypted file format is a header followed by segments. Every segment
+// holds SegmentSize bytes of plaintext, except the last which may be
+// shorter, sealed with AES-256-GCM. The header is the additional data of
+// every segment so it is authenticated with the first segment read. Empty
+// files have a single empty segment.
+//
+//	magic        8 bytes "vfscrypt"
+//	version      1 byte
+//	reserved     3 bytes
+//	segment size 4 bytes big endian
+//	size         8 bytes big endian plaintext size
+//	file id      16 bytes random
+const (
+	magic      = "vfscrypt"
+	version    = 1
+	headerSize = 40
+	fileIDSize = 16
+	tagSize    = 16
+
+	// DefaultSegmentSize is the segment size used when none is given.
+	DefaultSegmentSize = 64 * 1024
+	maxSegmentSize     = 16 * 1024 * 1024
+
+	// KeySize is the size of the master key.
+	KeySize = 32
+)
+
+var (
+	errNotEncrypted = errors.New("not an encrypted file")
+	errAuth         = errors.New("message authentication failed")
+)
+
+type header struct {
+	segmentSize uint32
+	size        int64
+	fileID      [fileIDSize]byte
+}
+
+func (h *header) marshal() []byte {
+	b := make([]byte, headerSize)
+	copy(b, magic)
+	b[8] = version
+	binary.BigEndian.PutUint32(b[12:], h.segmentSize)
+	binary.BigEndian.PutUint64(b[16:], uint64(h.size))
+	copy(b[24:], h.fileID[:])
+	return b
+}
+
+func parseHeader(b []byte) (*header, error) {
+	if len(b) != headerSize || string(b[:8]) != magic {
+		return nil, errNotEncrypted
+	}
+	if b[8] != version {
+		return nil, errors.Errorf("unsupported format version %d", b[8])
+	}
+	h := &header{
+		segmentSize: binary.BigEndian.Uint32(b[12:]),
+		size:        int64(binary.BigEndian.Uint64(b[16:])),
+	}
+	if h.segmentSize == 0 || h.segmentSize > maxSegmentSize || h.size < 0 {
+		return nil, errors.New("invalid header")
+	}
+	copy(h.fileID[:], b[24:])
+	return h, nil
+}
+
+func readHeader(r io.Reader) (*header, []byte, error) {
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, errNotEncrypted
+		}
+		return nil, nil, err
+	}
+	h, err := parseHeader(b)
+	return h, b, err
+}
+
+// segments returns the number of segments in the file.
+func (h *header) segments() int64 {
+	n := (h.size + int64(h.segmentSize) - 1) / int64(h.segmentSize)
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// segmentLen returns the plaintext length of segment i.
+func (h *header) segmentLen(i int64) int {
+	if rest := h.size - i*int64(h.segmentSize); rest < int64(h.segmentSize) {
+		return int(rest)
+	}
+	return int(h.segmentSize)
+}
+
+// segmentOffset returns the offset of segment i in the encrypted file.
+func (h *header) segmentOffset(i int64) int64 {
+	return headerSize + i*int64(h.segmentSize+tagSize)
+}
+
+func segmentNonce(i int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(i))
+	return nonce
+}
+
+// keys are derived from the master key with HMAC-SHA256.
+type keys struct {
+	master  []byte
+	names   cipher.AEAD
+	nameMAC []byte
+}
+
+func newKeys(key []byte) (*keys, error) {
+	if len(key) != KeySize {
+		return nil, errors.Errorf("cryptfs: key must be %d bytes, got %d", KeySize, len(key))
+	}
+	k := &keys{master: append([]byte(nil), key...)}
+	aead, err := newAEAD(derive(key, []byte("names")))
+	if err != nil {
+		return nil, err
+	}
+	k.names = aead
+	k.nameMAC = derive(key, []byte("name nonces"))
+	return k, nil
+}
+
+func derive(key, info []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(info)
+	return m.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// content returns the cipher for the contents of the file with header h.
+func (k *keys) content(h *header) (cipher.AEAD, error) {
+	return newAEAD(derive(k.master, append([]byte("content"), h.fileID[:]...)))
+}
+
+var nameEncoding = base64.RawURLEncoding
+
+// encryptName deterministically encrypts a single path element. The nonce
+// is derived from the name so the same name always encrypts to the same
+// string, which is what makes lookups possible.
+func (k *keys) encryptName(name string) string {
+	nonce := derive(k.nameMAC, []byte(name))[:12]
+	return nameEncoding.EncodeToString(k.names.Seal(nonce, nonce, []byte(name), nil))
+}
+
+func (k *keys) decryptName(s string) (string, error) {
+	b, err := nameEncoding.DecodeString(s)
+	if err != nil || len(b) < 12+tagSize {
+		return "", errNotEncrypted
+	}
+	name, err := k.names.Open(nil, b[:12], b[12:], nil)
+	if err != nil {
+		return "", errAuth
+	}
+	if !bytes.Equal(derive(k.nameMAC, name)[:12], b[:12]) {
+		return "", errAuth
+	}
+	return string(name), nil
+}
+
+func newFileID() ([fileIDSize]byte, error) {
+	var id [fileIDSize]byte
+	_, err := io.ReadFull(rand.Reader, id[:])
+	return id, err
+}
@@ -0,0 +1,167 @@
+package cryptfs
+
+import (
+	"io"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Encrypt reads exactly size bytes from r and writes them to w in the
+// encrypted format.
+func Encrypt(w io.Writer, r io.Reader, size int64, key []byte, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if size < 0 {
+		return errors.New("cryptfs: negative size")
+	}
+	segmentSize := opts.SegmentSize
+	if segmentSize == 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if segmentSize < 0 || segmentSize > maxSegmentSize {
+		return errors.Errorf("cryptfs: invalid segment size %d", segmentSize)
+	}
+	k, err := newKeys(key)
+	if err != nil {
+		return err
+	}
+	h := &header{segmentSize: uint32(segmentSize), size: size}
+	if h.fileID, err = newFileID(); err != nil {
+		return err
+	}
+	aead, err := k.content(h)
+	if err != nil {
+		return err
+	}
+	hb := h.marshal()
+	if _, err := w.Write(hb); err != nil {
+		return err
+	}
+	buf := make([]byte, segmentSize)
+	var out []byte
+	for i := int64(0); i < h.segments(); i++ {
+		n := h.segmentLen(i)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return errors.Wrap(err, "cryptfs: reading plaintext")
+		}
+		out = aead.Seal(out[:0], segmentNonce(i), buf[:n], hb)
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	// the size is part of the header so the input must end here.
+	if n, _ := r.Read(buf[:1]); n != 0 {
+		return errors.Errorf("cryptfs: input is longer than %d bytes", size)
+	}
+	return nil
+}
+
+// EncryptName returns the encrypted form of a single file or directory
+// name as stored with EncryptNames.
+func EncryptName(name string, key []byte) (string, error) {
+	k, err := newKeys(key)
+	if err != nil {
+		return "", err
+	}
+	return k.encryptName(name), nil
+}
+
+// EncryptTree writes an encrypted copy of the tree at root in fs into the OS
+// directory destDir, creating it if needed. Modes and modification times are
+// preserved, symbolic links and special files are skipped.
+func EncryptTree(fs vfs.FileSystem, root, destDir string, key []byte, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	k, err := newKeys(key)
+	if err != nil {
+		return err
+	}
+	root = pathpkg.Clean("/" + root)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	var dirs []string
+	var dirInfos []os.FileInfo
+	err = vfs.Walk(root, fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		var elems []string
+		if rel != "" {
+			elems = strings.Split(rel, "/")
+		}
+		if opts.EncryptNames {
+			for i, e := range elems {
+				elems[i] = k.encryptName(e)
+			}
+		}
+		dest := filepath.Join(append([]string{destDir}, elems...)...)
+
+		switch {
+		case fi.IsDir():
+			if err := os.MkdirAll(dest, 0700); err != nil {
+				return err
+			}
+			// modes and times of directories are set last, after their
+			// contents have been written.
+			dirs = append(dirs, dest)
+			dirInfos = append(dirInfos, fi)
+		case fi.Mode().IsRegular():
+			if err := encryptFile(fs, p, dest, fi, key, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], dirInfos[i].Mode().Perm()); err != nil {
+			return err
+		}
+		if mt := dirInfos[i].ModTime(); !mt.IsZero() {
+			if err := os.Chtimes(dirs[i], mt, mt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encryptFile(fs vfs.FileSystem, p, dest string, fi os.FileInfo, key []byte, opts *Options) error {
+	src, err := fs.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := Encrypt(f, src, fi.Size(), key, opts); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "encrypting %s", p)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dest, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if mt := fi.ModTime(); !mt.IsZero() {
+		return os.Chtimes(dest, mt, mt)
+	}
+	return nil
+}
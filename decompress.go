@@ -0,0 +1,602 @@
+package vfs
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// decompressors maps file name extensions to the formats Decompress
+// understands. ".lzw" files are raw compress/lzw streams (LSB order, 8 bit
+// literals), the format of Unix compress (".Z") is not supported.
+var decompressors = []struct {
+	ext string
+	// open starts decompressing r at the restart point at and calls mark
+	// with the restart points found after it.
+	open func(r *offsetReader, at restartPoint, mark func(restartPoint)) (io.ReadCloser, error)
+}{
+	{".gz", openGzip},
+	{".bz2", single(func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(bzip2.NewReader(r)), nil })},
+	{".z", single(zlib.NewReader)},
+	{".zlib", single(zlib.NewReader)},
+	{".lzw", single(func(r io.Reader) (io.ReadCloser, error) { return lzw.NewReader(r, lzw.LSB, 8), nil })},
+}
+
+// single adapts the reader of a format which can only be decompressed from
+// the beginning.
+func single(open func(io.Reader) (io.ReadCloser, error)) func(*offsetReader, restartPoint, func(restartPoint)) (io.ReadCloser, error) {
+	return func(r *offsetReader, _ restartPoint, _ func(restartPoint)) (io.ReadCloser, error) {
+		return open(r)
+	}
+}
+
+// DecompressOptions configures Decompress.
+type DecompressOptions struct {
+	// SideBySide keeps the compressed files visible next to the
+	// decompressed ones, by default they are hidden.
+	SideBySide bool
+	// SeekCache is the number of recently decompressed bytes each open
+	// file keeps for seeking backwards, it defaults to 1 MiB. Seeking to
+	// other offsets restarts decompression at the closest restart point
+	// before them.
+	SeekCache int
+}
+
+const decompressChunkSize = 64 * 1024
+
+// Decompress wraps fs and exposes every regular file ending in .gz, .bz2,
+// .z, .zlib or .lzw as a decompressed virtual file with the extension
+// stripped. Existing files always take precedence over virtual files, a
+// compressed file is left visible when its stripped name is taken.
+//
+// The uncompressed size is computed by decompressing the file, the gzip
+// trailer is not used as it only holds the size modulo 2^32 of the last
+// member. Stat and Lstat compute it right away and return decompression
+// errors, the entries returned by ReadDir compute it the first time Size is
+// called and report a size of 0 if that fails.
+//
+// Seeking restarts decompression at restart points, the start of the file
+// and the start of every gzip member, such as the blocks written by bgzip.
+// Restart points found while reading a file are remembered together with
+// its size for as long as the compressed file keeps its size and
+// modification time, so a single member file is decompressed again from
+// the beginning for every seek beyond the SeekCache.
+func Decompress(fs FileSystem, opts *DecompressOptions) FileSystem {
+	if opts == nil {
+		opts = &DecompressOptions{}
+	}
+	return &decompressFS{fs: fs, opts: *opts, indexes: map[string]*decompressIndex{}}
+}
+
+type decompressFS struct {
+	fs   FileSystem
+	opts DecompressOptions
+
+	mu      sync.Mutex
+	indexes map[string]*decompressIndex
+}
+
+// decompressIndex is what is known about the contents of a compressed file,
+// valid while it has the same size and modification time. It is guarded by
+// the mutex of the decompressFS.
+type decompressIndex struct {
+	csize   int64
+	modTime time.Time
+	size    int64          // -1 until the file has been decompressed to the end
+	points  []restartPoint // sorted, the first is the start of the file
+}
+
+// restartPoint is an offset in a compressed file where decompression can
+// start and the offset it has in the decompressed contents.
+type restartPoint struct {
+	coff, uoff int64
+}
+
+// index returns the index of the compressed file cpath.
+func (fs *decompressFS) index(cpath string, cfi os.FileInfo) *decompressIndex {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	ix := fs.indexes[cpath]
+	if ix == nil || ix.csize != cfi.Size() || !ix.modTime.Equal(cfi.ModTime()) {
+		ix = &decompressIndex{csize: cfi.Size(), modTime: cfi.ModTime(), size: -1, points: []restartPoint{{}}}
+		fs.indexes[cpath] = ix
+	}
+	return ix
+}
+
+// mark adds p to the restart points of ix.
+func (fs *decompressFS) mark(ix *decompressIndex, p restartPoint) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	i := sort.Search(len(ix.points), func(i int) bool { return ix.points[i].uoff >= p.uoff })
+	if i < len(ix.points) && ix.points[i].uoff == p.uoff {
+		return
+	}
+	ix.points = append(ix.points, restartPoint{})
+	copy(ix.points[i+1:], ix.points[i:])
+	ix.points[i] = p
+}
+
+// restartPoint returns the last restart point of ix at or before uoff.
+func (fs *decompressFS) restartPoint(ix *decompressIndex, uoff int64) restartPoint {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	i := sort.Search(len(ix.points), func(i int) bool { return ix.points[i].uoff > uoff })
+	return ix.points[i-1]
+}
+
+func (fs *decompressFS) String() string {
+	return fmt.Sprintf("decompress(%s)", fs.fs.String())
+}
+
+// compressedExt returns the index in decompressors for name, or -1.
+func compressedExt(name string) int {
+	for i, d := range decompressors {
+		if strings.HasSuffix(name, d.ext) && len(name) > len(d.ext) {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve returns the compressed file behind the virtual file path and the
+// index of its format, or ok false if path is not a virtual file.
+func (fs *decompressFS) resolve(path string) (string, os.FileInfo, int, bool) {
+	if _, err := fs.fs.Lstat(path); err == nil || !os.IsNotExist(err) {
+		return "", nil, 0, false
+	}
+	for i, d := range decompressors {
+		fi, err := fs.fs.Stat(path + d.ext)
+		if err == nil && fi.Mode().IsRegular() {
+			return path + d.ext, fi, i, true
+		}
+	}
+	return "", nil, 0, false
+}
+
+// hidden reports whether the existing file path is hidden because it is
+// shown decompressed.
+func (fs *decompressFS) hidden(path string, fi os.FileInfo) bool {
+	if fs.opts.SideBySide || !fi.Mode().IsRegular() {
+		return false
+	}
+	i := compressedExt(path)
+	if i < 0 {
+		return false
+	}
+	if _, err := fs.fs.Lstat(strings.TrimSuffix(path, decompressors[i].ext)); err == nil || !os.IsNotExist(err) {
+		return false
+	}
+	// an earlier extension wins for the same stripped name.
+	cpath, _, j, ok := fs.resolve(strings.TrimSuffix(path, decompressors[i].ext))
+	return ok && j == i && cpath == path
+}
+
+func (fs *decompressFS) stat(op, path string, f func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	fi, err := f(path)
+	if err == nil {
+		if fs.hidden(path, fs.statFollow(path, fi)) {
+			return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+		}
+		return fi, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	cpath, cfi, i, ok := fs.resolve(path)
+	if !ok {
+		return nil, err
+	}
+	vfi := fs.virtualInfo(cpath, pathpkg.Base(path), cfi, i)
+	if err := vfi.load(); err != nil {
+		return nil, err
+	}
+	return vfi, nil
+}
+
+// statFollow returns the Stat result for path if fi is a symbolic link.
+func (fs *decompressFS) statFollow(path string, fi os.FileInfo) os.FileInfo {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fi
+	}
+	if sfi, err := fs.fs.Stat(path); err == nil {
+		return sfi
+	}
+	return fi
+}
+
+func (fs *decompressFS) Stat(path string) (os.FileInfo, error) {
+	return fs.stat("stat", path, fs.fs.Stat)
+}
+
+func (fs *decompressFS) Lstat(path string) (os.FileInfo, error) {
+	return fs.stat("lstat", path, fs.fs.Lstat)
+}
+
+func (fs *decompressFS) ReadDir(path string) ([]os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(fis))
+	for _, fi := range fis {
+		names[fi.Name()] = true
+	}
+	var list []os.FileInfo
+	virtual := map[string]bool{}
+	for _, fi := range fis {
+		i := compressedExt(fi.Name())
+		if i < 0 {
+			list = append(list, fi)
+			continue
+		}
+		cfi := fs.statFollow(pathpkg.Join(path, fi.Name()), fi)
+		name := strings.TrimSuffix(fi.Name(), decompressors[i].ext)
+		if !cfi.Mode().IsRegular() || names[name] || virtual[name] || fs.earlierExt(names, name, i) {
+			list = append(list, fi)
+			continue
+		}
+		virtual[name] = true
+		list = append(list, fs.virtualInfo(pathpkg.Join(path, fi.Name()), name, cfi, i))
+		if fs.opts.SideBySide {
+			list = append(list, fi)
+		}
+	}
+	sort.Sort(byName(list))
+	return list, nil
+}
+
+// earlierExt reports whether name is also compressed with a format which
+// comes before i in decompressors.
+func (fs *decompressFS) earlierExt(names map[string]bool, name string, i int) bool {
+	for _, d := range decompressors[:i] {
+		if names[name+d.ext] {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *decompressFS) Open(path string) (ReadSeekCloser, error) {
+	path = pathpkg.Clean("/" + path)
+	if fi, err := fs.fs.Stat(path); err == nil {
+		if fs.hidden(path, fi) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return fs.fs.Open(path)
+	}
+	cpath, cfi, i, ok := fs.resolve(path)
+	if !ok {
+		return fs.fs.Open(path)
+	}
+	f, err := fs.fs.Open(cpath)
+	if err != nil {
+		return nil, err
+	}
+	vfi := fs.virtualInfo(cpath, pathpkg.Base(path), cfi, i)
+	max := fs.opts.SeekCache
+	if max <= 0 {
+		max = 1 << 20
+	}
+	r := &decompressReader{
+		fs:        fs,
+		ix:        fs.index(cpath, cfi),
+		r:         newOffsetReader(f),
+		path:      path,
+		open:      decompressors[i].open,
+		size:      vfi.sizeErr,
+		chunks:    map[int64][]byte{},
+		maxChunks: (max + decompressChunkSize - 1) / decompressChunkSize,
+	}
+	return r, nil
+}
+
+func (fs *decompressFS) virtualInfo(cpath, name string, cfi os.FileInfo, i int) *decompressedFI {
+	return &decompressedFI{
+		name:    name,
+		mode:    cfi.Mode(),
+		modTime: cfi.ModTime(),
+		compute: func() (int64, error) { return fs.computeSize(cpath, cfi, i) },
+	}
+}
+
+// computeSize decompresses cpath from its last known restart point to find
+// its uncompressed size, successful results are cached.
+func (fs *decompressFS) computeSize(cpath string, cfi os.FileInfo, i int) (int64, error) {
+	ix := fs.index(cpath, cfi)
+	fs.mu.Lock()
+	size := ix.size
+	p := ix.points[len(ix.points)-1]
+	fs.mu.Unlock()
+	if size >= 0 {
+		return size, nil
+	}
+	f, err := fs.fs.Open(cpath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := newOffsetReader(f)
+	if err := r.seek(p.coff); err != nil {
+		return 0, &os.PathError{Op: "decompress", Path: cpath, Err: err}
+	}
+	d, err := decompressors[i].open(r, p, func(p restartPoint) { fs.mark(ix, p) })
+	if err != nil {
+		return 0, &os.PathError{Op: "decompress", Path: cpath, Err: err}
+	}
+	defer d.Close()
+	n, err := io.Copy(ioutil.Discard, d)
+	if err != nil {
+		return 0, &os.PathError{Op: "decompress", Path: cpath, Err: err}
+	}
+	fs.mu.Lock()
+	ix.size = p.uoff + n
+	fs.mu.Unlock()
+	return p.uoff + n, nil
+}
+
+// offsetReader reads a compressed file and keeps track of the offset of the
+// next byte. It is an io.ByteReader so the decompressors do not read ahead
+// and the offset is where they stopped.
+type offsetReader struct {
+	f   ReadSeekCloser
+	br  *bufio.Reader
+	off int64
+}
+
+func newOffsetReader(f ReadSeekCloser) *offsetReader {
+	return &offsetReader{f: f, br: bufio.NewReader(f)}
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.br.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *offsetReader) ReadByte() (byte, error) {
+	c, err := r.br.ReadByte()
+	if err == nil {
+		r.off++
+	}
+	return c, err
+}
+
+func (r *offsetReader) seek(off int64) error {
+	if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	r.br.Reset(r.f)
+	r.off = off
+	return nil
+}
+
+// gzipReader decompresses the members of a gzip file one after another and
+// marks the start of each as a restart point.
+type gzipReader struct {
+	r    *offsetReader
+	z    *gzip.Reader
+	uoff int64
+	mark func(restartPoint)
+}
+
+func openGzip(r *offsetReader, at restartPoint, mark func(restartPoint)) (io.ReadCloser, error) {
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	z.Multistream(false)
+	return &gzipReader{r: r, z: z, uoff: at.uoff, mark: mark}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	for {
+		n, err := g.z.Read(p)
+		g.uoff += int64(n)
+		if err != io.EOF {
+			return n, err
+		}
+		next := restartPoint{coff: g.r.off, uoff: g.uoff}
+		if err := g.z.Reset(g.r); err != nil {
+			return n, err
+		}
+		g.z.Multistream(false)
+		g.mark(next)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (g *gzipReader) Close() error {
+	return g.z.Close()
+}
+
+// decompressedFI is the file info of a virtual file, its size is computed on
+// first use.
+type decompressedFI struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+
+	once    sync.Once
+	compute func() (int64, error)
+	size    int64
+	err     error
+}
+
+// load computes the size if needed and returns the error of doing so.
+func (fi *decompressedFI) load() error {
+	fi.once.Do(func() { fi.size, fi.err = fi.compute() })
+	return fi.err
+}
+
+func (fi *decompressedFI) sizeErr() (int64, error) {
+	err := fi.load()
+	return fi.size, err
+}
+
+func (fi *decompressedFI) Name() string       { return fi.name }
+func (fi *decompressedFI) Mode() os.FileMode  { return fi.mode }
+func (fi *decompressedFI) ModTime() time.Time { return fi.modTime }
+func (fi *decompressedFI) IsDir() bool        { return false }
+func (fi *decompressedFI) Sys() interface{}   { return nil }
+func (fi *decompressedFI) Size() int64 {
+	fi.load()
+	return fi.size
+}
+
+// decompressReader decompresses in chunks of decompressChunkSize. The most
+// recent chunks are kept, first in first out, so seeking back to them does
+// not restart decompression. Other chunks are decompressed from the closest
+// restart point before them.
+type decompressReader struct {
+	fs   *decompressFS
+	ix   *decompressIndex
+	r    *offsetReader
+	path string
+	open func(*offsetReader, restartPoint, func(restartPoint)) (io.ReadCloser, error)
+	size func() (int64, error)
+
+	d      io.ReadCloser // nil until the first read
+	pos    int64         // decompressed offset of d
+	eof    bool
+	offset int64
+
+	chunks    map[int64][]byte
+	order     []int64
+	maxChunks int
+}
+
+// restart starts decompression at the restart point p and skips to the
+// decompressed offset start.
+func (r *decompressReader) restart(p restartPoint, start int64) error {
+	if r.d != nil {
+		r.d.Close()
+		r.d = nil
+	}
+	if err := r.r.seek(p.coff); err != nil {
+		return err
+	}
+	d, err := r.open(r.r, p, func(p restartPoint) { r.fs.mark(r.ix, p) })
+	if err != nil {
+		return err
+	}
+	r.d, r.pos, r.eof = d, p.uoff, false
+	n, err := io.CopyN(ioutil.Discard, d, start-p.uoff)
+	r.pos += n
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// chunk returns chunk number n, decompressing up to it if needed.
+func (r *decompressReader) chunk(n int64) ([]byte, error) {
+	if c, ok := r.chunks[n]; ok {
+		return c, nil
+	}
+	start := n * decompressChunkSize
+	// continue decompressing unless a restart point is closer.
+	if p := r.fs.restartPoint(r.ix, start); r.d == nil || r.pos > start || p.uoff > r.pos {
+		if err := r.restart(p, start); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if r.eof {
+			return nil, io.EOF
+		}
+		buf := make([]byte, decompressChunkSize)
+		m, err := io.ReadFull(r.d, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			r.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		idx := r.pos / decompressChunkSize
+		r.pos += int64(m)
+		r.keep(idx, buf[:m])
+		if idx == n {
+			if m == 0 {
+				return nil, io.EOF
+			}
+			return buf[:m], nil
+		}
+	}
+}
+
+func (r *decompressReader) keep(idx int64, c []byte) {
+	if r.maxChunks <= 0 {
+		return
+	}
+	if len(r.order) >= r.maxChunks {
+		delete(r.chunks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.chunks[idx] = c
+	r.order = append(r.order, idx)
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	n := r.offset / decompressChunkSize
+	c, err := r.chunk(n)
+	if err != nil {
+		if err != io.EOF {
+			err = &os.PathError{Op: "read", Path: r.path, Err: err}
+		}
+		return 0, err
+	}
+	off := int(r.offset - n*decompressChunkSize)
+	if off >= len(c) {
+		return 0, io.EOF
+	}
+	m := copy(p, c[off:])
+	r.offset += int64(m)
+	return m, nil
+}
+
+func (r *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("decompress: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("decompress: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *decompressReader) Close() error {
+	if r.d != nil {
+		r.d.Close()
+	}
+	return r.r.f.Close()
+}
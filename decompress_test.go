@@ -0,0 +1,326 @@
+package vfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// "hello bzip2\n" compressed with bzip2 -9.
+var testBzip2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xab, 0x6b,
+	0xa1, 0xf1, 0x00, 0x00, 0x02, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10,
+	0x00, 0x12, 0x64, 0xc0, 0x10, 0x20, 0x00, 0x31, 0x00, 0xd3, 0x4d, 0x04,
+	0x00, 0x1e, 0xa3, 0xef, 0x4e, 0x51, 0xa2, 0x07, 0x8b, 0xb9, 0x22, 0x9c,
+	0x28, 0x48, 0x55, 0xb5, 0xd0, 0xf8, 0x80,
+}
+
+func compressString(t *testing.T, ext, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch ext {
+	case ".gz":
+		w = gzip.NewWriter(&buf)
+	case ".z":
+		w = zlib.NewWriter(&buf)
+	case ".lzw":
+		w = lzw.NewWriter(&buf, lzw.LSB, 8)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func decompressTestFS(t *testing.T, large string) FileSystem {
+	return Map(map[string]string{
+		"logs/a.json.gz": compressString(t, ".gz", `{"a":1}`),
+		"logs/b.txt.bz2": string(testBzip2),
+		"logs/c.z":       compressString(t, ".z", "zlib data"),
+		"logs/d.lzw":     compressString(t, ".lzw", "lzw data"),
+		"logs/plain.txt": "plain",
+		"taken.gz":       compressString(t, ".gz", "hidden"),
+		"taken":          "real file",
+		"large.bin.gz":   compressString(t, ".gz", large),
+		"large.bin.z":    compressString(t, ".z", large),
+	})
+}
+
+func randomString(n int) string {
+	r := rand.New(rand.NewSource(1))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = "abcdefgh"[r.Intn(8)]
+	}
+	return string(b)
+}
+
+func TestDecompress(t *testing.T) {
+	large := randomString(300 * 1024)
+	fs := Decompress(decompressTestFS(t, large), nil)
+	expected := map[string]string{
+		"logs/a.json":    `{"a":1}`,
+		"logs/b.txt":     "hello bzip2\n",
+		"logs/c":         "zlib data",
+		"logs/d":         "lzw data",
+		"logs/plain.txt": "plain",
+		"taken":          "real file",
+		"taken.gz":       compressString(t, ".gz", "hidden"),
+		"large.bin":      large,
+		"large.bin.z":    compressString(t, ".z", large),
+	}
+	var n int
+	for name, content := range expected {
+		n++
+		data, err := ReadFile(fs, name)
+		if err != nil || string(data) != content {
+			t.Fatalf("%s: unexpected content (%d bytes): %v", name, len(data), err)
+		}
+		fi, err := fs.Stat(name)
+		if err != nil || fi.Size() != int64(len(content)) {
+			t.Fatalf("%s: unexpected size %v: %v", name, fi, err)
+		}
+	}
+	checkDecompressWalk(t, fs, n)
+	for _, name := range []string{"logs/a.json.gz", "logs/b.txt.bz2", "large.bin.gz"} {
+		if _, err := fs.Stat(name); err == nil {
+			t.Fatalf("%s: expected the compressed file to be hidden", name)
+		}
+	}
+	fis, err := fs.ReadDir("/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fi.Name())
+	}
+	if s := strings.Join(got, ","); s != "a.json,b.txt,c,d,plain.txt" {
+		t.Fatalf("unexpected listing %s", s)
+	}
+
+	// a file which is not in the format fails on read.
+	fs = Decompress(Map(map[string]string{"notgz.gz": "not compressed"}), nil)
+	if _, err := ReadFile(fs, "notgz"); err == nil {
+		t.Fatal("expected error for a corrupt file")
+	}
+}
+
+func TestDecompressSizeError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decompress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := compressString(t, ".z", "zlib data")
+	name := filepath.Join(dir, "x.z")
+	if err := ioutil.WriteFile(name, []byte(data[:len(data)-3]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := Decompress(OS(dir), nil)
+	if fi, err := fs.Stat("/x"); err == nil {
+		t.Fatalf("expected error for a truncated file, got size %d", fi.Size())
+	}
+	f, err := fs.Open("/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err == nil {
+		t.Fatal("expected seek to the end of a truncated file to fail")
+	}
+	f.Close()
+
+	// the failure is not cached.
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/x")
+	if err != nil || fi.Size() != int64(len("zlib data")) {
+		t.Fatalf("unexpected size %v: %v", fi, err)
+	}
+}
+
+func TestDecompressSideBySide(t *testing.T) {
+	fs := Decompress(decompressTestFS(t, "large"), &DecompressOptions{SideBySide: true})
+	fis, err := fs.ReadDir("/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fi.Name())
+	}
+	if s := strings.Join(got, ","); s != "a.json,a.json.gz,b.txt,b.txt.bz2,c,c.z,d,d.lzw,plain.txt" {
+		t.Fatalf("unexpected listing %s", s)
+	}
+	if data, err := ReadFile(fs, "/logs/a.json.gz"); err != nil || string(data) != compressString(t, ".gz", `{"a":1}`) {
+		t.Fatalf("unexpected compressed content: %v", err)
+	}
+	checkDecompressWalk(t, fs, 14)
+}
+
+func TestDecompressSeek(t *testing.T) {
+	large := randomString(300 * 1024)
+	for _, cache := range []int{1, 128 * 1024, 0} {
+		fs := Decompress(decompressTestFS(t, large), &DecompressOptions{SeekCache: cache})
+		f, err := fs.Open("/large.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		for _, off := range []int64{250000, 10, 70000, 65535, 65536, 200000, 0, 300*1024 - 50} {
+			if _, err := f.Seek(off, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			n, err := io.ReadFull(f, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatalf("offset %d: %v", off, err)
+			}
+			if string(buf[:n]) != large[off:off+int64(n)] || (n < 100 && off+int64(n) != int64(len(large))) {
+				t.Fatalf("offset %d: unexpected content", off)
+			}
+		}
+		if pos, err := f.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(large))-10 {
+			t.Fatalf("unexpected position %d: %v", pos, err)
+		}
+		rest, err := ioutil.ReadAll(f)
+		if err != nil || string(rest) != large[len(large)-10:] {
+			t.Fatalf("unexpected tail %q: %v", rest, err)
+		}
+		f.Close()
+	}
+
+	// seeking to the end decompresses the file to compute its size.
+	fs := Decompress(Map(map[string]string{"x.z": compressString(t, ".z", large)}), nil)
+	f, err := fs.Open("/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if pos, err := f.Seek(-1, io.SeekEnd); err != nil || pos != int64(len(large))-1 {
+		t.Fatalf("unexpected position %d: %v", pos, err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != large[len(large)-1:] {
+		t.Fatalf("unexpected tail %q: %v", b, err)
+	}
+}
+
+// seekRecordFS records the offsets its files are seeked to.
+type seekRecordFS struct {
+	FileSystem
+	offsets *[]int64
+}
+
+func (fs seekRecordFS) Open(path string) (ReadSeekCloser, error) {
+	f, err := fs.FileSystem.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return seekRecordFile{f, fs.offsets}, nil
+}
+
+type seekRecordFile struct {
+	ReadSeekCloser
+	offsets *[]int64
+}
+
+func (f seekRecordFile) Seek(offset int64, whence int) (int64, error) {
+	*f.offsets = append(*f.offsets, offset)
+	return f.ReadSeekCloser.Seek(offset, whence)
+}
+
+func TestDecompressMembers(t *testing.T) {
+	// the size of a concatenated gzip file is not the one in the trailer
+	// of its last member.
+	fs := Decompress(Map(map[string]string{
+		"x.gz": compressString(t, ".gz", "first member\n") + compressString(t, ".gz", "second\n"),
+	}), nil)
+	if fi, err := fs.Stat("/x"); err != nil || fi.Size() != 20 {
+		t.Fatalf("unexpected stat %v: %v", fi, err)
+	}
+	if data, err := ReadFile(fs, "/x"); err != nil || string(data) != "first member\nsecond\n" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	// seeking restarts decompression at the closest member.
+	var members []string
+	var data string
+	for i := 0; i < 3; i++ {
+		m := randomString(100 * 1024)
+		members = append(members, compressString(t, ".gz", m))
+		data += m
+	}
+	var offsets []int64
+	fs = Decompress(seekRecordFS{Map(map[string]string{"y.gz": strings.Join(members, "")}), &offsets}, &DecompressOptions{SeekCache: 1})
+	if fi, err := fs.Stat("/y"); err != nil || fi.Size() != int64(len(data)) {
+		t.Fatalf("unexpected stat %v: %v", fi, err)
+	}
+	f, err := fs.Open("/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	offsets = nil
+	buf := make([]byte, 100)
+	for _, off := range []int64{270000, 150000, 10} {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(f, buf); err != nil || string(buf) != data[off:off+100] {
+			t.Fatalf("offset %d: unexpected content: %v", off, err)
+		}
+	}
+	want := []int64{int64(len(members[0]) + len(members[1])), int64(len(members[0])), 0}
+	if len(offsets) != len(want) {
+		t.Fatalf("unexpected seeks %v, want %v", offsets, want)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Fatalf("unexpected seeks %v, want %v", offsets, want)
+		}
+	}
+}
+
+// checkDecompressWalk walks fs and checks that the sizes in directory
+// listings, Stat and the file contents agree.
+func checkDecompressWalk(t *testing.T, fs FileSystem, files int) {
+	t.Helper()
+	var n int
+	err := Walk("/", fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		n++
+		sfi, err := fs.Stat(p)
+		if err != nil {
+			return err
+		}
+		data, err := ReadFile(fs, p)
+		if err != nil {
+			return err
+		}
+		if fi.Size() != int64(len(data)) || sfi.Size() != fi.Size() {
+			t.Fatalf("%s: sizes %d and %d, read %d bytes", p, fi.Size(), sfi.Size(), len(data))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != files {
+		t.Fatalf("expected %d files, walked %d", files, n)
+	}
+}
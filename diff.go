@@ -0,0 +1,417 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	pathpkg "path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DiffKind describes how a path differs between two file systems. The
+// Added, Removed and TypeChanged kinds are exclusive, the others can be
+// combined.
+type DiffKind int
+
+const (
+	DiffAdded DiffKind = 1 << iota
+	DiffRemoved
+	DiffTypeChanged
+	DiffModeChanged
+	DiffSizeChanged
+	DiffContentChanged
+)
+
+var diffKindNames = []string{"added", "removed", "type", "mode", "size", "content"}
+
+func (k DiffKind) String() string {
+	var s []string
+	for i, name := range diffKindNames {
+		if k&(1<<uint(i)) != 0 {
+			s = append(s, name)
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, "|")
+}
+
+// CompareMode selects how the contents of regular files that have the same
+// size are compared.
+type CompareMode int
+
+const (
+	// CompareBytes reads both files and compares them byte by byte.
+	CompareBytes CompareMode = iota
+	// CompareHash compares digests of both files, see DiffOptions.Hash.
+	CompareHash
+	// CompareSizeOnly never reads file contents.
+	CompareSizeOnly
+)
+
+// DiffOptions configures DiffWalk. The zero value compares modes and
+// contents byte by byte.
+type DiffOptions struct {
+	Compare CompareMode
+	// Hash returns the hash used by CompareHash. If it is nil SHA-256
+	// digests are compared, using those of Hasher file systems.
+	Hash func() hash.Hash
+	// IgnoreMode disables reporting of DiffModeChanged.
+	IgnoreMode bool
+}
+
+// A Difference is a single path that differs between two file systems. A is
+// the FileInfo from the first file system and B from the second, one of them
+// is nil for added and removed paths.
+type Difference struct {
+	Path string
+	Kind DiffKind
+	A, B os.FileInfo
+}
+
+// DiffFunc is called by DiffWalk for each difference found. If it returns an
+// error the walk is stopped and the error is returned by DiffWalk.
+type DiffFunc func(d Difference) error
+
+// Diff compares the trees of a and b and returns all differences in lexical
+// order using the default DiffOptions.
+func Diff(a, b FileSystem) ([]Difference, error) {
+	var ds []Difference
+	err := DiffWalk(a, b, "/", nil, func(d Difference) error {
+		ds = append(ds, d)
+		return nil
+	})
+	return ds, err
+}
+
+// DiffWalk walks the trees rooted at root in a and b in lexical order and
+// calls fn for every path that differs. Only one directory level of each
+// tree is held in memory at a time. The contents of an added or removed
+// directory are reported as added or removed paths as well. Like Walk,
+// DiffWalk does not follow symbolic links, a link whose destination changed
+// is reported as DiffContentChanged.
+func DiffWalk(a, b FileSystem, root string, opts *DiffOptions, fn DiffFunc) error {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	d := differ{a: a, b: b, opts: opts, fn: fn}
+	root = pathpkg.Clean("/" + root)
+	afi, err := d.lstat(a, root)
+	if err != nil {
+		return err
+	}
+	bfi, err := d.lstat(b, root)
+	if err != nil {
+		return err
+	}
+	return d.diff(root, afi, bfi)
+}
+
+// WriteDiff writes the differences between a and b below root to w in a
+// format similar to git's unified diff headers.
+func WriteDiff(w io.Writer, a, b FileSystem, root string, opts *DiffOptions) error {
+	return DiffWalk(a, b, root, opts, func(d Difference) error {
+		return writeDifference(w, d)
+	})
+}
+
+func writeDifference(w io.Writer, d Difference) error {
+	var buf bytes.Buffer
+	ap, bp := "a"+d.Path, "b"+d.Path
+	fmt.Fprintf(&buf, "diff %s %s\n", ap, bp)
+	switch {
+	case d.Kind&DiffAdded != 0:
+		fmt.Fprintf(&buf, "new %s mode %s\n", fileKind(d.B), fileMode(d.B))
+		if d.B.Mode().IsRegular() {
+			fmt.Fprintf(&buf, "--- /dev/null\n+++ %s\n", bp)
+		}
+	case d.Kind&DiffRemoved != 0:
+		fmt.Fprintf(&buf, "deleted %s mode %s\n", fileKind(d.A), fileMode(d.A))
+		if d.A.Mode().IsRegular() {
+			fmt.Fprintf(&buf, "--- %s\n+++ /dev/null\n", ap)
+		}
+	case d.Kind&DiffTypeChanged != 0:
+		fmt.Fprintf(&buf, "old %s mode %s\n", fileKind(d.A), fileMode(d.A))
+		fmt.Fprintf(&buf, "new %s mode %s\n", fileKind(d.B), fileMode(d.B))
+	default:
+		if d.Kind&DiffModeChanged != 0 {
+			fmt.Fprintf(&buf, "old mode %s\nnew mode %s\n", fileMode(d.A), fileMode(d.B))
+		}
+		if d.Kind&(DiffSizeChanged|DiffContentChanged) != 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", ap, bp)
+			if d.Kind&DiffSizeChanged != 0 {
+				fmt.Fprintf(&buf, "size %d => %d\n", d.A.Size(), d.B.Size())
+			}
+			fmt.Fprintf(&buf, "Files %s and %s differ\n", ap, bp)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func fileKind(fi os.FileInfo) string {
+	switch {
+	case fi.IsDir():
+		return "directory"
+	case fi.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case fi.Mode().IsRegular():
+		return "file"
+	}
+	return "special file"
+}
+
+func fileMode(fi os.FileInfo) string {
+	return fmt.Sprintf("%06o", uint32(fi.Mode().Perm()|fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)))
+}
+
+type differ struct {
+	a, b FileSystem
+	opts *DiffOptions
+	fn   DiffFunc
+}
+
+// lstat returns nil and no error for paths that does not exist.
+func (d *differ) lstat(fs FileSystem, p string) (os.FileInfo, error) {
+	fi, err := fs.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "diff %s", fs)
+	}
+	return fi, nil
+}
+
+func (d *differ) diff(p string, afi, bfi os.FileInfo) error {
+	switch {
+	case afi == nil && bfi == nil:
+		return nil
+	case afi == nil:
+		return d.report(d.b, p, DiffAdded)
+	case bfi == nil:
+		return d.report(d.a, p, DiffRemoved)
+	}
+
+	if afi.Mode()&os.ModeType != bfi.Mode()&os.ModeType {
+		if err := d.fn(Difference{Path: p, Kind: DiffTypeChanged, A: afi, B: bfi}); err != nil {
+			return err
+		}
+		if afi.IsDir() {
+			if err := d.reportChildren(d.a, p, DiffRemoved); err != nil {
+				return err
+			}
+		}
+		if bfi.IsDir() {
+			return d.reportChildren(d.b, p, DiffAdded)
+		}
+		return nil
+	}
+
+	var kind DiffKind
+	if !d.opts.IgnoreMode && afi.Mode() != bfi.Mode() {
+		kind |= DiffModeChanged
+	}
+	if afi.Mode().IsRegular() {
+		if afi.Size() != bfi.Size() {
+			kind |= DiffSizeChanged
+		} else if d.opts.Compare != CompareSizeOnly {
+			same, err := d.sameContent(p)
+			if err != nil {
+				return err
+			}
+			if !same {
+				kind |= DiffContentChanged
+			}
+		}
+	} else if afi.Mode()&os.ModeSymlink != 0 {
+		same, err := d.sameLink(p)
+		if err != nil {
+			return err
+		}
+		if !same {
+			kind |= DiffContentChanged
+		}
+	}
+	if kind != 0 {
+		if err := d.fn(Difference{Path: p, Kind: kind, A: afi, B: bfi}); err != nil {
+			return err
+		}
+	}
+	if !afi.IsDir() {
+		return nil
+	}
+
+	names, err := d.mergedNames(p)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		cp := pathpkg.Join(p, name)
+		afi, err := d.lstat(d.a, cp)
+		if err != nil {
+			return err
+		}
+		bfi, err := d.lstat(d.b, cp)
+		if err != nil {
+			return err
+		}
+		if err := d.diff(cp, afi, bfi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergedNames returns the sorted union of the names in directory p of both
+// file systems.
+func (d *differ) mergedNames(p string) ([]string, error) {
+	an, err := readDirNames(d.a, p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "diff %s", d.a)
+	}
+	bn, err := readDirNames(d.b, p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "diff %s", d.b)
+	}
+	names := make([]string, 0, len(an)+len(bn))
+	i, j := 0, 0
+	for i < len(an) || j < len(bn) {
+		switch {
+		case j >= len(bn) || i < len(an) && an[i] < bn[j]:
+			names = append(names, an[i])
+			i++
+		case i >= len(an) || bn[j] < an[i]:
+			names = append(names, bn[j])
+			j++
+		default:
+			names = append(names, an[i])
+			i++
+			j++
+		}
+	}
+	return names, nil
+}
+
+// report calls fn with kind for p and everything below it in fs.
+func (d *differ) report(fs FileSystem, p string, kind DiffKind) error {
+	return Walk(p, fs, func(wp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "diff %s", fs)
+		}
+		return d.fn(d.difference(wp, kind, info))
+	})
+}
+
+// reportChildren is like report but excludes p itself.
+func (d *differ) reportChildren(fs FileSystem, p string, kind DiffKind) error {
+	return Walk(p, fs, func(wp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "diff %s", fs)
+		}
+		if wp == p {
+			return nil
+		}
+		return d.fn(d.difference(wp, kind, info))
+	})
+}
+
+func (d *differ) difference(p string, kind DiffKind, fi os.FileInfo) Difference {
+	if kind == DiffAdded {
+		return Difference{Path: p, Kind: kind, B: fi}
+	}
+	return Difference{Path: p, Kind: kind, A: fi}
+}
+
+// sameContent reports whether the regular file p has the same content in
+// both file systems.
+func (d *differ) sameContent(p string) (bool, error) {
+	if d.opts.Compare == CompareHash {
+		ah, err := d.hash(d.a, p)
+		if err != nil {
+			return false, err
+		}
+		bh, err := d.hash(d.b, p)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(ah, bh), nil
+	}
+
+	af, err := d.a.Open(p)
+	if err != nil {
+		return false, errors.Wrapf(err, "diff %s", d.a)
+	}
+	defer af.Close()
+	bf, err := d.b.Open(p)
+	if err != nil {
+		return false, errors.Wrapf(err, "diff %s", d.b)
+	}
+	defer bf.Close()
+	return sameReader(af, bf)
+}
+
+// sameLink reports whether the symbolic link p has the same destination in
+// both file systems.
+func (d *differ) sameLink(p string) (bool, error) {
+	adest, err := Readlink(d.a, p)
+	if err != nil {
+		return false, errors.Wrapf(err, "diff %s", d.a)
+	}
+	bdest, err := Readlink(d.b, p)
+	if err != nil {
+		return false, errors.Wrapf(err, "diff %s", d.b)
+	}
+	return adest == bdest, nil
+}
+
+func (d *differ) hash(fs FileSystem, p string) ([]byte, error) {
+	newHash := d.opts.Hash
+	if newHash == nil {
+		sum, err := Hash(fs, p, HashSHA256)
+		if err != nil {
+			return nil, errors.Wrapf(err, "diff %s", fs)
+		}
+		return sum, nil
+	}
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "diff %s", fs)
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "diff %s: %s", fs, p)
+	}
+	return h.Sum(nil), nil
+}
+
+// sameReader reports whether a and b produce the same bytes.
+func sameReader(a, b io.Reader) (bool, error) {
+	const size = 32 * 1024
+	abuf := make([]byte, size)
+	bbuf := make([]byte, size)
+	for {
+		an, aerr := io.ReadFull(a, abuf)
+		bn, berr := io.ReadFull(b, bbuf)
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+		aeof := aerr == io.EOF || aerr == io.ErrUnexpectedEOF
+		beof := berr == io.EOF || berr == io.ErrUnexpectedEOF
+		if aerr != nil && !aeof {
+			return false, aerr
+		}
+		if berr != nil && !beof {
+			return false, berr
+		}
+		if aeof || beof {
+			return aeof == beof, nil
+		}
+	}
+}
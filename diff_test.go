@@ -0,0 +1,183 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := Map(map[string]string{
+		"same.txt":       "same",
+		"changed.txt":    "aaaa",
+		"grown.txt":      "a",
+		"removed.txt":    "gone",
+		"dir/file":       "file",
+		"dir/sub/x":      "x",
+		"typechange":     "was a file",
+		"oldtree/a/b":    "b",
+		"modechange.txt": "mode",
+	})
+	b := ModeMap(Map(map[string]string{
+		"same.txt":          "same",
+		"changed.txt":       "bbbb",
+		"grown.txt":         "abc",
+		"added.txt":         "new",
+		"dir/file":          "file",
+		"dir/sub/x":         "x",
+		"typechange/inner":  "now a dir",
+		"newtree/c/d":       "d",
+		"modechange.txt":    "mode",
+		"oldtree/a/b/inner": "b",
+	}), map[string]os.FileMode{
+		"modechange.txt": 0755,
+	})
+
+	ds, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range ds {
+		got = append(got, fmt.Sprintf("%s %s", d.Kind, d.Path))
+	}
+	want := []string{
+		"added /added.txt",
+		"content /changed.txt",
+		"size /grown.txt",
+		"mode /modechange.txt",
+		"added /newtree",
+		"added /newtree/c",
+		"added /newtree/c/d",
+		"type /oldtree/a/b",
+		"added /oldtree/a/b/inner",
+		"removed /removed.txt",
+		"type /typechange",
+		"added /typechange/inner",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	ds, err = Diff(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 0 {
+		t.Fatalf("expected no differences, got %v", ds)
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	a := Map(map[string]string{"f": "aaaa", "g": "g"})
+	b := ModeMap(Map(map[string]string{"f": "bbbb", "g": "g"}), map[string]os.FileMode{"g": 0700})
+
+	for _, tt := range []struct {
+		opts DiffOptions
+		want string
+	}{
+		{DiffOptions{}, "content /f,mode /g"},
+		{DiffOptions{Compare: CompareHash}, "content /f,mode /g"},
+		{DiffOptions{Compare: CompareHash, Hash: md5.New}, "content /f,mode /g"},
+		{DiffOptions{Compare: CompareSizeOnly}, "mode /g"},
+		{DiffOptions{IgnoreMode: true}, "content /f"},
+	} {
+		var got []string
+		opts := tt.opts
+		err := DiffWalk(a, b, "/", &opts, func(d Difference) error {
+			got = append(got, fmt.Sprintf("%s %s", d.Kind, d.Path))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, strings.Join(got, ","), tt.want)
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	a := Map(map[string]string{"f": "aaaa", "old": "x"})
+	b := Map(map[string]string{"f": "bbbbb", "dir/new": "y"})
+	var buf bytes.Buffer
+	if err := WriteDiff(&buf, a, b, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `diff a/dir b/dir
+new directory mode 000755
+diff a/dir/new b/dir/new
+new file mode 000444
+--- /dev/null
++++ b/dir/new
+diff a/f b/f
+--- a/f
++++ b/f
+size 4 => 5
+Files a/f and b/f differ
+diff a/old b/old
+deleted file mode 000444
+--- a/old
++++ /dev/null
+`
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestSameReader(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	for _, tt := range []struct {
+		a, b string
+		same bool
+	}{
+		{"", "", true},
+		{"a", "a", true},
+		{"a", "", false},
+		{"", "a", false},
+		{long, long, true},
+		{long, long + "x", false},
+		{long + "y", long + "x", false},
+	} {
+		same, err := sameReader(strings.NewReader(tt.a), strings.NewReader(tt.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same != tt.same {
+			t.Errorf("sameReader(%d bytes, %d bytes) = %v", len(tt.a), len(tt.b), same)
+		}
+	}
+}
+
+func TestDiffSymlink(t *testing.T) {
+	dirs := make([]string, 2)
+	for i, dest := range []string{"target-a", "target-b"} {
+		dir, err := ioutil.TempDir("", "diff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"same", "changed"} {
+			d := "same-target"
+			if name == "changed" {
+				d = dest
+			}
+			if err := os.Symlink(d, filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs[i] = dir
+	}
+	ds, err := Diff(OS(dirs[0]), OS(dirs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].Path != "/changed" || ds[0].Kind != DiffContentChanged {
+		t.Fatalf("expected only /changed to differ in content, got %v", ds)
+	}
+}
@@ -0,0 +1,270 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DynamicFile describes a file generated by a DynamicHandler. Its contents
+// are either Content or, if Open is set, read from the ReadSeekCloser Open
+// returns, in which case Size must be set too.
+type DynamicFile struct {
+	Content []byte
+	Open    func() (ReadSeekCloser, error)
+	Size    int64
+	// Mode defaults to 0444.
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// DynamicHandler generates the file at path. It is called for Stat and
+// ReadDir as well as for Open. Returning an error satisfying os.IsNotExist
+// declines the path so the next matching pattern is tried.
+type DynamicHandler func(path string) (*DynamicFile, error)
+
+// DynamicFS is a FileSystem of files generated on demand by handlers
+// registered per path or pattern. Directories are inferred from the
+// registered patterns.
+type DynamicFS struct {
+	mu       sync.RWMutex
+	exact    map[string]DynamicHandler
+	patterns []dynamicPattern
+}
+
+type dynamicPattern struct {
+	pattern string
+	elems   []string
+	handler DynamicHandler
+}
+
+// NewDynamic returns an empty DynamicFS.
+func NewDynamic() *DynamicFS {
+	return &DynamicFS{exact: map[string]DynamicHandler{}}
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// Handle registers h for pattern, which is either a path such as
+// "/version.json" or a path.Match pattern such as "/reports/*.json". Exact
+// paths take precedence over patterns, which are tried in the order they
+// were registered. Files matched by a wildcard are not listed by ReadDir
+// and directories matched by a wildcard element exist for any name. When a
+// wildcard file pattern also matches a directory of another pattern the
+// file wins, unless its handler declines the path. Handle panics if the
+// pattern is malformed or already registered, or if an exact path would
+// also be a directory of another pattern.
+func (fs *DynamicFS) Handle(pattern string, h DynamicHandler) {
+	pattern = pathpkg.Clean("/" + pattern)
+	if pattern == "/" {
+		panic("vfs: dynamic handler for the root directory")
+	}
+	if _, err := pathpkg.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("vfs: invalid dynamic pattern %q: %v", pattern, err))
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	elems := strings.Split(pattern[1:], "/")
+	exact := !isPattern(pattern)
+	for _, p := range fs.patterns {
+		if p.pattern == pattern {
+			panic(fmt.Sprintf("vfs: multiple dynamic handlers for %s", pattern))
+		}
+		if exact && prefixMatch(p.elems, elems) || !isPattern(p.pattern) && prefixMatch(elems, p.elems) {
+			panic(fmt.Sprintf("vfs: dynamic handlers for %s and %s conflict, one is a directory of the other", p.pattern, pattern))
+		}
+	}
+	if exact {
+		fs.exact[pattern] = h
+	}
+	fs.patterns = append(fs.patterns, dynamicPattern{
+		pattern: pattern,
+		elems:   elems,
+		handler: h,
+	})
+}
+
+// HandleContent registers a handler which always returns content.
+func (fs *DynamicFS) HandleContent(path string, content []byte, modTime time.Time) {
+	fs.Handle(path, func(string) (*DynamicFile, error) {
+		return &DynamicFile{Content: content, ModTime: modTime}, nil
+	})
+}
+
+func (fs *DynamicFS) String() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fmt.Sprintf("dynamic(%d handlers)", len(fs.patterns))
+}
+
+// file returns the generated file at path.
+func (fs *DynamicFS) file(path string) (*DynamicFile, error) {
+	fs.mu.RLock()
+	h, ok := fs.exact[path]
+	patterns := fs.patterns
+	fs.mu.RUnlock()
+	if ok {
+		return fs.call(h, path)
+	}
+	for _, p := range patterns {
+		if !isPattern(p.pattern) {
+			continue
+		}
+		if m, _ := pathpkg.Match(p.pattern, path); !m {
+			continue
+		}
+		f, err := fs.call(p.handler, path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, os.ErrNotExist
+}
+
+func (fs *DynamicFS) call(h DynamicHandler, path string) (*DynamicFile, error) {
+	f, err := h(path)
+	if err == nil && f == nil {
+		err = os.ErrNotExist
+	}
+	return f, err
+}
+
+// isDir reports whether path is a parent directory of a registered
+// pattern.
+func (fs *DynamicFS) isDir(path string) bool {
+	if path == "/" {
+		return true
+	}
+	elems := strings.Split(path[1:], "/")
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	for _, p := range fs.patterns {
+		if prefixMatch(p.elems, elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixMatch reports whether the pattern elements pattern start with
+// elements matching elems and have at least one more element.
+func prefixMatch(pattern, elems []string) bool {
+	if len(pattern) <= len(elems) {
+		return false
+	}
+	for i, e := range elems {
+		if m, _ := pathpkg.Match(pattern[i], e); !m {
+			return false
+		}
+	}
+	return true
+}
+
+func (fs *DynamicFS) Open(path string) (ReadSeekCloser, error) {
+	path = pathpkg.Clean("/" + path)
+	f, err := fs.file(path)
+	if err != nil {
+		if os.IsNotExist(err) && fs.isDir(path) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("is a directory")}
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	if f.Open != nil {
+		return f.Open()
+	}
+	return nopCloser{bytes.NewReader(f.Content)}, nil
+}
+
+func (fs *DynamicFS) Lstat(path string) (os.FileInfo, error) {
+	return fs.Stat(path)
+}
+
+func (fs *DynamicFS) Stat(path string) (os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	f, err := fs.file(path)
+	if err == nil {
+		return newDynamicFI(pathpkg.Base(path), f), nil
+	}
+	if os.IsNotExist(err) && fs.isDir(path) {
+		return dirInfo(pathpkg.Base(path)), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+}
+
+func (fs *DynamicFS) ReadDir(path string) ([]os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	if path != "/" {
+		if _, err := fs.file(path); err == nil {
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: errors.New("not a directory")}
+		}
+	}
+	if !fs.isDir(path) {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+	var elems []string
+	if path != "/" {
+		elems = strings.Split(path[1:], "/")
+	}
+	fs.mu.RLock()
+	patterns := fs.patterns
+	fs.mu.RUnlock()
+	seen := map[string]bool{}
+	var list []os.FileInfo
+	for _, p := range patterns {
+		if !prefixMatch(p.elems, elems) {
+			continue
+		}
+		name := p.elems[len(elems)]
+		if isPattern(name) || seen[name] {
+			continue
+		}
+		child := pathpkg.Join(path, name)
+		fi, err := fs.Stat(child)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		list = append(list, fi)
+	}
+	sort.Sort(byName(list))
+	return list, nil
+}
+
+func newDynamicFI(name string, f *DynamicFile) os.FileInfo {
+	fi := dynamicFI{name: name, size: f.Size, mode: f.Mode, modTime: f.ModTime}
+	if f.Open == nil {
+		fi.size = int64(len(f.Content))
+	}
+	if fi.mode == 0 {
+		fi.mode = 0444
+	}
+	return fi
+}
+
+// dynamicFI is the file info of a generated file.
+type dynamicFI struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi dynamicFI) Name() string       { return fi.name }
+func (fi dynamicFI) Size() int64        { return fi.size }
+func (fi dynamicFI) Mode() os.FileMode  { return fi.mode }
+func (fi dynamicFI) ModTime() time.Time { return fi.modTime }
+func (fi dynamicFI) IsDir() bool        { return false }
+func (fi dynamicFI) Sys() interface{}   { return nil }
@@ -0,0 +1,193 @@
+package vfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDynamic(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	site := Map(map[string]string{"index.html": "index", "about/index.html": "about"})
+
+	dfs := NewDynamic()
+	dfs.HandleContent("/version.json", []byte(`{"version":"1.0"}`), modTime)
+	var checks int
+	dfs.Handle("healthz", func(string) (*DynamicFile, error) {
+		checks++
+		return &DynamicFile{Content: []byte(fmt.Sprintf("ok %d", checks))}, nil
+	})
+	dfs.Handle("/meta/sitemap.txt", func(string) (*DynamicFile, error) {
+		var urls []string
+		err := Walk("/", site, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				urls = append(urls, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		content := strings.Join(urls, "\n")
+		return &DynamicFile{
+			Open: func() (ReadSeekCloser, error) {
+				return nopCloser{strings.NewReader(content)}, nil
+			},
+			Size: int64(len(content)),
+			Mode: 0644,
+		}, nil
+	})
+	dfs.Handle("/users/*/profile.json", func(p string) (*DynamicFile, error) {
+		user := strings.Split(p, "/")[2]
+		if user == "nobody" {
+			return nil, os.ErrNotExist
+		}
+		return &DynamicFile{Content: []byte(`{"user":"` + user + `"}`)}, nil
+	})
+	dfs.Handle("/reports/*.csv", func(p string) (*DynamicFile, error) {
+		return &DynamicFile{Content: []byte(p)}, nil
+	})
+
+	for name, content := range map[string]string{
+		"/version.json":             `{"version":"1.0"}`,
+		"/meta/sitemap.txt":         "/about/index.html\n/index.html",
+		"/users/bob/profile.json":   `{"user":"bob"}`,
+		"/reports/2020.csv":         "/reports/2020.csv",
+		"healthz":                   "ok 2",
+		"/users/alice/profile.json": `{"user":"alice"}`,
+	} {
+		fi, err := dfs.Stat(name)
+		if err != nil || fi.Size() != int64(len(content)) || fi.IsDir() {
+			t.Fatalf("%s: unexpected file info %v: %v", name, fi, err)
+		}
+		data, err := ReadFile(dfs, name)
+		if err != nil || string(data) != content {
+			t.Fatalf("%s: unexpected content %q: %v", name, data, err)
+		}
+	}
+	if fi, err := dfs.Stat("/version.json"); err != nil || !fi.ModTime().Equal(modTime) || fi.Mode() != 0444 {
+		t.Fatalf("unexpected file info %v: %v", fi, err)
+	}
+	if fi, err := dfs.Stat("/meta/sitemap.txt"); err != nil || fi.Mode() != 0644 {
+		t.Fatalf("unexpected file info %v: %v", fi, err)
+	}
+	for _, name := range []string{"/", "/meta", "/users", "/users/bob", "/reports"} {
+		if fi, err := dfs.Stat(name); err != nil || !fi.IsDir() {
+			t.Fatalf("%s: expected a directory, got %v: %v", name, fi, err)
+		}
+	}
+	for _, name := range []string{"/missing", "/users/nobody/profile.json", "/reports/x.txt", "/version.json/x"} {
+		if _, err := dfs.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected not exist error, got %v", name, err)
+		}
+	}
+	if _, err := dfs.Open("/meta"); err == nil {
+		t.Fatal("expected error opening a directory")
+	}
+	if _, err := dfs.ReadDir("/version.json"); err == nil {
+		t.Fatal("expected error listing a file")
+	}
+
+	for dir, expected := range map[string]string{
+		"/":        "healthz,meta,reports,users,version.json",
+		"/users":   "",
+		"/reports": "",
+		"/meta":    "sitemap.txt",
+	} {
+		fis, err := dfs.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if got := strings.Join(names, ","); got != expected {
+			t.Fatalf("%s: unexpected listing %s", dir, got)
+		}
+	}
+}
+
+func TestDynamicNameSpace(t *testing.T) {
+	dfs := NewDynamic()
+	dfs.HandleContent("/version.json", []byte("1.0"), time.Time{})
+
+	ns := NewNameSpace()
+	ns.Bind("/", Map(map[string]string{"index.html": "index"}), "/", BindReplace)
+	ns.Bind("/", dfs, "/", BindAfter)
+	ns.Bind("/api", dfs, "/", BindReplace)
+
+	for _, name := range []string{"/version.json", "/api/version.json"} {
+		f, err := ns.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(data) != "1.0" {
+			t.Fatalf("%s: unexpected content %q: %v", name, data, err)
+		}
+	}
+	fis, err := ns.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if got := strings.Join(names, ","); got != "api,index.html,version.json" {
+		t.Fatalf("unexpected listing %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for a duplicate pattern")
+		}
+	}()
+	dfs.HandleContent("version.json", nil, time.Time{})
+}
+
+func TestDynamicConflict(t *testing.T) {
+	for _, tt := range [][2]string{
+		{"/a", "/a/b"},
+		{"/a/b", "/a"},
+		{"/a", "/*/b.json"},
+		{"/users/*/profile.json", "/users/bob"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s, %s: expected panic for conflicting handlers", tt[0], tt[1])
+				}
+			}()
+			dfs := NewDynamic()
+			dfs.HandleContent(tt[0], nil, time.Time{})
+			dfs.HandleContent(tt[1], nil, time.Time{})
+		}()
+	}
+
+	// a wildcard file pattern takes precedence over a directory unless its
+	// handler declines the path.
+	dfs := NewDynamic()
+	dfs.Handle("/data/*", func(p string) (*DynamicFile, error) {
+		if p == "/data/dir" {
+			return nil, os.ErrNotExist
+		}
+		return &DynamicFile{Content: []byte(p)}, nil
+	})
+	dfs.HandleContent("/data/file/inner", []byte("inner"), time.Time{})
+	dfs.HandleContent("/data/dir/inner", []byte("inner"), time.Time{})
+	if fi, err := dfs.Stat("/data/file"); err != nil || fi.IsDir() {
+		t.Fatalf("expected /data/file to be a file: %v", err)
+	}
+	if _, err := dfs.ReadDir("/data/file"); err == nil {
+		t.Fatal("expected ReadDir to fail for a file")
+	}
+	if fi, err := dfs.Stat("/data/dir"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected /data/dir to be a directory: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SyncMode selects how Export decides that a file already present in the
+// destination directory is unchanged and does not have to be copied again.
+type SyncMode int
+
+const (
+	// SyncNone always copies every file.
+	SyncNone SyncMode = iota
+	// SyncSizeModTime skips files with equal size and modification time
+	// (compared with one second precision).
+	SyncSizeModTime
+	// SyncHash skips files with equal size and SHA-256 digest.
+	SyncHash
+)
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	Sync SyncMode
+	// Delete removes files and directories from the destination which
+	// does not exist in the source.
+	Delete bool
+	// Atomic writes the whole tree into a temporary directory next to the
+	// destination and renames it into place when everything has been
+	// written. Extraneous files are always removed in atomic mode.
+	Atomic bool
+}
+
+// Export copies the directory tree rooted at srcPath in fs into the OS
+// directory destDir, creating it if needed. File modes (as reported by fs, so
+// ModeMap overrides apply) and modification times are preserved and symbolic
+// links are recreated using Readlink. Files with a zero ModTime get the
+// current time. Other special files are skipped.
+func Export(fs FileSystem, srcPath, destDir string, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	srcPath = pathpkg.Clean("/" + srcPath)
+	fi, err := fs.Stat(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "export %s", srcPath)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("export %s: not a directory", srcPath)
+	}
+	if !opts.Atomic {
+		e := &exporter{fs: fs, opts: opts, src: srcPath, dest: destDir, prev: destDir}
+		return e.export()
+	}
+
+	parent, base := filepath.Split(filepath.Clean(destDir))
+	if parent == "" {
+		parent = "."
+	}
+	tmp, err := ioutil.TempDir(parent, "."+base+".export")
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	e := &exporter{fs: fs, opts: opts, src: srcPath, dest: tmp}
+	if _, err := os.Stat(destDir); err == nil {
+		e.prev = destDir
+	}
+	if err := e.export(); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if e.prev == "" {
+		if err := os.Rename(tmp, destDir); err != nil {
+			os.RemoveAll(tmp)
+			return errors.Wrap(err, "export")
+		}
+		return nil
+	}
+	old, err := ioutil.TempDir(parent, "."+base+".old")
+	if err != nil {
+		os.RemoveAll(tmp)
+		return errors.Wrap(err, "export")
+	}
+	old = filepath.Join(old, base)
+	if err := os.Rename(destDir, old); err != nil {
+		os.RemoveAll(tmp)
+		os.RemoveAll(filepath.Dir(old))
+		return errors.Wrap(err, "export")
+	}
+	if err := os.Rename(tmp, destDir); err != nil {
+		os.Rename(old, destDir)
+		os.RemoveAll(tmp)
+		os.RemoveAll(filepath.Dir(old))
+		return errors.Wrap(err, "export")
+	}
+	return os.RemoveAll(filepath.Dir(old))
+}
+
+// exporter copies the tree at src into dest. Unchanged files are looked up
+// in prev which is equal to dest unless an atomic export is made.
+type exporter struct {
+	fs   FileSystem
+	opts *ExportOptions
+	src  string
+	dest string
+	prev string
+
+	dirs []exportedDir
+}
+
+// exportedDir is a directory which gets its mode and times set after all of
+// its contents have been written.
+type exportedDir struct {
+	path string
+	fi   os.FileInfo
+}
+
+func (e *exporter) export() error {
+	err := Walk(e.src, e.fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "export %s", p)
+		}
+		rel := filepath.FromSlash(strings.TrimPrefix(p, e.src))
+		target := filepath.Join(e.dest, rel)
+		var prev string
+		if e.prev != "" {
+			prev = filepath.Join(e.prev, rel)
+		}
+		switch {
+		case fi.IsDir():
+			return e.dir(p, target, fi)
+		case fi.Mode()&os.ModeSymlink != 0:
+			return e.symlink(p, target, fi)
+		case fi.Mode().IsRegular():
+			return e.file(p, target, prev, fi)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		d := e.dirs[i]
+		if err := os.Chmod(d.path, d.fi.Mode().Perm()); err != nil {
+			return errors.Wrap(err, "export")
+		}
+		if err := chtimes(d.path, d.fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exporter) dir(p, target string, fi os.FileInfo) error {
+	if dfi, err := os.Lstat(target); err == nil && !dfi.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return errors.Wrap(err, "export")
+		}
+	}
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	// make sure the directory is writable while its contents is exported.
+	if err := os.Chmod(target, 0700); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	e.dirs = append(e.dirs, exportedDir{target, fi})
+	if !e.opts.Delete || e.dest != e.prev {
+		return nil
+	}
+
+	keep := make(map[string]bool)
+	names, err := readDirNames(e.fs, p)
+	if err != nil {
+		return errors.Wrapf(err, "export %s", p)
+	}
+	for _, name := range names {
+		keep[name] = true
+	}
+	dfis, err := ioutil.ReadDir(target)
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	for _, dfi := range dfis {
+		if !keep[dfi.Name()] {
+			if err := os.RemoveAll(filepath.Join(target, dfi.Name())); err != nil {
+				return errors.Wrap(err, "export")
+			}
+		}
+	}
+	return nil
+}
+
+func (e *exporter) symlink(p, target string, fi os.FileInfo) error {
+	dst, err := Readlink(e.fs, p)
+	if err != nil {
+		return errors.Wrapf(err, "export %s", p)
+	}
+	if cur, err := os.Readlink(target); err == nil && cur == dst {
+		return nil
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	return errors.Wrap(os.Symlink(dst, target), "export")
+}
+
+func (e *exporter) file(p, target, prev string, fi os.FileInfo) error {
+	if prev != "" {
+		same, err := e.unchanged(p, prev, fi)
+		if err != nil {
+			return err
+		}
+		if same {
+			if prev != target {
+				// prev belongs to the live tree of an atomic export, so it
+				// is only linked if its metadata does not have to change.
+				if sameMetadata(prev, fi) && os.Link(prev, target) == nil {
+					return nil
+				}
+				if err := copyOSFile(prev, target); err != nil {
+					return err
+				}
+			}
+			if err := os.Chmod(target, fi.Mode().Perm()); err != nil {
+				return errors.Wrap(err, "export")
+			}
+			return chtimes(target, fi)
+		}
+	}
+
+	if dfi, err := os.Lstat(target); err == nil && !dfi.Mode().IsRegular() {
+		if err := os.RemoveAll(target); err != nil {
+			return errors.Wrap(err, "export")
+		}
+	}
+	r, err := e.fs.Open(p)
+	if err != nil {
+		return errors.Wrapf(err, "export %s", p)
+	}
+	defer r.Close()
+	if err := writeFileAtomic(target, r, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return chtimes(target, fi)
+}
+
+// unchanged reports whether the OS file prev is already up to date with p.
+func (e *exporter) unchanged(p, prev string, fi os.FileInfo) (bool, error) {
+	if e.opts.Sync == SyncNone {
+		return false, nil
+	}
+	pfi, err := os.Lstat(prev)
+	if err != nil || !pfi.Mode().IsRegular() || pfi.Size() != fi.Size() {
+		return false, nil
+	}
+	switch e.opts.Sync {
+	case SyncSizeModTime:
+		return !fi.ModTime().IsZero() && pfi.ModTime().Unix() == fi.ModTime().Unix(), nil
+	case SyncHash:
+		sum, err := Hash(e.fs, p, HashSHA256)
+		if err != nil {
+			return false, errors.Wrapf(err, "export %s", p)
+		}
+		f, err := os.Open(prev)
+		if err != nil {
+			return false, errors.Wrap(err, "export")
+		}
+		defer f.Close()
+		ph := sha256.New()
+		if _, err := io.Copy(ph, f); err != nil {
+			return false, errors.Wrap(err, "export")
+		}
+		return bytes.Equal(sum, ph.Sum(nil)), nil
+	}
+	return false, nil
+}
+
+// sameMetadata reports whether the OS file name already has the permission
+// bits and modification time export would set from fi.
+func sameMetadata(name string, fi os.FileInfo) bool {
+	pfi, err := os.Lstat(name)
+	if err != nil || pfi.Mode().Perm() != fi.Mode().Perm() {
+		return false
+	}
+	return fi.ModTime().IsZero() || pfi.ModTime().Equal(fi.ModTime())
+}
+
+// writeFileAtomic writes the contents of r to a temporary file in the same
+// directory as name and renames it to name.
+func writeFileAtomic(name string, r io.Reader, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "export %s", name)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "export")
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "export")
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "export")
+	}
+	return nil
+}
+
+func copyOSFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	defer f.Close()
+	return writeFileAtomic(dst, f, 0600)
+}
+
+func chtimes(name string, fi os.FileInfo) error {
+	if fi.ModTime().IsZero() {
+		return nil
+	}
+	return errors.Wrap(os.Chtimes(name, fi.ModTime(), fi.ModTime()), "export")
+}
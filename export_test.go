@@ -0,0 +1,237 @@
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mtimeFS sets a fixed ModTime on all FileInfos returned by Stat and Lstat
+// of the wrapped file system.
+type mtimeFS struct {
+	FileSystem
+	t time.Time
+}
+
+type mtimeFI struct {
+	os.FileInfo
+	t time.Time
+}
+
+func (fi mtimeFI) ModTime() time.Time { return fi.t }
+
+func (fs mtimeFS) Lstat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return mtimeFI{fi, fs.t}, nil
+}
+
+func (fs mtimeFS) Stat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return mtimeFI{fi, fs.t}, nil
+}
+
+func assertOSFile(t *testing.T, name, data string, mode os.FileMode) {
+	t.Helper()
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != mode {
+		t.Fatalf("%s: mode %v, want %v", name, fi.Mode(), mode)
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Fatalf("%s: read %q, want %q", name, b, data)
+	}
+}
+
+func TestExport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vfs-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	mtime := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	fs := mtimeFS{ModeMap(Map(map[string]string{
+		"a/b.sh":  "#!/bin/sh",
+		"a/c.txt": "c",
+		"d.txt":   "d",
+	}), map[string]os.FileMode{
+		"a/b.sh": 0755,
+		"a":      os.ModeDir | 0750,
+	}), mtime}
+
+	dest := filepath.Join(tmp, "out")
+	if err := Export(fs, "/", dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	assertOSFile(t, filepath.Join(dest, "a/b.sh"), "#!/bin/sh", 0755)
+	assertOSFile(t, filepath.Join(dest, "a/c.txt"), "c", 0444)
+	assertOSFile(t, filepath.Join(dest, "d.txt"), "d", 0444)
+	fi, err := os.Stat(filepath.Join(dest, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeDir|0750 {
+		t.Fatalf("unexpected directory mode %v", fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected directory modtime %v", fi.ModTime())
+	}
+
+	// a sync with a changed source only rewrites changed files.
+	ioutil.WriteFile(filepath.Join(dest, "extra"), []byte("extra"), 0644)
+	marker := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dest, "d.txt"), marker, marker); err != nil {
+		t.Fatal(err)
+	}
+	fs2 := mtimeFS{Map(map[string]string{
+		"a/c.txt": "C",
+		"d.txt":   "d",
+	}), mtime}
+	if err := Export(fs2, "/", dest, &ExportOptions{Sync: SyncHash, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertOSFile(t, filepath.Join(dest, "a/c.txt"), "C", 0444)
+	assertIsNotExistOS(t, filepath.Join(dest, "a/b.sh"), filepath.Join(dest, "extra"))
+	fi, err = os.Stat(filepath.Join(dest, "d.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected modtime %v", fi.ModTime())
+	}
+
+	// atomic export replaces the whole directory.
+	fs3 := Map(map[string]string{"new": "new"})
+	if err := Export(fs3, "/", dest, &ExportOptions{Atomic: true, Sync: SyncSizeModTime}); err != nil {
+		t.Fatal(err)
+	}
+	assertOSFile(t, filepath.Join(dest, "new"), "new", 0444)
+	assertIsNotExistOS(t, filepath.Join(dest, "d.txt"), filepath.Join(dest, "a"))
+	fis, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Fatalf("expected temporary directories to be removed, got %d entries", len(fis))
+	}
+}
+
+func TestExportSymlink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vfs-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	if err := os.MkdirAll(filepath.Join(src, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "dir/file"), []byte("file"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(src, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	ns := NewNameSpace()
+	ns.Bind("/src", OS(src), "/", BindReplace)
+	dest := filepath.Join(tmp, "dest")
+	if err := Export(ns, "/src", dest, &ExportOptions{Atomic: true}); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := os.Readlink(filepath.Join(dest, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != "dir/file" {
+		t.Fatalf("unexpected link destination %q", dst)
+	}
+	assertOSFile(t, filepath.Join(dest, "dir/file"), "file", 0640)
+}
+
+func assertIsNotExistOS(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := os.Lstat(name); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to not exist: %v", name, err)
+		}
+	}
+}
+
+// failOpenFS fails to open the file at path.
+type failOpenFS struct {
+	FileSystem
+	path string
+}
+
+func (fs failOpenFS) Open(p string) (ReadSeekCloser, error) {
+	if p == fs.path {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrPermission}
+	}
+	return fs.FileSystem.Open(p)
+}
+
+func TestExportAtomicAbort(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vfs-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	files := map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+		"z.txt": "z",
+	}
+	mtime := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	dest := filepath.Join(tmp, "out")
+	if err := Export(mtimeFS{Map(files), mtime}, "/", dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	before := make(map[string]os.FileInfo)
+	for name := range files {
+		fi, err := os.Lstat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[name] = fi
+	}
+
+	// a.txt only changes its metadata and z.txt fails the export after a.txt
+	// has been staged.
+	fs := failOpenFS{mtimeFS{ModeMap(Map(files), map[string]os.FileMode{
+		"a.txt": 0600,
+	}), mtime.Add(time.Hour)}, "/z.txt"}
+	for _, sync := range []SyncMode{SyncHash, SyncSizeModTime} {
+		if err := Export(fs, "/", dest, &ExportOptions{Atomic: true, Sync: sync}); err == nil {
+			t.Fatal("expected export to fail")
+		}
+		for name, data := range files {
+			fi, err := os.Lstat(filepath.Join(dest, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode() != before[name].Mode() || !fi.ModTime().Equal(before[name].ModTime()) {
+				t.Fatalf("%s changed by aborted export: %v %v", name, fi.Mode(), fi.ModTime())
+			}
+			assertOSFile(t, filepath.Join(dest, name), data, before[name].Mode())
+		}
+	}
+	if fis, err := ioutil.ReadDir(tmp); err != nil || len(fis) != 1 {
+		t.Fatalf("expected temporary directories to be removed, got %d entries, %v", len(fis), err)
+	}
+}
@@ -8,11 +8,19 @@ import (
 )
 
 func renamedFileInfo(fi os.FileInfo, name string) os.FileInfo {
-	return renamedFI{fi, name}
+	return keepOSPath(fi, renamedFI{fi, name})
 }
 
 func modeFileInfo(fi os.FileInfo, mode os.FileMode) os.FileInfo {
-	return modeFI{fi, mode}
+	return keepOSPath(fi, modeFI{fi, mode})
+}
+
+// keepOSPath returns wrapped with the OSPath of fi if fi is an OSPather.
+func keepOSPath(fi, wrapped os.FileInfo) os.FileInfo {
+	if op, ok := fi.(OSPather); ok {
+		return osPathFI{wrapped, op.OSPath()}
+	}
+	return wrapped
 }
 
 func mapFileInfo(name, contents string) os.FileInfo {
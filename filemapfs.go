@@ -8,8 +8,10 @@ package vfs // import "github.com/thomasf/vfs"
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	pathpkg "path"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -17,10 +19,12 @@ import (
 )
 
 // FileMap returns a new FileSystem from the provided Map. The Map value
-// specifies the source location of a file. Map keys should be forward
-// slash-separated pathnames and not contain a leading slash.
+// specifies the source location of a file or a directory, directories are
+// browseable recursively. Map keys should be forward slash-separated
+// pathnames and not contain a leading slash. The map is copied and indexed,
+// later changes to it are not reflected by the FileSystem.
 func FileMap(m map[string]string) FileSystem {
-	return filemapFS(m)
+	return filemapFS{newMapIndex(m)}
 }
 
 func SafeFileMap(m map[string]string) FileSystemFunc {
@@ -36,21 +40,43 @@ func SafeFileMap(m map[string]string) FileSystemFunc {
 			_ = fi
 			newm[new] = old
 		}
-		return filemapFS(newm), nil
+		return FileMap(newm), nil
 	}
 }
 
 // filemapFS is the map based implementation of FileSystem
-type filemapFS map[string]string
+type filemapFS struct {
+	*mapIndex
+}
 
 func (fs filemapFS) String() string {
-	return fmt.Sprintf("filemap(%v)", len(fs))
+	return fmt.Sprintf("filemap(%v)", len(fs.files))
 }
 
 func (fs filemapFS) Close() error { return nil }
 
+// osPath returns the OS path of p, which is either a map entry or inside a
+// map entry which is a directory.
+func (fs filemapFS) osPath(p string) (string, bool) {
+	if b, ok := fs.files[filename(p)]; ok {
+		return b, true
+	}
+	p = pathpkg.Clean("/" + p)
+	for dir := pathpkg.Dir(p); dir != "/"; dir = pathpkg.Dir(dir) {
+		b, ok := fs.files[dir[1:]]
+		if !ok {
+			continue
+		}
+		if fi, err := os.Stat(b); err != nil || !fi.IsDir() {
+			return "", false
+		}
+		return filepath.Join(b, filepath.FromSlash(p[len(dir):])), true
+	}
+	return "", false
+}
+
 func (fs filemapFS) Open(p string) (ReadSeekCloser, error) {
-	b, ok := fs[filename(p)]
+	b, ok := fs.osPath(p)
 	if !ok {
 		return nil, os.ErrNotExist
 	}
@@ -72,73 +98,89 @@ func (fs filemapFS) Open(p string) (ReadSeekCloser, error) {
 }
 
 func (fs filemapFS) Lstat(p string) (os.FileInfo, error) {
-	b, ok := fs[filename(p)]
-	if ok {
+	return fs.stat(p, os.Lstat)
+}
 
-		fi, err := os.Lstat(b)
-		if err != nil {
+func (fs filemapFS) Stat(p string) (os.FileInfo, error) {
+	return fs.stat(p, os.Stat)
+}
+
+// stat implements the FileSystem Stat and Lstat methods.
+func (fs filemapFS) stat(p string, f func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	b, ok := fs.osPath(p)
+	if ok {
+		fi, err := f(b)
+		if err == nil {
+			return osPathFI{renamedFI{fi, pathpkg.Base(p)}, b}, nil
+		}
+		// entries below a directory entry create directories which do not
+		// exist in the OS directory.
+		if !os.IsNotExist(err) || !fs.isDir(p) {
 			return nil, err
 		}
-		return osPathFI{renamedFileInfo(fi, b), b}, nil
+		return mapDirInfo(p), nil
 	}
-	ents, _ := fs.ReadDir(p)
-	if len(ents) > 0 {
+	if fs.isDir(p) {
 		return mapDirInfo(p), nil
 	}
 	return nil, os.ErrNotExist
 }
 
-func (fs filemapFS) Stat(p string) (os.FileInfo, error) {
-	return fs.Lstat(p)
-}
-
+// ReadDir lists the entries of the index for p and, if p is a directory
+// entry or inside one, the entries of the OS directory. Map entries take
+// precedence over OS directory entries of the same name.
 func (fs filemapFS) ReadDir(p string) ([]os.FileInfo, error) {
-	p = pathpkg.Clean(p)
-	var ents []string
-	fim := make(map[string]os.FileInfo) // base -> fi
-	for fn, dst := range fs {
-		dir := slashdir(fn)
-		isFile := true
-		var lastBase string
-		for {
-			if dir == p {
-				base := lastBase
-				if isFile {
-					base = pathpkg.Base(fn)
-				}
-				if fim[base] == nil {
-					var fi os.FileInfo
-					if isFile {
-						var err error
-						fi, err = os.Stat(dst)
-						if err != nil {
-							return nil, err
-						}
-						fi = renamedFileInfo(fi, base)
-					} else {
-						fi = mapDirInfo(base)
-					}
-					ents = append(ents, base)
-					fim[base] = fi
-				}
-			}
-			if dir == "/" {
-				break
-			} else {
-				isFile = false
-				lastBase = pathpkg.Base(dir)
-				dir = pathpkg.Dir(dir)
-			}
+	osfis, mapped, err := fs.readOSDir(p)
+	if err != nil {
+		return nil, err
+	}
+	ents, err := fs.readDir(p)
+	if err != nil && !mapped {
+		return nil, err
+	}
+	list := make([]os.FileInfo, 0, len(ents)+len(osfis))
+	names := make(map[string]bool, len(ents))
+	for _, e := range ents {
+		names[e.name] = true
+		if e.dir {
+			list = append(list, mapDirInfo(e.name))
+			continue
 		}
+		dst := fs.files[e.key]
+		fi, err := os.Stat(dst)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, osPathFI{renamedFI{fi, e.name}, dst})
 	}
-	if len(ents) == 0 {
-		return nil, os.ErrNotExist
+	if !mapped {
+		return list, nil
 	}
-
-	sort.Strings(ents)
-	var list []os.FileInfo
-	for _, dir := range ents {
-		list = append(list, fim[dir])
+	for _, fi := range osfis {
+		if !names[fi.Name()] {
+			list = append(list, fi)
+		}
 	}
+	sort.Sort(byName(list))
 	return list, nil
 }
+
+// readOSDir lists the OS directory of p, mapped is false if p is not a
+// directory entry or inside one.
+func (fs filemapFS) readOSDir(p string) (fis []os.FileInfo, mapped bool, err error) {
+	dst, ok := fs.osPath(p)
+	if !ok {
+		return nil, false, nil
+	}
+	if fi, err := os.Stat(dst); err != nil || !fi.IsDir() {
+		return nil, false, nil
+	}
+	fis, err = ioutil.ReadDir(dst)
+	if err != nil {
+		return nil, true, err
+	}
+	for i, fi := range fis {
+		fis[i] = osPathFI{fi, filepath.Join(dst, fi.Name())}
+	}
+	return fis, true, nil
+}
@@ -95,3 +95,98 @@ func TestSafeFileMapFS(t *testing.T) {
 		)
 	}
 }
+
+func BenchmarkFileMapReadDir(b *testing.B) {
+	fs := FileMap(benchMap(20000, func(i int) string { return "test-fixtures/C/animals/cats/cats" }))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.ReadDir("/dir1/sub150"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileMapStatDir(b *testing.B) {
+	fs := FileMap(benchMap(20000, func(i int) string { return "test-fixtures/C/animals/cats/cats" }))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Stat("/dir1/sub150"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFileMapDirs(t *testing.T) {
+	fs := FileMap(map[string]string{
+		"things":         "test-fixtures/B/things",
+		"things/extra":   "test-fixtures/C/animals/cats/cats",
+		"things/wood/x":  "test-fixtures/C/animals/cats/C-cats",
+		"things/new/x":   "test-fixtures/C/animals/cats/C-cats",
+		"animals/dogs":   "test-fixtures/A/animals/dogs",
+		"favicon.ico":    "test-fixtures/C/animals/cats/cats",
+		"missing/nested": "test-fixtures/C/animals/cats/cats",
+	})
+	assertIsDir(t, fs,
+		"/things",
+		"/things/wood",
+		"/things/wood/tree",
+		"/things/new",
+		"/animals",
+		"/animals/dogs",
+	)
+	assertIsRegular(t, fs,
+		"/things/wood/tree/B-tree",
+		"/things/extra",
+		"/things/wood/x",
+		"/things/new/x",
+		"/animals/dogs/A-dogs",
+		"/favicon.ico",
+	)
+	assertIsNotExist(t, fs,
+		"/things/nothing",
+		"/things/wood/tree/nothing",
+		"/favicon.ico/x",
+		"/missing/nested/x",
+	)
+	assertOSPather(t, fs, map[string]string{
+		"/things":                  "test-fixtures/B/things",
+		"/things/wood/tree/B-tree": "test-fixtures/B/things/wood/tree/B-tree",
+		"/animals/dogs/dogs":       "test-fixtures/A/animals/dogs/dogs",
+		"/things/extra":            "test-fixtures/C/animals/cats/cats",
+	})
+	if _, err := fs.Open("/things/wood"); err == nil {
+		t.Fatal("expected error opening a directory")
+	}
+
+	assertWalk(t, fs, `dir : /
+dir : /animals
+dir : /animals/dogs
+file: /animals/dogs/A-dogs
+data: A/animals/dogs/A-dogs
+file: /animals/dogs/dogs
+data: A/animals/dogs/dogs
+file: /favicon.ico
+data: C/animals/cats/cats
+dir : /missing
+file: /missing/nested
+data: C/animals/cats/cats
+dir : /things
+file: /things/extra
+data: C/animals/cats/cats
+dir : /things/new
+file: /things/new/x
+data: C/animals/cats/C-cats
+dir : /things/wood
+dir : /things/wood/table
+file: /things/wood/table/B-table
+data: B/things/wood/table/B-table
+file: /things/wood/table/table
+data: B/things/wood/table/table
+dir : /things/wood/tree
+file: /things/wood/tree/B-tree
+data: B/things/wood/tree/B-tree
+file: /things/wood/tree/tree
+data: B/things/wood/tree/tree
+file: /things/wood/x
+data: C/animals/cats/C-cats`)
+}
@@ -0,0 +1,166 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// FilterFunc reports whether the file at path should be kept, fi is the
+// result of Lstat.
+type FilterFunc func(path string, fi os.FileInfo) bool
+
+// SizeAtMost keeps files which are not directories of at most n bytes.
+func SizeAtMost(n int64) FilterFunc {
+	return func(path string, fi os.FileInfo) bool {
+		return fi.IsDir() || fi.Size() <= n
+	}
+}
+
+// ModifiedSince keeps files which are not directories modified at or after
+// t.
+func ModifiedSince(t time.Time) FilterFunc {
+	return func(path string, fi os.FileInfo) bool {
+		return fi.IsDir() || !fi.ModTime().Before(t)
+	}
+}
+
+// NoDotFiles hides files and directories whose names start with a dot.
+func NoDotFiles(path string, fi os.FileInfo) bool {
+	return path == "/" || !strings.HasPrefix(pathpkg.Base(path), ".")
+}
+
+// OnlyRegular hides everything but directories and regular files, such as
+// symbolic links and devices.
+func OnlyRegular(path string, fi os.FileInfo) bool {
+	return fi.IsDir() || fi.Mode().IsRegular()
+}
+
+// FilterOptions configures Filter.
+type FilterOptions struct {
+	// PruneEmptyDirs hides directories without any kept files below them.
+	// Deciding that walks the directories until a kept file is found, so
+	// every call can read the whole tree below a directory without kept
+	// files. Each call reads a directory at most once, nothing is cached
+	// between calls.
+	PruneEmptyDirs bool
+}
+
+// Filter wraps fs and hides the files for which any of the keep functions
+// returns false, and everything below hidden directories. Every call checks
+// the path and its parent directories so Stat, Lstat, Open and ReadDir
+// agree. Pruning empty directories walks them until a kept file is found.
+func Filter(fs FileSystem, opts *FilterOptions, keep ...FilterFunc) FileSystem {
+	var o FilterOptions
+	if opts != nil {
+		o = *opts
+	}
+	return predicateFS{fs: fs, keep: keep, prune: o.PruneEmptyDirs}
+}
+
+type predicateFS struct {
+	fs    FileSystem
+	keep  []FilterFunc
+	prune bool
+}
+
+func (fs predicateFS) String() string {
+	return fmt.Sprintf("filter(%s)", fs.fs.String())
+}
+
+// kept applies the keep functions to a single file.
+func (fs predicateFS) kept(path string, fi os.FileInfo) bool {
+	for _, keep := range fs.keep {
+		if !keep(path, fi) {
+			return false
+		}
+	}
+	if fs.prune && fi.IsDir() && path != "/" {
+		return fs.nonEmpty(path)
+	}
+	return true
+}
+
+// nonEmpty reports whether the directory path contains a kept file.
+func (fs predicateFS) nonEmpty(path string) bool {
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, fi := range fis {
+		if fs.kept(pathpkg.Join(path, fi.Name()), fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error unless path and its parent directories are kept.
+func (fs predicateFS) check(op, path string) error {
+	path = pathpkg.Clean("/" + path)
+	var dirs []string
+	for p := path; p != "/"; p = pathpkg.Dir(p) {
+		dirs = append(dirs, p)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := fs.fs.Lstat(dirs[i])
+		if err != nil {
+			return err
+		}
+		// only the last element is pruned, a kept file below a
+		// directory keeps it.
+		keep := fs.kept
+		if i > 0 {
+			keep = predicateFS{fs: fs.fs, keep: fs.keep}.kept
+		}
+		if !keep(dirs[i], fi) {
+			return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+		}
+	}
+	return nil
+}
+
+func (fs predicateFS) Open(path string) (ReadSeekCloser, error) {
+	if err := fs.check("open", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Open(path)
+}
+
+func (fs predicateFS) Lstat(path string) (os.FileInfo, error) {
+	if err := fs.check("lstat", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Lstat(path)
+}
+
+func (fs predicateFS) Stat(path string) (os.FileInfo, error) {
+	if err := fs.check("stat", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Stat(path)
+}
+
+func (fs predicateFS) ReadDir(path string) ([]os.FileInfo, error) {
+	// the listing tells whether path is pruned, so it is not walked twice.
+	if err := (predicateFS{fs: fs.fs, keep: fs.keep}).check("readdir", path); err != nil {
+		return nil, err
+	}
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	path = pathpkg.Clean("/" + path)
+	list := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		if fs.kept(pathpkg.Join(path, fi.Name()), fi) {
+			list = append(list, fi)
+		}
+	}
+	if fs.prune && path != "/" && len(list) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+	return list, nil
+}
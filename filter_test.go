@@ -0,0 +1,113 @@
+package vfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// readDirCountFS counts the ReadDir calls for each path.
+type readDirCountFS struct {
+	FileSystem
+	reads map[string]int
+}
+
+func (fs readDirCountFS) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.reads[path]++
+	return fs.FileSystem.ReadDir(path)
+}
+
+func TestFilter(t *testing.T) {
+	m := Map(map[string]string{
+		".git/config":         "config",
+		"docs/.hidden":        "hidden",
+		"docs/small.txt":      "small",
+		"docs/large.txt":      strings.Repeat("x", 100),
+		"empty/only/.dotfile": "dot",
+		"large/big.bin":       strings.Repeat("x", 100),
+		"top.txt":             "top",
+	})
+	fs := Filter(m, nil, NoDotFiles, SizeAtMost(10))
+	assertIsRegular(t, fs,
+		"/docs/small.txt",
+		"/top.txt",
+	)
+	assertIsDir(t, fs,
+		"/",
+		"/docs",
+		"/empty",
+		"/empty/only",
+		"/large",
+	)
+	assertIsNotExist(t, fs,
+		"/.git",
+		"/.git/config",
+		"/docs/.hidden",
+		"/docs/large.txt",
+		"/empty/only/.dotfile",
+		"/large/big.bin",
+	)
+	if _, err := fs.Open("/.git/config"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	assertWalk(t, fs, `dir : /
+dir : /docs
+file: /docs/small.txt
+data: small
+dir : /empty
+dir : /empty/only
+dir : /large
+file: /top.txt
+data: top`)
+
+	fs = Filter(m, &FilterOptions{PruneEmptyDirs: true}, NoDotFiles, SizeAtMost(10))
+	assertIsDir(t, fs, "/", "/docs")
+	assertIsNotExist(t, fs, "/empty", "/empty/only", "/large")
+	assertWalk(t, fs, `dir : /
+dir : /docs
+file: /docs/small.txt
+data: small
+file: /top.txt
+data: top`)
+
+	fs = Filter(m, nil, func(path string, fi os.FileInfo) bool {
+		return !strings.HasSuffix(path, ".txt")
+	})
+	assertIsNotExist(t, fs, "/top.txt", "/docs/small.txt")
+	assertIsRegular(t, fs, "/docs/.hidden")
+}
+
+func TestFilterOS(t *testing.T) {
+	fs := Filter(OS(testPath("")), &FilterOptions{PruneEmptyDirs: true},
+		OnlyRegular,
+		func(path string, fi os.FileInfo) bool {
+			return fi.IsDir() || strings.HasPrefix(fi.Name(), "B-")
+		},
+	)
+	assertIsRegular(t, fs, "/B/things/wood/tree/B-tree")
+	assertIsNotExist(t, fs, "/A", "/C", "/B/things/wood/tree/tree")
+	assertOSPather(t, fs, map[string]string{
+		"/B/animals/dogs/B-dogs": testPath("B/animals/dogs/B-dogs"),
+	})
+}
+
+func TestFilterPruneReads(t *testing.T) {
+	m := readDirCountFS{Map(map[string]string{
+		"a/x/.hidden":   "x",
+		"a/y/z/.hidden": "y",
+		"a/z/file":      "z",
+	}), map[string]int{}}
+	fs := Filter(m, &FilterOptions{PruneEmptyDirs: true}, NoDotFiles)
+	fis, err := fs.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "z" {
+		t.Fatalf("unexpected listing %v", fis)
+	}
+	for p, n := range m.reads {
+		if n != 1 {
+			t.Errorf("%s read %d times", p, n)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package vfs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Hash algorithms understood by Hash.
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+	HashSHA512 = "sha512"
+	HashCRC32  = "crc32" // IEEE polynomial, big endian
+)
+
+// ErrHashUnsupported is returned by Hasher implementations which can not
+// return a digest using the requested algorithm without reading the file.
+var ErrHashUnsupported = errors.New("hash algorithm not supported")
+
+// Hasher is implemented by file systems that can return the digest of a
+// regular file without reading all of it, for example from checksums stored
+// next to the contents.
+type Hasher interface {
+	Hash(path, algo string) ([]byte, error)
+}
+
+// Hash returns the digest of the regular file at path in fs using algo. If
+// fs is a Hasher it is asked first, the file is read if it is not or it
+// returns ErrHashUnsupported.
+func Hash(fs FileSystem, path, algo string) ([]byte, error) {
+	if h, ok := fs.(Hasher); ok {
+		sum, err := h.Hash(path, algo)
+		if !isHashUnsupported(err) {
+			return sum, err
+		}
+	}
+	return hashFile(fs, path, algo)
+}
+
+func isHashUnsupported(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return errors.Cause(err) == ErrHashUnsupported
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	}
+	return nil, errors.Errorf("unknown hash algorithm %q", algo)
+}
+
+// hashFile reads the file at path and returns its digest.
+func hashFile(fs FileSystem, path, algo string) ([]byte, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "hashing %s", path)
+	}
+	return h.Sum(nil), nil
+}
+
+// HashCache wraps fs and implements Hasher by remembering the digests of
+// files keyed on their path, size and modification time, so a file is only
+// hashed again after it changed. Digests are computed with Hash, which uses
+// fs itself if it is a Hasher. Files without a modification time are never
+// cached.
+func HashCache(fs FileSystem) FileSystem {
+	return &hashCacheFS{FileSystem: fs, sums: newHashStore()}
+}
+
+type hashKey struct {
+	path, algo string
+}
+
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// hashStore remembers digests keyed on path and algorithm, valid while the
+// file keeps its size and modification time. It backs HashCache and
+// TreeCache.
+type hashStore struct {
+	mu   sync.Mutex
+	sums map[hashKey]hashEntry
+}
+
+func newHashStore() *hashStore {
+	return &hashStore{sums: map[hashKey]hashEntry{}}
+}
+
+// lookup returns a copy of the digest stored for key if fi still matches
+// it, or nil.
+func (s *hashStore) lookup(key hashKey, fi os.FileInfo) []byte {
+	s.mu.Lock()
+	e, ok := s.sums[key]
+	s.mu.Unlock()
+	if !ok || e.size != fi.Size() || !e.modTime.Equal(fi.ModTime()) {
+		return nil
+	}
+	return append([]byte(nil), e.sum...)
+}
+
+// store remembers sum for key unless fi has no modification time.
+func (s *hashStore) store(key hashKey, fi os.FileInfo, sum []byte) {
+	if fi.ModTime().IsZero() {
+		return
+	}
+	s.mu.Lock()
+	s.sums[key] = hashEntry{size: fi.Size(), modTime: fi.ModTime(), sum: append([]byte(nil), sum...)}
+	s.mu.Unlock()
+}
+
+type hashCacheFS struct {
+	FileSystem
+	sums *hashStore
+}
+
+func (fs *hashCacheFS) String() string {
+	return fmt.Sprintf("hashcache(%v)", fs.FileSystem.String())
+}
+
+// Hash implements the Hasher interface.
+func (fs *hashCacheFS) Hash(path, algo string) ([]byte, error) {
+	fi, err := fs.FileSystem.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &os.PathError{Op: "hash", Path: path, Err: errors.New("not a regular file")}
+	}
+	key := hashKey{path, algo}
+	if sum := fs.sums.lookup(key, fi); sum != nil {
+		return sum, nil
+	}
+	sum, err := Hash(fs.FileSystem, path, algo)
+	if err != nil {
+		return nil, err
+	}
+	fs.sums.store(key, fi, sum)
+	return sum, nil
+}
@@ -0,0 +1,153 @@
+package vfs
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFS counts the files opened.
+type countingFS struct {
+	FileSystem
+	opens int32
+}
+
+func (fs *countingFS) Open(path string) (ReadSeekCloser, error) {
+	atomic.AddInt32(&fs.opens, 1)
+	return fs.FileSystem.Open(path)
+}
+
+// fixedHasher returns the same digest for every file.
+type fixedHasher struct {
+	FileSystem
+	sum []byte
+}
+
+func (fs fixedHasher) Hash(path, algo string) ([]byte, error) {
+	if _, err := fs.Stat(path); err != nil {
+		return nil, err
+	}
+	if algo != HashSHA256 {
+		return nil, &os.PathError{Op: "hash", Path: path, Err: ErrHashUnsupported}
+	}
+	return fs.sum, nil
+}
+
+func TestHash(t *testing.T) {
+	fs := Map(map[string]string{"a.txt": "a"})
+	for algo, want := range map[string]string{
+		HashMD5:    "0cc175b9c0f1b6a831c399e269772661",
+		HashSHA1:   "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8",
+		HashSHA256: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+		HashCRC32:  "e8b7be43",
+	} {
+		sum, err := Hash(fs, "/a.txt", algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(sum) != want {
+			t.Errorf("%s: got %x, want %s", algo, sum, want)
+		}
+	}
+	if _, err := Hash(fs, "/a.txt", "md4"); err == nil {
+		t.Fatal("expected error for unknown algorithm")
+	}
+	if _, err := Hash(fs, "/missing", HashSHA256); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	h := fixedHasher{fs, []byte{1, 2, 3}}
+	if sum, err := Hash(h, "/a.txt", HashSHA256); err != nil || hex.EncodeToString(sum) != "010203" {
+		t.Fatalf("expected the digest of the Hasher, got %x, %v", sum, err)
+	}
+	if sum, err := Hash(h, "/a.txt", HashMD5); err != nil || hex.EncodeToString(sum) != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Fatalf("expected the file to be read, got %x, %v", sum, err)
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.txt")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("a", now)
+
+	cfs := &countingFS{FileSystem: OS(dir)}
+	fs := HashCache(cfs)
+	hasher, ok := fs.(Hasher)
+	if !ok {
+		t.Fatal("HashCache is not a Hasher")
+	}
+	for i := 0; i < 3; i++ {
+		sum, err := hasher.Hash("/a.txt", HashSHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(sum) != "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" {
+			t.Fatalf("unexpected digest %x", sum)
+		}
+	}
+	if cfs.opens != 1 {
+		t.Fatalf("file hashed %d times, want once", cfs.opens)
+	}
+	if _, err := hasher.Hash("/a.txt", HashMD5); err != nil || cfs.opens != 2 {
+		t.Fatalf("expected the file to be hashed again for another algorithm, %d opens, %v", cfs.opens, err)
+	}
+
+	write("b", now.Add(time.Second))
+	sum, err := hasher.Hash("/a.txt", HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sum) != "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d" || cfs.opens != 3 {
+		t.Fatalf("expected changed file to be hashed again, got %x after %d opens", sum, cfs.opens)
+	}
+	if _, err := hasher.Hash("/", HashSHA256); err == nil {
+		t.Fatal("expected error for directory")
+	}
+}
+
+func TestNameSpaceHash(t *testing.T) {
+	ns := NewNameSpace()
+	ns.Bind("/native", fixedHasher{Map(map[string]string{"a.txt": "a"}), []byte{1}}, "/", BindReplace)
+	ns.Bind("/plain", Map(map[string]string{"a.txt": "a"}), "/", BindReplace)
+	ns.Bind("/union", Map(map[string]string{"b.txt": "b"}), "/", BindReplace)
+	ns.Bind("/union", fixedHasher{Map(map[string]string{"a.txt": "a"}), []byte{2}}, "/", BindAfter)
+
+	for path, want := range map[string]string{
+		"/native/a.txt": "01",
+		"/plain/a.txt":  "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+		"/union/a.txt":  "02",
+		"/union/b.txt":  "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d",
+	} {
+		sum, err := Hash(ns, path, HashSHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(sum) != want {
+			t.Errorf("%s: got %x, want %s", path, sum, want)
+		}
+	}
+	if _, err := ns.Hash("/plain/a.txt", HashSHA256); !isHashUnsupported(err) {
+		t.Fatalf("expected ErrHashUnsupported, got %v", err)
+	}
+	if _, err := Hash(ns, "/union/c.txt", HashSHA256); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
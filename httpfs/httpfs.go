@@ -0,0 +1,312 @@
+// Package httpfs implements an http.Handler which serves the files of a
+// vfs.FileSystem.
+package httpfs // import "github.com/thomasf/vfs/httpfs"
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thomasf/vfs"
+)
+
+// Options configures a Handler. The zero value serves files only.
+type Options struct {
+	// Index is the name of a file which is served instead of a directory
+	// listing when it exists in a directory, typically "index.html".
+	Index string
+	// Listing enables directory listings. JSON is returned when the
+	// request has a format=json query parameter or prefers
+	// application/json, HTML otherwise.
+	Listing bool
+	// Precompressed enables serving name.br and name.gz siblings of a
+	// requested file when the client accepts that encoding.
+	Precompressed bool
+	// Error, if set, is called instead of writing a plain text error
+	// response. It receives the underlying error from the FileSystem.
+	Error func(w http.ResponseWriter, r *http.Request, status int, err error)
+}
+
+// DirEntry is a single entry of a JSON directory listing.
+type DirEntry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	IsDir   bool        `json:"is_dir"`
+}
+
+// New returns a handler that serves HTTP requests with the contents of fs.
+// Request paths are cleaned so they can never refer to anything outside of
+// the root of fs. Range, If-Modified-Since and If-None-Match requests are
+// handled by http.ServeContent.
+func New(fs vfs.FileSystem, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &handler{fs: fs, opts: opts}
+}
+
+type handler struct {
+	fs   vfs.FileSystem
+	opts *Options
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.error(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	name := pathpkg.Clean("/" + r.URL.Path)
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		h.error(w, r, statusOf(err), err)
+		return
+	}
+
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			redirect(w, r, pathpkg.Base(name)+"/")
+			return
+		}
+		if h.opts.Index != "" {
+			index := pathpkg.Join(name, h.opts.Index)
+			if ifi, err := h.fs.Stat(index); err == nil && ifi.Mode().IsRegular() {
+				h.serveFile(w, r, index, ifi)
+				return
+			}
+		}
+		if !h.opts.Listing {
+			h.error(w, r, http.StatusForbidden, nil)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") && r.URL.Path != "/" {
+		redirect(w, r, "../"+pathpkg.Base(name))
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		h.error(w, r, http.StatusForbidden, nil)
+		return
+	}
+	h.serveFile(w, r, name, fi)
+}
+
+// encodings are the precompressed variants in order of preference.
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo) {
+	ctype := mime.TypeByExtension(pathpkg.Ext(name))
+	if h.opts.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if !acceptsEncoding(r, enc.name) {
+				continue
+			}
+			cfi, err := h.fs.Stat(name + enc.ext)
+			if err != nil || !cfi.Mode().IsRegular() {
+				continue
+			}
+			if ctype == "" {
+				ctype = h.sniff(name)
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Content-Encoding", enc.name)
+			h.serveContent(w, r, name+enc.ext, cfi, enc.name)
+			return
+		}
+	}
+	if ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	h.serveContent(w, r, name, fi, "")
+}
+
+// serveContent serves the regular file name, encoding is appended to the
+// entity tag of precompressed variants.
+func (h *handler) serveContent(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo, encoding string) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.error(w, r, statusOf(err), err)
+		return
+	}
+	defer f.Close()
+	if etag := FileETag(h.fs, name, fi); etag != "" {
+		if encoding != "" {
+			etag = etag[:len(etag)-1] + "-" + encoding + `"`
+		}
+		w.Header().Set("Etag", etag)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// sniff detects the content type of name from its first 512 bytes.
+func (h *handler) sniff(name string) string {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	return http.DetectContentType(buf[:n])
+}
+
+// FileETag returns a strong entity tag from the SHA-256 digest of name if fs
+// is a vfs.Hasher, such as vfs.HashCache, and ETag otherwise. It is the
+// entity tag of GET responses.
+func FileETag(fs vfs.FileSystem, name string, fi os.FileInfo) string {
+	if hasher, ok := fs.(vfs.Hasher); ok {
+		if sum, err := hasher.Hash(name, vfs.HashSHA256); err == nil {
+			return `"` + hex.EncodeToString(sum) + `"`
+		}
+	}
+	return ETag(fi)
+}
+
+// ETag returns a weak entity tag for fi based on its size and modification
+// time, or the empty string if fi has no modification time.
+func ETag(fi os.FileInfo) string {
+	if fi.ModTime().IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+}
+
+func (h *handler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	fis, err := h.fs.ReadDir(name)
+	if err != nil {
+		h.error(w, r, statusOf(err), err)
+		return
+	}
+	entries := make([]DirEntry, 0, len(fis))
+	for _, fi := range fis {
+		entries = append(entries, DirEntry{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.Method == "HEAD" {
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []DirEntry
+	}{name, entries})
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(e DirEntry) string {
+		u := url.URL{Path: e.Name}
+		if e.IsDir {
+			return u.String() + "/"
+		}
+		return u.String()
+	},
+}).Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>{{.Path}}</title>
+<h1>{{.Path}}</h1>
+<pre>
+{{range .Entries}}<a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a>
+{{end}}</pre>
+`))
+
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows
+// enc with a non zero quality value.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(v, ";")
+		if strings.TrimSpace(parts[0]) != enc {
+			continue
+		}
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func statusOf(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *handler) error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Etag")
+	if h.opts.Error != nil {
+		h.opts.Error(w, r, status, err)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// redirect redirects to a path relative to the request, keeping the query.
+func redirect(w http.ResponseWriter, r *http.Request, newPath string) {
+	if q := r.URL.RawQuery; q != "" {
+		newPath += "?" + q
+	}
+	w.Header().Set("Location", newPath)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
@@ -0,0 +1,165 @@
+package httpfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thomasf/vfs"
+)
+
+func serve(h http.Handler, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func assertResponse(t *testing.T, w *httptest.ResponseRecorder, status int, body string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if body != "" && w.Body.String() != body {
+		t.Fatalf("body %q, want %q", w.Body.String(), body)
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	fs := vfs.ModeMap(vfs.Map(map[string]string{
+		"index.html":     "<html>index</html>",
+		"a/data.txt":     "0123456789",
+		"a/style.css":    "body{}",
+		"a/style.css.gz": "gzipped",
+		"a/style.css.br": "brotli",
+		"a/noext":        "<html>sniffed</html>",
+		"a/noext.gz":     "gz",
+	}), map[string]os.FileMode{"a/data.txt": 0600})
+	h := New(fs, &Options{Index: "index.html", Precompressed: true})
+
+	w := serve(h, "GET", "/a/data.txt", nil)
+	assertResponse(t, w, 200, "0123456789")
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	w = serve(h, "GET", "/a/data.txt", map[string]string{"Range": "bytes=2-4"})
+	assertResponse(t, w, http.StatusPartialContent, "234")
+
+	w = serve(h, "HEAD", "/a/data.txt", nil)
+	assertResponse(t, w, 200, "")
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "10" {
+		t.Fatalf("unexpected HEAD response %v %q", w.Header(), w.Body.String())
+	}
+
+	w = serve(h, "GET", "/", nil)
+	assertResponse(t, w, 200, "<html>index</html>")
+
+	w = serve(h, "GET", "/a/style.css", map[string]string{"Accept-Encoding": "gzip, br"})
+	assertResponse(t, w, 200, "brotli")
+	if w.Header().Get("Content-Encoding") != "br" || w.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Fatalf("unexpected headers %v", w.Header())
+	}
+	w = serve(h, "GET", "/a/style.css", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	assertResponse(t, w, 200, "gzipped")
+	w = serve(h, "GET", "/a/style.css", nil)
+	assertResponse(t, w, 200, "body{}")
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("unexpected headers %v", w.Header())
+	}
+	w = serve(h, "GET", "/a/noext", map[string]string{"Accept-Encoding": "gzip"})
+	assertResponse(t, w, 200, "gz")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	assertResponse(t, serve(h, "GET", "/a", nil), http.StatusMovedPermanently, "")
+	assertResponse(t, serve(h, "GET", "/a/", nil), http.StatusForbidden, "")
+	assertResponse(t, serve(h, "GET", "/a/data.txt/", nil), http.StatusMovedPermanently, "")
+	assertResponse(t, serve(h, "GET", "/nope", nil), http.StatusNotFound, "")
+	assertResponse(t, serve(h, "POST", "/a/data.txt", nil), http.StatusMethodNotAllowed, "")
+	assertResponse(t, serve(h, "GET", "/../../a/data.txt", nil), 200, "0123456789")
+}
+
+func TestConditional(t *testing.T) {
+	h := New(vfs.OS("../test-fixtures/B"), nil)
+	w := serve(h, "GET", "/things/wood/tree/tree", nil)
+	assertResponse(t, w, 200, "B/things/wood/tree/tree")
+	etag := w.Header().Get("Etag")
+	lastModified := w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("expected validators, got %v", w.Header())
+	}
+	assertResponse(t, serve(h, "GET", "/things/wood/tree/tree", map[string]string{"If-None-Match": etag}), http.StatusNotModified, "")
+	assertResponse(t, serve(h, "GET", "/things/wood/tree/tree", map[string]string{"If-Modified-Since": lastModified}), http.StatusNotModified, "")
+	assertResponse(t, serve(h, "GET", "/things/wood/tree/tree", map[string]string{"If-None-Match": `W/"other"`}), 200, "")
+}
+
+func TestStrongETag(t *testing.T) {
+	h := New(vfs.HashCache(vfs.OS("../test-fixtures/B")), nil)
+	w := serve(h, "GET", "/things/wood/tree/tree", nil)
+	assertResponse(t, w, 200, "B/things/wood/tree/tree")
+	sum := sha256.Sum256([]byte("B/things/wood/tree/tree"))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if got := w.Header().Get("Etag"); got != etag {
+		t.Fatalf("got entity tag %s, want %s", got, etag)
+	}
+	assertResponse(t, serve(h, "GET", "/things/wood/tree/tree", map[string]string{"If-None-Match": etag}), http.StatusNotModified, "")
+}
+
+func TestListing(t *testing.T) {
+	fs := vfs.ModeMap(vfs.Map(map[string]string{
+		"dir/a.txt":    "a",
+		"dir/<b>.txt":  "b",
+		"dir/sub/file": "c",
+	}), map[string]os.FileMode{"dir/a.txt": 0640})
+	var gotErr error
+	h := New(fs, &Options{
+		Listing: true,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			gotErr = err
+			w.WriteHeader(status)
+		},
+	})
+
+	w := serve(h, "GET", "/dir/", nil)
+	assertResponse(t, w, 200, "")
+	body := w.Body.String()
+	for _, want := range []string{`<a href="a.txt">a.txt</a>`, `<a href="sub/">sub/</a>`, `&lt;b&gt;.txt`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected listing to contain %q:\n%s", want, body)
+		}
+	}
+
+	w = serve(h, "GET", "/dir/", map[string]string{"Accept": "application/json"})
+	assertResponse(t, w, 200, "")
+	var entries []DirEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[1].Name != "a.txt" || entries[1].Mode != 0640 || !entries[2].IsDir {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	w = serve(h, "GET", "/dir/?format=json", nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	assertResponse(t, serve(h, "GET", "/missing", nil), http.StatusNotFound, "")
+	if !os.IsNotExist(gotErr) {
+		t.Fatalf("expected the FileSystem error to be passed on, got %v", gotErr)
+	}
+	body2, _ := ioutil.ReadAll(serve(h, "GET", "/dir/a.txt", nil).Body)
+	if string(body2) != "a" {
+		t.Fatalf("unexpected body %q", body2)
+	}
+}
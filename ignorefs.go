@@ -0,0 +1,371 @@
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreOptions configures Ignore.
+type IgnoreOptions struct {
+	// Names are the names of the ignore files, rules from later names
+	// take precedence. It defaults to ".vfsignore" and ".gitignore".
+	Names []string
+	// HideIgnoreFiles hides the ignore files themselves.
+	HideIgnoreFiles bool
+	// Recheck is how long the cached rules of a directory are used before
+	// its ignore files are checked with Stat again. If it is zero they are
+	// only read again after Invalidate, for example from a file watcher.
+	Recheck time.Duration
+}
+
+// Ignore wraps fs and hides the files matched by ignore files found in the
+// directories of fs itself, following the rules of .gitignore: rules in
+// deeper directories take precedence over those above them, the last
+// matching rule in a file wins, "!" re-includes a file and nothing can be
+// re-included below an ignored directory.
+//
+// The rules of a directory are parsed once and cached, see
+// IgnoreOptions.Recheck for how changes to the ignore files are noticed.
+// The returned FileSystem is an Invalidator. The cache holds an entry for
+// every directory visited, Invalidate("/") empties it.
+func Ignore(fs FileSystem, opts *IgnoreOptions) FileSystem {
+	var o IgnoreOptions
+	if opts != nil {
+		o = *opts
+	}
+	if len(o.Names) == 0 {
+		o.Names = []string{".vfsignore", ".gitignore"}
+	}
+	return &ignoreFS{fs: fs, opts: o, dirs: map[string]*ignoreDir{}}
+}
+
+type ignoreFS struct {
+	fs   FileSystem
+	opts IgnoreOptions
+
+	mu   sync.Mutex
+	dirs map[string]*ignoreDir
+}
+
+// ignoreDir holds the parsed rules of a directory and the state of its
+// ignore files when they were last checked.
+type ignoreDir struct {
+	stamps  []ignoreStamp
+	rules   []ignoreRule
+	checked time.Time
+}
+
+type ignoreStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	base    bool // match the base name instead of the relative path
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base {
+		return r.re.MatchString(pathpkg.Base(rel))
+	}
+	return r.re.MatchString(rel)
+}
+
+// parseIgnore parses the lines of an ignore file, invalid patterns are
+// skipped like git does.
+func parseIgnore(data []byte) []ignoreRule {
+	var rules []ignoreRule
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+		if line == "" {
+			continue
+		}
+		var r ignoreRule
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		r.base = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(ignoreRegexp(line))
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// ignoreRegexp translates a gitignore glob into a regular expression.
+func ignoreRegexp(p string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' && (i == 0 || p[i-1] == '/') {
+				switch {
+				case i+2 == len(p):
+					// a trailing "**" matches everything inside.
+					b.WriteString(".*")
+					i++
+					continue
+				case p[i+2] == '/':
+					// "**/" matches zero or more directories.
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+			for i+1 < len(p) && p[i+1] == '*' {
+				i++
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			if j < len(p) && (p[j] == '!' || p[j] == '^') {
+				j++
+			}
+			if j < len(p) && p[j] == ']' {
+				j++
+			}
+			for j < len(p) && p[j] != ']' {
+				j++
+			}
+			if j >= len(p) {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i = j
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ignoreMemo holds the rules of the directories looked up by a single
+// operation, so each directory is looked up once.
+type ignoreMemo map[string][]ignoreRule
+
+// rules returns the rules of the ignore files in dir. Cached rules are
+// checked against the ignore files once Recheck has passed and parsed again
+// if they changed.
+func (fs *ignoreFS) rules(dir string, memo ignoreMemo) ([]ignoreRule, error) {
+	if rules, ok := memo[dir]; ok {
+		return rules, nil
+	}
+	now := time.Now()
+	fs.mu.Lock()
+	d := fs.dirs[dir]
+	fs.mu.Unlock()
+	if d != nil && (fs.opts.Recheck == 0 || now.Sub(d.checked) < fs.opts.Recheck) {
+		memo[dir] = d.rules
+		return d.rules, nil
+	}
+
+	stamps := make([]ignoreStamp, len(fs.opts.Names))
+	for i, name := range fs.opts.Names {
+		fi, err := fs.fs.Stat(pathpkg.Join(dir, name))
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		stamps[i] = ignoreStamp{exists: true, size: fi.Size(), modTime: fi.ModTime()}
+	}
+	if d != nil && sameStamps(d.stamps, stamps) {
+		d = &ignoreDir{stamps: stamps, rules: d.rules, checked: now}
+	} else {
+		d = &ignoreDir{stamps: stamps, checked: now}
+		for i, name := range fs.opts.Names {
+			if !stamps[i].exists {
+				continue
+			}
+			data, err := ReadFile(fs.fs, pathpkg.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			d.rules = append(d.rules, parseIgnore(data)...)
+		}
+	}
+	fs.mu.Lock()
+	fs.dirs[dir] = d
+	fs.mu.Unlock()
+	memo[dir] = d.rules
+	return d.rules, nil
+}
+
+// Invalidate implements the Invalidator interface. It drops the cached
+// rules of path, of the directories below it and of its parent directory,
+// so it can be called with a changed ignore file as well as a directory.
+func (fs *ignoreFS) Invalidate(path string) {
+	path = pathpkg.Clean("/" + path)
+	parent := pathpkg.Dir(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for dir := range fs.dirs {
+		if dir == parent || hasPathPrefix(dir, path) {
+			delete(fs.dirs, dir)
+		}
+	}
+}
+
+func sameStamps(a, b []ignoreStamp) bool {
+	for i := range a {
+		if a[i].exists != b[i].exists || a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignored reports whether path is ignored by the rules of its parent
+// directories, assuming none of them is ignored.
+func (fs *ignoreFS) ignored(path string, isDir bool, memo ignoreMemo) (bool, error) {
+	if fs.opts.HideIgnoreFiles && !isDir {
+		for _, name := range fs.opts.Names {
+			if pathpkg.Base(path) == name {
+				return true, nil
+			}
+		}
+	}
+	var dirs []string
+	for d := pathpkg.Dir(path); ; d = pathpkg.Dir(d) {
+		dirs = append(dirs, d)
+		if d == "/" {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules, err := fs.rules(dirs[i], memo)
+		if err != nil {
+			return false, err
+		}
+		rel := strings.TrimPrefix(path[len(dirs[i]):], "/")
+		for _, r := range rules {
+			if r.match(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored, nil
+}
+
+// check returns an error if path or one of its parent directories is
+// ignored.
+func (fs *ignoreFS) check(op, path string) error {
+	path = pathpkg.Clean("/" + path)
+	if path == "/" {
+		return nil
+	}
+	// the rules of the parent directories are only looked up, and cached,
+	// once path is known to exist.
+	fi, err := fs.fs.Lstat(path)
+	if err != nil {
+		return err
+	}
+	elems := strings.Split(path[1:], "/")
+	memo := ignoreMemo{}
+	for i := range elems {
+		p := "/" + strings.Join(elems[:i+1], "/")
+		isDir := i < len(elems)-1 || fi.IsDir()
+		ignored, err := fs.ignored(p, isDir, memo)
+		if err != nil {
+			return err
+		}
+		if ignored {
+			return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+		}
+	}
+	return nil
+}
+
+func (fs *ignoreFS) String() string {
+	return fmt.Sprintf("ignore(%s)", fs.fs.String())
+}
+
+func (fs *ignoreFS) Open(path string) (ReadSeekCloser, error) {
+	if err := fs.check("open", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Open(path)
+}
+
+func (fs *ignoreFS) Lstat(path string) (os.FileInfo, error) {
+	if err := fs.check("lstat", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Lstat(path)
+}
+
+func (fs *ignoreFS) Stat(path string) (os.FileInfo, error) {
+	if err := fs.check("stat", path); err != nil {
+		return nil, err
+	}
+	return fs.fs.Stat(path)
+}
+
+func (fs *ignoreFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if err := fs.check("readdir", path); err != nil {
+		return nil, err
+	}
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	path = pathpkg.Clean("/" + path)
+	memo := ignoreMemo{}
+	list := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		ignored, err := fs.ignored(pathpkg.Join(path, fi.Name()), fi.IsDir(), memo)
+		if err != nil {
+			return nil, err
+		}
+		if !ignored {
+			list = append(list, fi)
+		}
+	}
+	return list, nil
+}
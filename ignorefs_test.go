@@ -0,0 +1,224 @@
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIgnoreRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"*.log", "a.log", true},
+		{"*.log", "a.logx", false},
+		{"a/*.c", "a/b.c", true},
+		{"a/*.c", "a/b/c.c", false},
+		{"**/foo", "foo", true},
+		{"**/foo", "a/b/foo", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**", "a/x/y", true},
+		{"a/**", "a", false},
+		{"fo?", "foo", true},
+		{"fo?", "fo/", false},
+		{"[!a]b", "cb", true},
+		{"[!a]b", "ab", false},
+		{"[a-c]x", "bx", true},
+		{`\#x`, "#x", true},
+		{`\!x`, "!x", true},
+		{"x[", "x[", true},
+		{"a**b", "axxb", true},
+	} {
+		rules := parseIgnore([]byte(tc.pattern))
+		if len(rules) != 1 {
+			t.Fatalf("%q: parsed %d rules", tc.pattern, len(rules))
+		}
+		if m := rules[0].match(tc.path, false); m != tc.match {
+			t.Errorf("%q match %q = %v, want %v", tc.pattern, tc.path, m, tc.match)
+		}
+	}
+	if rules := parseIgnore([]byte("# comment\n\n  \n/\n")); len(rules) != 0 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	fs := Ignore(Map(map[string]string{
+		".gitignore":           "*.log\nbuild/\n/root-only.txt\n!keep.log\nsecret*\n",
+		"a.log":                "",
+		"keep.log":             "",
+		"root-only.txt":        "",
+		"build/out":            "",
+		"src/build":            "a file, not a directory",
+		"src/root-only.txt":    "",
+		"src/debug.log":        "",
+		"src/.gitignore":       "!debug.log\n*.tmp\n",
+		"src/x.tmp":            "",
+		"src/sub/y.tmp":        "",
+		"src/sub/.vfsignore":   "!y.tmp",
+		"secrets/.gitignore":   "!*",
+		"secrets/key":          "",
+		"docs/.vfsignore":      "draft/\n",
+		"docs/draft/a.md":      "",
+		"docs/final/draft.md":  "",
+		"docs/draft.md/.empty": "",
+	}), nil)
+
+	assertIsRegular(t, fs,
+		"/.gitignore",
+		"/keep.log",
+		"/src/build",
+		"/src/root-only.txt",
+		"/src/debug.log",
+		"/src/sub/y.tmp",
+		"/docs/final/draft.md",
+	)
+	assertIsNotExist(t, fs,
+		"/a.log",
+		"/root-only.txt",
+		"/build",
+		"/build/out",
+		"/src/x.tmp",
+		"/secrets",
+		"/secrets/key",
+		"/docs/draft",
+		"/docs/draft/a.md",
+	)
+	assertIsDir(t, fs, "/docs/draft.md")
+	if _, err := fs.Open("/secrets/key"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	assertWalk(t, fs, `dir : /
+file: /.gitignore
+data: *.log
+build/
+/root-only.txt
+!keep.log
+secret*
+
+dir : /docs
+file: /docs/.vfsignore
+data: draft/
+
+dir : /docs/draft.md
+file: /docs/draft.md/.empty
+data: 
+dir : /docs/final
+file: /docs/final/draft.md
+data: 
+file: /keep.log
+data: 
+dir : /src
+file: /src/.gitignore
+data: !debug.log
+*.tmp
+
+file: /src/build
+data: a file, not a directory
+file: /src/debug.log
+data: 
+file: /src/root-only.txt
+data: 
+dir : /src/sub
+file: /src/sub/.vfsignore
+data: !y.tmp
+file: /src/sub/y.tmp
+data: `)
+
+	fs = Ignore(Map(map[string]string{
+		".ignore": "*.txt",
+		"a.txt":   "",
+		"b.md":    "",
+	}), &IgnoreOptions{Names: []string{".ignore"}, HideIgnoreFiles: true})
+	assertIsNotExist(t, fs, "/.ignore", "/a.txt")
+	assertIsRegular(t, fs, "/b.md")
+
+	// looking up missing paths does not cache their directories.
+	assertIsNotExist(t, fs, "/missing/a/b", "/b.md/c")
+	for dir := range fs.(*ignoreFS).dirs {
+		if dir != "/" {
+			t.Errorf("unexpected cached directory %s", dir)
+		}
+	}
+}
+
+// statCountFS counts the Stat calls for each base name.
+type statCountFS struct {
+	FileSystem
+	mu    sync.Mutex
+	stats map[string]int
+}
+
+func (fs *statCountFS) Stat(p string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	fs.stats[pathpkg.Base(p)]++
+	fs.mu.Unlock()
+	return fs.FileSystem.Stat(p)
+}
+
+func TestIgnoreChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string, modTime time.Time) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("a.txt", "a", now)
+	write("b.txt", "b", now)
+	write(".gitignore", "a.txt", now)
+
+	// without Recheck changes are only noticed after Invalidate.
+	sfs := &statCountFS{FileSystem: OS(dir), stats: map[string]int{}}
+	fs := Ignore(sfs, nil)
+	assertIsNotExist(t, fs, "/a.txt")
+	assertIsRegular(t, fs, "/b.txt")
+	if n := sfs.stats[".gitignore"]; n != 1 {
+		t.Fatalf("ignore file checked %d times, want once", n)
+	}
+
+	write(".gitignore", "b.txt", now.Add(time.Second))
+	assertIsNotExist(t, fs, "/a.txt")
+	fs.(Invalidator).Invalidate("/.gitignore")
+	assertIsNotExist(t, fs, "/b.txt")
+	assertIsRegular(t, fs, "/a.txt")
+
+	os.Remove(filepath.Join(dir, ".gitignore"))
+	fs.(Invalidator).Invalidate("/")
+	assertIsRegular(t, fs, "/a.txt", "/b.txt")
+
+	// with Recheck the ignore files are checked at most once per call.
+	write(".gitignore", "a.txt", now)
+	if err := os.MkdirAll(filepath.Join(dir, "x", "y"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("x/y/z.txt", "z", now)
+	sfs.stats = map[string]int{}
+	fs = Ignore(sfs, &IgnoreOptions{Recheck: time.Nanosecond})
+	assertIsNotExist(t, fs, "/a.txt")
+	sfs.stats = map[string]int{}
+	if _, err := fs.Stat("/x/y/z.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// one Stat per ignore file name in /, /x and /x/y and one of z.txt.
+	if n := sfs.stats[".gitignore"] + sfs.stats[".vfsignore"]; n != 6 {
+		t.Fatalf("ignore files checked %d times, want 6", n)
+	}
+	write(".gitignore", "b.txt", now.Add(time.Second))
+	assertIsNotExist(t, fs, "/b.txt")
+	assertIsRegular(t, fs, "/a.txt")
+}
@@ -16,9 +16,10 @@ import (
 
 // Map returns a new FileSystem from the provided map. Map keys should be
 // forward slash-separated pathnames and not contain a leading slash. The Map
-// value string contents is returned by Open.
+// value string contents is returned by Open. The map is copied and indexed,
+// later changes to it are not reflected by the FileSystem.
 func Map(m map[string]string) FileSystem {
-	return mapFS(m)
+	return mapFS{newMapIndex(m)}
 }
 
 func SafeMap(m map[string]string) FileSystemFunc {
@@ -31,15 +32,92 @@ func SafeMap(m map[string]string) FileSystemFunc {
 			m[path] = data
 
 		}
-		return mapFS(m), nil
+		return Map(m), nil
 	}
 }
 
+// mapIndex is the directory index shared by Map and FileMap. It is built
+// once so Stat is a map lookup and ReadDir only visits the entries of the
+// directory.
+type mapIndex struct {
+	files map[string]string     // map key -> value
+	dirs  map[string][]mapEntry // slash dir -> entries sorted by name
+}
+
+// mapEntry is a directory entry, key is the map key of files.
+type mapEntry struct {
+	name string
+	key  string
+	dir  bool
+}
+
+func newMapIndex(m map[string]string) *mapIndex {
+	idx := &mapIndex{
+		files: make(map[string]string, len(m)),
+		dirs:  make(map[string][]mapEntry),
+	}
+	pos := make(map[string]int) // dir + "\x00" + name -> index in dirs[dir]
+	add := func(dir string, e mapEntry) bool {
+		k := dir + "\x00" + e.name
+		if i, ok := pos[k]; ok {
+			// files take precedence over directories of the same name.
+			if idx.dirs[dir][i].dir && !e.dir {
+				idx.dirs[dir][i] = e
+			}
+			return false
+		}
+		pos[k] = len(idx.dirs[dir])
+		idx.dirs[dir] = append(idx.dirs[dir], e)
+		return true
+	}
+	for fn, v := range m {
+		idx.files[fn] = v
+		dir := slashdir(fn)
+		e := mapEntry{name: pathpkg.Base(fn), key: fn}
+		for {
+			// the ancestors are already indexed if the directory was.
+			if !add(dir, e) && e.dir {
+				break
+			}
+			if dir == "/" {
+				break
+			}
+			e = mapEntry{name: pathpkg.Base(dir), dir: true}
+			dir = pathpkg.Dir(dir)
+		}
+	}
+	for _, ents := range idx.dirs {
+		sort.Slice(ents, func(i, j int) bool { return ents[i].name < ents[j].name })
+	}
+	return idx
+}
+
+// isDir reports whether p is a directory.
+func (idx *mapIndex) isDir(p string) bool {
+	p = pathpkg.Clean(p)
+	return len(idx.dirs[p]) > 0 || filename(p) == ""
+}
+
+// readDir returns the entries of the directory p.
+func (idx *mapIndex) readDir(p string) ([]mapEntry, error) {
+	p = pathpkg.Clean(p)
+	ents, ok := idx.dirs[p]
+	if !ok {
+		if p == "/" {
+			return nil, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return ents, nil
+}
+
 // mapFS is the map based implementation of FileSystem
-type mapFS map[string]string
+type mapFS struct {
+	*mapIndex
+}
 
 func (fs mapFS) String() string {
-	return fmt.Sprintf("filemap(%v)", len(fs))
+	return fmt.Sprintf("filemap(%v)", len(fs.files))
 }
 
 func (fs mapFS) Close() error { return nil }
@@ -49,7 +127,7 @@ func filename(p string) string {
 }
 
 func (fs mapFS) Open(p string) (ReadSeekCloser, error) {
-	b, ok := fs[filename(p)]
+	b, ok := fs.files[filename(p)]
 	if !ok {
 		return nil, os.ErrNotExist
 	}
@@ -57,12 +135,11 @@ func (fs mapFS) Open(p string) (ReadSeekCloser, error) {
 }
 
 func (fs mapFS) Lstat(p string) (os.FileInfo, error) {
-	b, ok := fs[filename(p)]
+	b, ok := fs.files[filename(p)]
 	if ok {
 		return mapFileInfo(p, b), nil
 	}
-	ents, _ := fs.ReadDir(p)
-	if len(ents) > 0 {
+	if fs.isDir(p) {
 		return mapDirInfo(p), nil
 	}
 	return nil, os.ErrNotExist
@@ -86,47 +163,17 @@ func slashdir(p string) string {
 }
 
 func (fs mapFS) ReadDir(p string) ([]os.FileInfo, error) {
-	p = pathpkg.Clean(p)
-	var ents []string
-	fim := make(map[string]os.FileInfo) // base -> fi
-	for fn, b := range fs {
-		dir := slashdir(fn)
-		isFile := true
-		var lastBase string
-		for {
-			if dir == p {
-				base := lastBase
-				if isFile {
-					base = pathpkg.Base(fn)
-				}
-				if fim[base] == nil {
-					var fi os.FileInfo
-					if isFile {
-						fi = mapFileInfo(fn, b)
-					} else {
-						fi = mapDirInfo(base)
-					}
-					ents = append(ents, base)
-					fim[base] = fi
-				}
-			}
-			if dir == "/" {
-				break
-			} else {
-				isFile = false
-				lastBase = pathpkg.Base(dir)
-				dir = pathpkg.Dir(dir)
-			}
-		}
-	}
-	if len(ents) == 0 {
-		return nil, os.ErrNotExist
+	ents, err := fs.readDir(p)
+	if err != nil {
+		return nil, err
 	}
-
-	sort.Strings(ents)
-	var list []os.FileInfo
-	for _, dir := range ents {
-		list = append(list, fim[dir])
+	list := make([]os.FileInfo, 0, len(ents))
+	for _, e := range ents {
+		if e.dir {
+			list = append(list, mapDirInfo(e.name))
+		} else {
+			list = append(list, mapFileInfo(e.key, fs.files[e.key]))
+		}
 	}
 	return list, nil
 }
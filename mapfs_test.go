@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -154,3 +155,76 @@ func TestSafeMapFS(t *testing.T) {
 		)
 	}
 }
+
+func TestMapFSIndex(t *testing.T) {
+	m := map[string]string{
+		"a":     "file",
+		"a/b":   "shadowed by the file a",
+		"c/d/e": "e",
+	}
+	fs := Map(m)
+	m["f"] = "added later"
+	delete(m, "c/d/e")
+
+	fis, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []os.FileInfo{
+		mapFI{name: "a", size: 4},
+		mapFI{name: "c", dir: true},
+	}
+	if !reflect.DeepEqual(fis, want) {
+		t.Fatalf("ReadDir(/) = %#v; want %#v", fis, want)
+	}
+	if fi, err := fs.Stat("/c/d"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat(/c/d) = %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/f"); !os.IsNotExist(err) {
+		t.Fatalf("Stat(/f) = %v; want os.IsNotExist error", err)
+	}
+	if fis, err := fs.ReadDir("/"); err != nil || len(fis) != 2 {
+		t.Fatalf("ReadDir(/) = %v, %v", fis, err)
+	}
+	if fis, err := Map(nil).ReadDir("/"); err != nil || fis == nil || len(fis) != 0 {
+		t.Fatalf("ReadDir(/) of an empty map = %#v, %v", fis, err)
+	}
+}
+
+// benchMap returns a map with n files spread over directories of 100
+// entries.
+func benchMap(n int, value func(i int) string) map[string]string {
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		m[fmt.Sprintf("dir%d/sub%d/file%d", i/10000, i/100, i)] = value(i)
+	}
+	return m
+}
+
+func BenchmarkMap(b *testing.B) {
+	m := benchMap(20000, func(i int) string { return "content" })
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Map(m)
+	}
+}
+
+func BenchmarkMapReadDir(b *testing.B) {
+	fs := Map(benchMap(20000, func(i int) string { return "content" }))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.ReadDir("/dir1/sub150"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMapStatDir(b *testing.B) {
+	fs := Map(benchMap(20000, func(i int) string { return "content" }))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Stat("/dir1/sub150"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
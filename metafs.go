@@ -0,0 +1,186 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MetaRule overrides the metadata of the files it matches.
+type MetaRule struct {
+	// Pattern is a path.Match pattern. Patterns containing a slash are
+	// matched against the full path, others against the base name so
+	// "*.sh" matches in every directory.
+	Pattern string
+	// Prefix matches a path and everything below it, such as "/bin". A
+	// rule with both Pattern and Prefix must match both, a rule with
+	// neither matches every path.
+	Prefix string
+
+	// SetMode replaces the permission bits with those of Mode.
+	SetMode bool
+	Mode    os.FileMode
+	// Clear and Add are permission bits removed and added after SetMode,
+	// for example Clear 0222 strips the write bits.
+	Clear os.FileMode
+	Add   os.FileMode
+	// ModTime replaces the modification time if it is not zero.
+	ModTime time.Time
+	// SetOwner reports Uid and Gid in a MetaSys value from Sys.
+	SetOwner bool
+	Uid, Gid int
+}
+
+func (r MetaRule) match(p string) bool {
+	if r.Prefix != "" && !hasPathPrefix(p, pathpkg.Clean("/"+r.Prefix)) {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+	name := p
+	if !strings.Contains(r.Pattern, "/") {
+		name = pathpkg.Base(p)
+	} else if !strings.HasPrefix(r.Pattern, "/") {
+		name = filename(p)
+	}
+	m, _ := pathpkg.Match(r.Pattern, name)
+	return m
+}
+
+// MetaSys is returned by Sys for files with an owner set by a MetaRule.
+type MetaSys struct {
+	Uid, Gid int
+	// Sys is the Sys value of the underlying file.
+	Sys interface{}
+}
+
+// Meta wraps a FileSystem and overrides the mode, modification time and
+// owner reported for the files matching rules. Every matching rule is
+// applied in order so later rules take precedence. The OS paths of files,
+// Readlink and Hash are passed through to fs.
+func Meta(fs FileSystem, rules ...MetaRule) FileSystem {
+	return metaFS{fs, rules}
+}
+
+// SafeMeta is like Meta but verifies the patterns.
+func SafeMeta(fs FileSystem, rules ...MetaRule) FileSystemFunc {
+	return func() (FileSystem, error) {
+		for _, r := range rules {
+			if _, err := pathpkg.Match(r.Pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern %q", r.Pattern)
+			}
+			if r.Mode&^os.ModePerm != 0 || r.Clear&^os.ModePerm != 0 || r.Add&^os.ModePerm != 0 {
+				return nil, errors.Errorf("rules may only change permission bits: %q", r.Pattern)
+			}
+		}
+		return Meta(fs, rules...), nil
+	}
+}
+
+type metaFS struct {
+	FileSystem
+	rules []MetaRule
+}
+
+func (fs metaFS) String() string {
+	return fmt.Sprintf("meta(%v)", fs.FileSystem.String())
+}
+
+// fileInfo applies the rules matching p to fi.
+func (fs metaFS) fileInfo(p string, fi os.FileInfo) os.FileInfo {
+	var (
+		mfi     = metaFI{FileInfo: fi, mode: fi.Mode(), modTime: fi.ModTime()}
+		changed bool
+	)
+	for _, r := range fs.rules {
+		if !r.match(p) {
+			continue
+		}
+		changed = true
+		perm := mfi.mode.Perm()
+		if r.SetMode {
+			perm = r.Mode.Perm()
+		}
+		perm = perm&^r.Clear | r.Add&os.ModePerm
+		mfi.mode = mfi.mode&^os.ModePerm | perm
+		if !r.ModTime.IsZero() {
+			mfi.modTime = r.ModTime
+		}
+		if r.SetOwner {
+			mfi.sys = &MetaSys{Uid: r.Uid, Gid: r.Gid, Sys: fi.Sys()}
+		}
+	}
+	if !changed {
+		return fi
+	}
+	return keepOSPath(fi, mfi)
+}
+
+func (fs metaFS) Lstat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(pathpkg.Clean("/"+p), fi), nil
+}
+
+func (fs metaFS) Stat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(pathpkg.Clean("/"+p), fi), nil
+}
+
+func (fs metaFS) ReadDir(p string) ([]os.FileInfo, error) {
+	fis, err := fs.FileSystem.ReadDir(p)
+	if err != nil {
+		return fis, err
+	}
+	p = pathpkg.Clean("/" + p)
+	// the slice may be cached by fs.FileSystem so a new one is returned.
+	list := make([]os.FileInfo, len(fis))
+	for i, fi := range fis {
+		list[i] = fs.fileInfo(pathpkg.Join(p, fi.Name()), fi)
+	}
+	return list, nil
+}
+
+// Readlink implements the Readlinker interface.
+func (fs metaFS) Readlink(p string) (string, error) {
+	return Readlink(fs.FileSystem, p)
+}
+
+// Hash implements the Hasher interface, ErrHashUnsupported is returned if
+// the wrapped file system is not a Hasher.
+func (fs metaFS) Hash(p, algo string) ([]byte, error) {
+	if h, ok := fs.FileSystem.(Hasher); ok {
+		return h.Hash(p, algo)
+	}
+	if _, err := fs.FileSystem.Stat(p); err != nil {
+		return nil, err
+	}
+	return nil, &os.PathError{Op: "hash", Path: p, Err: ErrHashUnsupported}
+}
+
+// metaFI wraps a os.FileInfo with the metadata set by rules.
+type metaFI struct {
+	os.FileInfo
+	mode    os.FileMode
+	modTime time.Time
+	sys     interface{}
+}
+
+func (fi metaFI) Mode() os.FileMode  { return fi.mode }
+func (fi metaFI) ModTime() time.Time { return fi.modTime }
+func (fi metaFI) Sys() interface{} {
+	if fi.sys != nil {
+		return fi.sys
+	}
+	return fi.FileInfo.Sys()
+}
@@ -0,0 +1,139 @@
+package vfs
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	modTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := Meta(Map(map[string]string{
+		"bin/run.sh":      "#!/bin/sh",
+		"bin/tool":        "tool",
+		"etc/app.conf":    "conf",
+		"etc/secret.key":  "key",
+		"scripts/deploy":  "deploy",
+		"scripts/test.sh": "test",
+	}),
+		MetaRule{Clear: 0222},
+		MetaRule{Pattern: "*.sh", Add: 0111},
+		MetaRule{Prefix: "/bin", SetMode: true, Mode: 0755, ModTime: modTime},
+		MetaRule{Pattern: "/etc/*.key", SetMode: true, Mode: 0600, SetOwner: true, Uid: 1000, Gid: 100},
+		MetaRule{Pattern: "scripts/deploy", Add: 0100},
+	)
+
+	for name, mode := range map[string]os.FileMode{
+		"/":                0555 | os.ModeDir,
+		"/bin":             0755 | os.ModeDir,
+		"/bin/run.sh":      0755,
+		"/bin/tool":        0755,
+		"/etc":             0555 | os.ModeDir,
+		"/etc/app.conf":    0444,
+		"/etc/secret.key":  0600,
+		"/scripts/deploy":  0544,
+		"/scripts/test.sh": 0555,
+	} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != mode {
+			t.Fatalf("%s: mode %v, want %v", name, fi.Mode(), mode)
+		}
+	}
+
+	fis, err := fs.ReadDir("/bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if fi.Mode() != 0755 || !fi.ModTime().Equal(modTime) {
+			t.Fatalf("%s: unexpected metadata %v %v", fi.Name(), fi.Mode(), fi.ModTime())
+		}
+	}
+
+	fi, err := fs.Lstat("/etc/secret.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys, ok := fi.Sys().(*MetaSys); !ok || sys.Uid != 1000 || sys.Gid != 100 {
+		t.Fatalf("unexpected Sys %#v", fi.Sys())
+	}
+	if fi, err := fs.Stat("/etc/app.conf"); err != nil || fi.Sys() != nil {
+		t.Fatalf("unexpected Sys %#v: %v", fi.Sys(), err)
+	}
+}
+
+func TestMetaOSPath(t *testing.T) {
+	fs := Meta(OS(testPath("C")), MetaRule{Pattern: "cats", SetMode: true, Mode: 0700})
+	assertOSPather(t, fs, map[string]string{
+		"/animals/cats/cats": testPath("C/animals/cats/cats"),
+	})
+	fi, err := fs.Stat("/animals/cats/cats")
+	if err != nil || fi.Mode() != 0700 {
+		t.Fatalf("unexpected mode %v: %v", fi, err)
+	}
+
+	assertNotSafe(t,
+		SafeMeta(fs, MetaRule{Pattern: "[a"}),
+		SafeMeta(fs, MetaRule{SetMode: true, Mode: os.ModeDir}),
+	)
+	assertIsSafe(t, SafeMeta(fs, MetaRule{Pattern: "*.sh", Add: 0111}))
+}
+
+// linkFS reports a destination for every path.
+type linkFS struct {
+	FileSystem
+}
+
+func (fs linkFS) Readlink(p string) (string, error) {
+	return p + ".dest", nil
+}
+
+// readDirCacheFS returns the same slice from every ReadDir call.
+type readDirCacheFS struct {
+	FileSystem
+	fis map[string][]os.FileInfo
+}
+
+func (fs readDirCacheFS) ReadDir(p string) ([]os.FileInfo, error) {
+	if fis, ok := fs.fis[p]; ok {
+		return fis, nil
+	}
+	fis, err := fs.FileSystem.ReadDir(p)
+	if err == nil {
+		fs.fis[p] = fis
+	}
+	return fis, err
+}
+
+func TestMetaForward(t *testing.T) {
+	cached := readDirCacheFS{Map(map[string]string{"a.sh": "a"}), map[string][]os.FileInfo{}}
+	fs := Meta(cached, MetaRule{Pattern: "*.sh", Add: 0111})
+	for i := 0; i < 2; i++ {
+		fis, err := fs.ReadDir("/")
+		if err != nil || len(fis) != 1 || fis[0].Mode() != 0555 {
+			t.Fatalf("unexpected listing %v: %v", fis, err)
+		}
+	}
+	if mode := cached.fis["/"][0].Mode(); mode != 0444 {
+		t.Fatalf("wrapped listing modified, mode %v", mode)
+	}
+
+	h := Meta(fixedHasher{Map(map[string]string{"a.txt": "a"}), []byte{1}})
+	if sum, err := Hash(h, "/a.txt", HashSHA256); err != nil || hex.EncodeToString(sum) != "01" {
+		t.Fatalf("expected the digest of the wrapped Hasher, got %x, %v", sum, err)
+	}
+	if _, err := h.(Hasher).Hash("/missing", HashSHA256); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	if _, err := Meta(Map(map[string]string{"a.txt": "a"})).(Hasher).Hash("/a.txt", HashSHA256); !isHashUnsupported(err) {
+		t.Fatalf("expected ErrHashUnsupported, got %v", err)
+	}
+
+	if dst, err := Readlink(Meta(linkFS{Map(map[string]string{"a.txt": "a"})}), "/a.txt"); err != nil || dst != "/a.txt.dest" {
+		t.Fatalf("unexpected link destination %q: %v", dst, err)
+	}
+}
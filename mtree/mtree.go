@@ -0,0 +1,614 @@
+// Package mtree reads, writes and checks BSD mtree specifications of
+// vfs.FileSystem trees.
+//
+// Both the hierarchical format written by mtree -c and the format with full
+// paths written by libarchive are read. The keywords type, mode, size, time,
+// link and sha256digest (or sha256) are understood, others are kept but not
+// checked.
+package mtree // import "github.com/thomasf/vfs/mtree"
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// DefaultKeywords are the keywords written by Generate if none are given.
+var DefaultKeywords = []string{"type", "mode", "size", "time", "sha256digest"}
+
+// keywords are the supported keywords in the order they are written.
+var keywords = []string{"type", "mode", "size", "time", "link", "sha256digest"}
+
+// canonical returns the canonical name of the keyword kw.
+func canonical(kw string) string {
+	if kw == "sha256" {
+		return "sha256digest"
+	}
+	return kw
+}
+
+// A Spec is a parsed mtree specification.
+type Spec struct {
+	// Entries are sorted by path.
+	Entries []*Entry
+}
+
+// An Entry is a single file of a Spec.
+type Entry struct {
+	// Path is the rooted, slash separated path of the file, the root
+	// directory is "/".
+	Path string
+	// Keywords are the keywords of the entry, including those set with
+	// /set. Keywords without a value, such as "optional", are empty.
+	Keywords map[string]string
+}
+
+// Type returns the type keyword of e, it defaults to "file".
+func (e *Entry) Type() string {
+	if t, ok := e.Keywords["type"]; ok {
+		return t
+	}
+	return "file"
+}
+
+// Parse reads an mtree specification.
+func Parse(r io.Reader) (*Spec, error) {
+	var (
+		set     = map[string]string{}
+		entries = map[string]*Entry{}
+		cwd     = "/"
+		line    string
+		s       = bufio.NewScanner(r)
+	)
+	for n := 1; s.Scan(); n++ {
+		text := strings.TrimSpace(s.Text())
+		if strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`) {
+			line += text[:len(text)-1] + " "
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "/set":
+			for k, v := range parseKeywords(fields[1:]) {
+				set[k] = v
+			}
+			continue
+		case "/unset":
+			for _, f := range fields[1:] {
+				if f == "all" {
+					set = map[string]string{}
+				}
+				delete(set, canonical(f))
+			}
+			continue
+		case "..":
+			if cwd != "/" {
+				cwd = pathpkg.Dir(cwd)
+			}
+			continue
+		}
+		name, err := unvis(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "mtree line %d", n)
+		}
+		for _, elem := range strings.Split(name, "/") {
+			if elem == ".." {
+				return nil, errors.Errorf("mtree line %d: invalid path %q", n, name)
+			}
+		}
+		e := &Entry{Keywords: map[string]string{}}
+		for k, v := range set {
+			e.Keywords[k] = v
+		}
+		for k, v := range parseKeywords(fields[1:]) {
+			e.Keywords[k] = v
+		}
+		switch {
+		case name == ".":
+			e.Path = "/"
+		case strings.Contains(name, "/"):
+			// full paths are relative to the root and do not change
+			// the current directory.
+			e.Path = pathpkg.Clean("/" + name)
+		default:
+			e.Path = pathpkg.Join(cwd, name)
+			if e.Type() == "dir" {
+				cwd = e.Path
+			}
+		}
+		if prev, ok := entries[e.Path]; ok {
+			// a repeated entry updates the previous one.
+			for k, v := range e.Keywords {
+				prev.Keywords[k] = v
+			}
+			continue
+		}
+		entries[e.Path] = e
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading mtree spec")
+	}
+	spec := &Spec{}
+	for _, e := range entries {
+		spec.Entries = append(spec.Entries, e)
+	}
+	sort.Slice(spec.Entries, func(i, j int) bool {
+		return spec.Entries[i].Path < spec.Entries[j].Path
+	})
+	return spec, nil
+}
+
+func parseKeywords(fields []string) map[string]string {
+	kws := make(map[string]string, len(fields))
+	for _, f := range fields {
+		k, v := f, ""
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			k, v = f[:i], f[i+1:]
+		}
+		kws[canonical(k)] = v
+	}
+	return kws
+}
+
+// vis encodes a file name like strsvis(3) with VIS_OCTAL, which mtree uses
+// for names containing white space and special characters.
+func vis(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '*' || c == '?' || c == '[' {
+			fmt.Fprintf(&b, `\%03o`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// unvis decodes names encoded by vis.
+func unvis(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case i+3 < len(s) && isOctal(s[i+1:i+4]):
+			c, _ := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			b.WriteByte(byte(c))
+			i += 3
+		case i+1 < len(s) && s[i+1] == '\\':
+			b.WriteByte('\\')
+			i++
+		case i+1 < len(s) && s[i+1] == 's':
+			b.WriteByte(' ')
+			i++
+		default:
+			return "", errors.Errorf("invalid escape in %q", s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isOctal(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '7' {
+			return false
+		}
+	}
+	return s[0] <= '3'
+}
+
+// WriteSpec writes spec in the hierarchical format of mtree -c.
+func WriteSpec(w io.Writer, spec *Spec) error {
+	idx := newIndex(spec)
+	var buf bytes.Buffer
+	buf.WriteString("#mtree\n")
+	var write func(p string, depth int)
+	write = func(p string, depth int) {
+		e := idx.entries[p]
+		name := "."
+		if p != "/" {
+			name = vis(pathpkg.Base(p))
+		}
+		fmt.Fprintf(&buf, "%s%s", strings.Repeat("    ", depth), name)
+		for _, kw := range sortedKeywords(e.Keywords) {
+			if v := e.Keywords[kw]; v != "" {
+				fmt.Fprintf(&buf, " %s=%s", kw, v)
+			} else {
+				fmt.Fprintf(&buf, " %s", kw)
+			}
+		}
+		buf.WriteByte('\n')
+		if e.Type() != "dir" {
+			return
+		}
+		for _, name := range idx.children[p] {
+			write(pathpkg.Join(p, name), depth+1)
+		}
+		fmt.Fprintf(&buf, "%s..\n", strings.Repeat("    ", depth+1))
+	}
+	write("/", 0)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// sortedKeywords returns the keywords of kws, the supported ones first.
+func sortedKeywords(kws map[string]string) []string {
+	var known, rest []string
+	for _, kw := range keywords {
+		if _, ok := kws[kw]; ok {
+			known = append(known, kw)
+		}
+	}
+	for kw := range kws {
+		if !isKeyword(kw) {
+			rest = append(rest, kw)
+		}
+	}
+	sort.Strings(rest)
+	return append(known, rest...)
+}
+
+func isKeyword(kw string) bool {
+	for _, k := range keywords {
+		if k == kw {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate walks the tree at root in fs and returns a spec with the given
+// keywords, or DefaultKeywords if none are given. Keywords that do not apply
+// to a file, such as size for directories, are left out.
+func Generate(fs vfs.FileSystem, root string, kws ...string) (*Spec, error) {
+	if len(kws) == 0 {
+		kws = DefaultKeywords
+	}
+	selected := make([]string, len(kws))
+	for i, kw := range kws {
+		selected[i] = canonical(kw)
+		if !isKeyword(selected[i]) {
+			return nil, errors.Errorf("unsupported mtree keyword %q", kw)
+		}
+	}
+	root = pathpkg.Clean("/" + root)
+	spec := &Spec{}
+	err := vfs.Walk(root, fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		e := &Entry{Path: specPath(root, p), Keywords: map[string]string{}}
+		for _, kw := range selected {
+			v, ok, err := value(fs, p, fi, kw)
+			if err != nil {
+				return err
+			}
+			if ok {
+				e.Keywords[kw] = v
+			}
+		}
+		spec.Entries = append(spec.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "mtree")
+	}
+	return spec, nil
+}
+
+// specPath returns the path of p in a spec for the tree at root.
+func specPath(root, p string) string {
+	return pathpkg.Clean("/" + strings.TrimPrefix(p, root))
+}
+
+// value returns the value of the keyword kw for the file p, ok is false if
+// the keyword does not apply to the file.
+func value(fs vfs.FileSystem, p string, fi os.FileInfo, kw string) (v string, ok bool, err error) {
+	switch kw {
+	case "type":
+		return fileType(fi.Mode()), true, nil
+	case "mode":
+		return formatMode(fi.Mode()), true, nil
+	case "size":
+		if !fi.Mode().IsRegular() {
+			return "", false, nil
+		}
+		return strconv.FormatInt(fi.Size(), 10), true, nil
+	case "time":
+		return formatTime(fi.ModTime()), true, nil
+	case "link":
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return "", false, nil
+		}
+		dest, err := vfs.Readlink(fs, p)
+		if err != nil {
+			return "", false, err
+		}
+		return vis(dest), true, nil
+	case "sha256digest":
+		if !fi.Mode().IsRegular() {
+			return "", false, nil
+		}
+		f, err := fs.Open(p)
+		if err != nil {
+			return "", false, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", false, errors.Wrapf(err, "hashing %s", p)
+		}
+		return hex.EncodeToString(h.Sum(nil)), true, nil
+	}
+	return "", false, nil
+}
+
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "link"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "char"
+	case mode&os.ModeDevice != 0:
+		return "block"
+	}
+	return "file"
+}
+
+// typeMode is the inverse of fileType.
+func typeMode(t string) os.FileMode {
+	switch t {
+	case "dir":
+		return os.ModeDir
+	case "link":
+		return os.ModeSymlink
+	case "fifo":
+		return os.ModeNamedPipe
+	case "socket":
+		return os.ModeSocket
+	case "char":
+		return os.ModeDevice | os.ModeCharDevice
+	case "block":
+		return os.ModeDevice
+	}
+	return 0
+}
+
+func formatMode(mode os.FileMode) string {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return fmt.Sprintf("%#o", m)
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 07777 {
+		return 0, errors.Errorf("invalid mode %q", s)
+	}
+	mode := os.FileMode(m).Perm()
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// formatTime formats t as seconds and nanoseconds since the Unix epoch.
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+func parseTime(s string) (time.Time, error) {
+	sec, nsec := s, "0"
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		sec, nsec = s[:i], s[i+1:]
+	}
+	st, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q", s)
+	}
+	nt, err := strconv.ParseInt(nsec, 10, 64)
+	if err != nil || nt < 0 || nt >= 1e9 {
+		return time.Time{}, errors.Errorf("invalid time %q", s)
+	}
+	return time.Unix(st, nt), nil
+}
+
+// normalize returns the value v of kw in the format written by Generate so
+// values can be compared as strings.
+func normalize(kw, v string) string {
+	switch kw {
+	case "mode":
+		if m, err := parseMode(v); err == nil {
+			return formatMode(m)
+		}
+	case "time":
+		if t, err := parseTime(v); err == nil {
+			return formatTime(t)
+		}
+	case "sha256digest":
+		return strings.ToLower(v)
+	case "link":
+		if dest, err := unvis(v); err == nil {
+			return vis(dest)
+		}
+	}
+	return v
+}
+
+// DiffKind describes how a file differs from its spec.
+type DiffKind int
+
+const (
+	// Missing files are in the spec but not in the file system.
+	Missing DiffKind = iota
+	// Extra files are in the file system but not in the spec.
+	Extra
+	// Changed files have a keyword whose value differs from the spec.
+	Changed
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case Missing:
+		return "missing"
+	case Extra:
+		return "extra"
+	}
+	return "changed"
+}
+
+// A Difference is a single mismatch found by Check. Keyword, Want and Got
+// are only set for Changed files.
+type Difference struct {
+	Path      string
+	Kind      DiffKind
+	Keyword   string
+	Want, Got string
+}
+
+func (d Difference) String() string {
+	if d.Kind != Changed {
+		return fmt.Sprintf("%s: %s", d.Path, d.Kind)
+	}
+	return fmt.Sprintf("%s: %s expected %s found %s", d.Path, d.Keyword, d.Want, d.Got)
+}
+
+// Check compares the tree at root in fs with spec and returns the
+// differences sorted by path. Only the supported keywords of each entry are
+// compared, keywords which do not apply to the type of the file are
+// skipped. Entries with the optional keyword may be missing, and nothing
+// below entries with the ignore keyword is checked.
+func Check(fs vfs.FileSystem, root string, spec *Spec) ([]Difference, error) {
+	root = pathpkg.Clean("/" + root)
+	var (
+		ds      []Difference
+		ignored []string
+	)
+	idx := newIndex(spec)
+	for _, e := range spec.Entries {
+		if isIgnored(ignored, e.Path) {
+			continue
+		}
+		if _, ok := e.Keywords["ignore"]; ok {
+			ignored = append(ignored, e.Path)
+		}
+		p := pathpkg.Join(root, e.Path)
+		fi, err := fs.Lstat(p)
+		if os.IsNotExist(err) {
+			if _, ok := e.Keywords["optional"]; !ok {
+				ds = append(ds, Difference{Path: e.Path, Kind: Missing})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "mtree check")
+		}
+		for _, kw := range sortedKeywords(e.Keywords) {
+			if !isKeyword(kw) {
+				continue
+			}
+			got, ok, err := value(fs, p, fi, kw)
+			if err != nil {
+				return nil, errors.Wrap(err, "mtree check")
+			}
+			// keywords which do not apply to the file type are skipped,
+			// such as the size of directories in BSD specs.
+			if !ok {
+				continue
+			}
+			if want := normalize(kw, e.Keywords[kw]); got != want {
+				ds = append(ds, Difference{Path: e.Path, Kind: Changed, Keyword: kw, Want: want, Got: got})
+				if kw == "type" {
+					break
+				}
+			}
+		}
+	}
+	err := vfs.Walk(root, fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		sp := specPath(root, p)
+		if isIgnored(ignored, sp) && !containsPath(ignored, sp) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := idx.entries[sp]; !ok {
+			ds = append(ds, Difference{Path: sp, Kind: Extra})
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "mtree check")
+	}
+	sort.SliceStable(ds, func(i, j int) bool { return ds[i].Path < ds[j].Path })
+	return ds, nil
+}
+
+// isIgnored reports whether p is one of the dirs or below them.
+func isIgnored(dirs []string, p string) bool {
+	for _, d := range dirs {
+		if d == "/" || p == d || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPath(paths []string, p string) bool {
+	for _, q := range paths {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package mtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thomasf/vfs"
+)
+
+var modTime = time.Unix(1500000000, 0)
+
+func testFS(m map[string]string) vfs.FileSystem {
+	return vfs.Normalize(vfs.Map(m), &vfs.NormalizeOptions{ModTime: modTime})
+}
+
+func TestGenerate(t *testing.T) {
+	fs := testFS(map[string]string{
+		"a.txt":          "a",
+		"dir/with space": "space",
+		"dir/sub/b":      "bb",
+	})
+	spec, err := Generate(fs, "/", "type", "size", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteSpec(&buf, spec); err != nil {
+		t.Fatal(err)
+	}
+	want := `#mtree
+. type=dir
+    a.txt type=file size=1 sha256digest=ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb
+    dir type=dir
+        sub type=dir
+            b type=file size=2 sha256digest=3b64db95cb55c763391c707108489ae18b4112d783300de38e033b4c98c3deaf
+            ..
+        with\040space type=file size=5 sha256digest=3f49dbbfe051cb20cc038923424fedf8d18307cc805e1520e4168e9360e2eb38
+        ..
+    ..
+`
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, spec) {
+		t.Fatalf("parsed spec differs from generated spec")
+	}
+	sum, err := vfs.Hash(SpecFS(parsed), "/dir/sub/b", vfs.HashSHA256)
+	if err != nil || fmt.Sprintf("%x", sum) != "3b64db95cb55c763391c707108489ae18b4112d783300de38e033b4c98c3deaf" {
+		t.Fatalf("unexpected digest %x, %v", sum, err)
+	}
+
+	spec, err = Generate(fs, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range spec.Entries {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"/", "/sub", "/sub/b", "/with space"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got paths %v, want %v", paths, want)
+	}
+	if e := spec.Entries[2]; e.Keywords["mode"] != "0644" || e.Keywords["time"] != "1500000000.000000000" {
+		t.Fatalf("unexpected keywords %v", e.Keywords)
+	}
+	if _, err := Generate(fs, "/", "md5digest"); err == nil {
+		t.Fatal("expected error for unsupported keyword")
+	}
+}
+
+func TestParse(t *testing.T) {
+	spec, err := Parse(strings.NewReader(`#mtree
+# comment
+/set type=file mode=0644
+. type=dir mode=0755
+    bin type=dir
+        tool mode=0755 \
+            size=10
+    ..
+    etc type=dir
+        link type=link link=../bin/tool
+        /unset mode
+        conf size=3
+        ..
+..
+./var/log/messages optional
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[string]string{}
+	for _, e := range spec.Entries {
+		got[e.Path] = e.Keywords
+	}
+	want := map[string]map[string]string{
+		"/":                 {"type": "dir", "mode": "0755"},
+		"/bin":              {"type": "dir", "mode": "0644"},
+		"/bin/tool":         {"type": "file", "mode": "0755", "size": "10"},
+		"/etc":              {"type": "dir", "mode": "0644"},
+		"/etc/link":         {"type": "link", "mode": "0644", "link": "../bin/tool"},
+		"/etc/conf":         {"type": "file", "size": "3"},
+		"/var/log/messages": {"type": "file", "optional": ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for _, in := range []string{
+		"a\\9 type=file",
+		"../a type=file",
+	} {
+		if _, err := Parse(strings.NewReader(in)); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	spec, err := Generate(testFS(map[string]string{
+		"a.txt":        "a",
+		"b.txt":        "b",
+		"gone/c":       "c",
+		"cache/x":      "x",
+		"maybe":        "",
+		"dir/same.txt": "same",
+	}), "/", "type", "size", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range spec.Entries {
+		switch e.Path {
+		case "/cache":
+			e.Keywords["ignore"] = ""
+		case "/maybe":
+			e.Keywords["optional"] = ""
+		}
+	}
+	ds, err := Check(testFS(map[string]string{
+		"a.txt":        "A",
+		"b.txt":        "bb",
+		"cache/y":      "y",
+		"dir/same.txt": "same",
+		"new/d":        "d",
+	}), "/", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range ds {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"/a.txt: sha256digest expected ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb found 559aead08264d5795d3909718cdd05abd49572e84fe55590eef31a88a08fdffd",
+		"/b.txt: size expected 1 found 2",
+		"/b.txt: sha256digest expected 3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d found 3b64db95cb55c763391c707108489ae18b4112d783300de38e033b4c98c3deaf",
+		"/gone: missing",
+		"/gone/c: missing",
+		"/new: extra",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	ds, err = Check(testFS(map[string]string{"a.txt/b": ""}), "/", &Spec{Entries: []*Entry{
+		{Path: "/a.txt", Keywords: map[string]string{"type": "file", "size": "1"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, d := range ds {
+		got = append(got, d.String())
+	}
+	if want := []string{"/a.txt: type expected file found dir", "/a.txt/b: extra"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// BSD mtree lists the size of directories as well.
+	spec, err = Parse(strings.NewReader(`#	   user: root
+/set type=file uid=0 gid=0 nlink=1
+.               type=dir nlink=3 size=512
+    a.txt       size=1
+    dir         type=dir nlink=2 size=512
+        b.txt   size=2
+    ..
+..
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err = Check(testFS(map[string]string{"a.txt": "a", "dir/b.txt": "b"}), "/", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, d := range ds {
+		got = append(got, d.String())
+	}
+	if want := []string{"/dir/b.txt: size expected 2 found 1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSpecFS(t *testing.T) {
+	spec, err := Parse(strings.NewReader(`
+. type=dir mode=0755
+    bin type=dir mode=0755
+        tool type=file mode=04755 size=10 time=1500000000.5
+        ..
+    lib type=link link=bin
+..
+./usr/share/doc type=dir
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := SpecFS(spec)
+	fi, err := fs.Stat("/bin/tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 10 || fi.Mode() != os.ModeSetuid|0755 || !fi.ModTime().Equal(time.Unix(1500000000, 5)) {
+		t.Fatalf("unexpected file info %v %v %v", fi.Size(), fi.Mode(), fi.ModTime())
+	}
+	if fi, err := fs.Lstat("/lib"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected symbolic link, got %v, %v", fi, err)
+	}
+	if fi, err := fs.Stat("/lib"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected directory, got %v, %v", fi, err)
+	}
+	if dest, err := vfs.Readlink(fs, "/lib"); err != nil || dest != "bin" {
+		t.Fatalf("unexpected link %q, %v", dest, err)
+	}
+	if _, err := fs.(vfs.Hasher).Hash("/bin/tool", vfs.HashSHA256); err == nil {
+		t.Fatal("expected error without sha256digest")
+	}
+	if _, err := fs.Open("/bin/tool"); err == nil || err.(*os.PathError).Err != ErrNoContents {
+		t.Fatalf("expected ErrNoContents, got %v", err)
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	var paths []string
+	err = vfs.Walk("/", fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/bin", "/bin/tool", "/lib", "/usr", "/usr/share", "/usr/share/doc"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %v, want %v", paths, want)
+	}
+}
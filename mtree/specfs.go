@@ -0,0 +1,185 @@
+package mtree
+
+import (
+	"encoding/hex"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// ErrNoContents is returned when a file of a SpecFS is opened.
+var ErrNoContents = errors.New("mtree spec has no file contents")
+
+// index is the in memory tree of a spec.
+type index struct {
+	entries  map[string]*Entry   // by path
+	children map[string][]string // sorted names by directory path
+}
+
+// newIndex indexes the entries of spec, directories missing from the spec
+// are synthesized.
+func newIndex(spec *Spec) *index {
+	idx := &index{
+		entries:  map[string]*Entry{"/": {Path: "/", Keywords: map[string]string{"type": "dir"}}},
+		children: map[string][]string{},
+	}
+	for _, e := range spec.Entries {
+		idx.entries[e.Path] = e
+	}
+	for _, e := range spec.Entries {
+		for p := e.Path; p != "/"; p = pathpkg.Dir(p) {
+			dir := pathpkg.Dir(p)
+			idx.children[dir] = append(idx.children[dir], pathpkg.Base(p))
+			if _, ok := idx.entries[dir]; ok {
+				break
+			}
+			idx.entries[dir] = &Entry{Path: dir, Keywords: map[string]string{"type": "dir"}}
+		}
+	}
+	for _, names := range idx.children {
+		sort.Strings(names)
+	}
+	return idx
+}
+
+// SpecFS returns a metadata only FileSystem of the files in spec, for
+// example to do a dry run of an operation. Stat, Lstat and ReadDir report
+// the type, mode, size and time keywords of the entries and Readlink
+// returns the link keyword. Open always returns ErrNoContents.
+func SpecFS(spec *Spec) vfs.FileSystem {
+	return &specFS{newIndex(spec)}
+}
+
+type specFS struct {
+	idx *index
+}
+
+func (fs *specFS) String() string {
+	return "mtree"
+}
+
+func (fs *specFS) entry(op, path string) (*Entry, error) {
+	path = pathpkg.Clean("/" + path)
+	e, ok := fs.idx.entries[path]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fs *specFS) Open(path string) (vfs.ReadSeekCloser, error) {
+	e, err := fs.entry("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &os.PathError{Op: "open", Path: e.Path, Err: ErrNoContents}
+}
+
+func (fs *specFS) Lstat(path string) (os.FileInfo, error) {
+	e, err := fs.entry("lstat", path)
+	if err != nil {
+		return nil, err
+	}
+	return specFI{e}, nil
+}
+
+func (fs *specFS) Stat(path string) (os.FileInfo, error) {
+	e, err := fs.entry("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	// follow symbolic links within the spec.
+	for i := 0; e.Type() == "link" && i < 255; i++ {
+		dest, err := unvis(e.Keywords["link"])
+		if err != nil || dest == "" {
+			break
+		}
+		if !pathpkg.IsAbs(dest) {
+			dest = pathpkg.Join(pathpkg.Dir(e.Path), dest)
+		}
+		if e, err = fs.entry("stat", dest); err != nil {
+			return nil, err
+		}
+	}
+	return specFI{e}, nil
+}
+
+func (fs *specFS) ReadDir(path string) ([]os.FileInfo, error) {
+	e, err := fs.entry("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if e.Type() != "dir" {
+		return nil, &os.PathError{Op: "readdir", Path: e.Path, Err: errors.New("not a directory")}
+	}
+	names := fs.idx.children[e.Path]
+	fis := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		fis[i] = specFI{fs.idx.entries[pathpkg.Join(e.Path, name)]}
+	}
+	return fis, nil
+}
+
+// Hash implements the vfs.Hasher interface with the sha256digest keyword.
+func (fs *specFS) Hash(path, algo string) ([]byte, error) {
+	e, err := fs.entry("hash", path)
+	if err != nil {
+		return nil, err
+	}
+	sum, ok := e.Keywords["sha256digest"]
+	if algo != vfs.HashSHA256 || !ok {
+		return nil, &os.PathError{Op: "hash", Path: e.Path, Err: vfs.ErrHashUnsupported}
+	}
+	return hex.DecodeString(sum)
+}
+
+func (fs *specFS) Readlink(path string) (string, error) {
+	e, err := fs.entry("readlink", path)
+	if err != nil {
+		return "", err
+	}
+	if e.Type() != "link" {
+		return "", &os.PathError{Op: "readlink", Path: e.Path, Err: errors.New("not a symbolic link")}
+	}
+	return unvis(e.Keywords["link"])
+}
+
+// specFI is the os.FileInfo of an entry, missing keywords are reported as
+// zero values except for mode which defaults to 0644 or 0755.
+type specFI struct {
+	e *Entry
+}
+
+func (fi specFI) Name() string {
+	return pathpkg.Base(fi.e.Path)
+}
+
+func (fi specFI) Size() int64 {
+	n, _ := strconv.ParseInt(fi.e.Keywords["size"], 10, 64)
+	return n
+}
+
+func (fi specFI) Mode() os.FileMode {
+	t := fi.e.Type()
+	perm, err := parseMode(fi.e.Keywords["mode"])
+	if err != nil {
+		perm = 0644
+		if t == "dir" {
+			perm = 0755
+		}
+	}
+	return typeMode(t) | perm
+}
+
+func (fi specFI) ModTime() time.Time {
+	t, _ := parseTime(fi.e.Keywords["time"])
+	return t
+}
+
+func (fi specFI) IsDir() bool      { return fi.e.Type() == "dir" }
+func (fi specFI) Sys() interface{} { return fi.e }
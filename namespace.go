@@ -209,6 +209,8 @@ func safe(fs FileSystem) FileSystemFunc {
 	}
 }
 
+// BindSafe is like Bind but verifies that newfs is configured correctly and
+// that new is a directory or, for single file mounts, a regular file.
 func (ns NameSpace) BindSafe(old string, newfs FileSystemFunc, new string, mode BindMode) error {
 	fs, err := newfs()
 	if err != nil {
@@ -218,8 +220,8 @@ func (ns NameSpace) BindSafe(old string, newfs FileSystemFunc, new string, mode
 	if err != nil {
 		return errors.Wrapf(err, "can read pathh %s %s %v", old, new, mode)
 	}
-	if !fi.IsDir() {
-		return errors.Errorf("can not mount %s on %s, %s is not a directory", old, new, old)
+	if !fi.IsDir() && !fi.Mode().IsRegular() {
+		return errors.Errorf("can not mount %s on %s, %s is not a directory or a regular file", new, old, new)
 	}
 	ns.Bind(old, fs, new, mode)
 	// ns.Bind(old string, newfs FileSystemFunc, new string, mode BindMode)
@@ -227,6 +229,27 @@ func (ns NameSpace) BindSafe(old string, newfs FileSystemFunc, new string, mode
 	return nil
 }
 
+// BindFile binds the single OS file at file to the path old. It panics if
+// old is the root directory, which can only be a directory.
+func (ns NameSpace) BindFile(old, file string, mode BindMode) {
+	old = ns.clean(old)
+	if old == "/" {
+		panic(fmt.Sprintf("invalid BindFile: can not mount the file %s on /", file))
+	}
+	name := pathpkg.Base(old)
+	ns.Bind(old, OneFile(file, name), "/"+name, mode)
+}
+
+// BindFileSafe is like BindFile but verifies that file is a regular file.
+func (ns NameSpace) BindFileSafe(old, file string, mode BindMode) error {
+	old = ns.clean(old)
+	if old == "/" {
+		return errors.Errorf("can not mount the file %s on /", file)
+	}
+	name := pathpkg.Base(old)
+	return ns.BindSafe(old, SafeOneFile(file, name), "/"+name, mode)
+}
+
 // resolve resolves a path to the list of mountedFS to use for path.
 func (ns NameSpace) resolve(path string) []mountedFS {
 	path = ns.clean(path)
@@ -269,12 +292,19 @@ func (ns NameSpace) Open(path string) (ReadSeekCloser, error) {
 	return nil, err
 }
 
-// stat implements the FileSystem Stat and Lstat methods.
-func (ns NameSpace) stat(path string, f func(FileSystem, string) (os.FileInfo, error)) (os.FileInfo, error) {
+// stat implements the FileSystem Stat and Lstat methods, synthesized
+// directories are configured by opts.
+func (ns NameSpace) stat(path string, f func(FileSystem, string) (os.FileInfo, error), opts *NameSpaceOptions) (os.FileInfo, error) {
+	path = ns.clean(path)
 	var err error
 	for _, m := range ns.resolve(path) {
 		fi, err1 := f(m.fs, m.translate(path))
 		if err1 == nil {
+			// The root of a mounted file system is named after its own
+			// location, report the name it has in the name space instead.
+			if path != "/" && fi.Name() != pathpkg.Base(path) {
+				fi = renamedFileInfo(fi, pathpkg.Base(path))
+			}
 			return fi, nil
 		}
 		if err == nil {
@@ -284,13 +314,7 @@ func (ns NameSpace) stat(path string, f func(FileSystem, string) (os.FileInfo, e
 	if os.IsNotExist(err) {
 		for old := range ns {
 			if hasPathPrefix(old, path) && old != path {
-				// Find next element after path in old.
-				elem := old[len(path):]
-				elem = strings.TrimPrefix(elem, "/")
-				if i := strings.Index(elem, "/"); i >= 0 {
-					elem = elem[:i]
-				}
-				return dirInfo(elem), nil
+				return opts.dirInfo(pathpkg.Base(path)), nil
 			}
 		}
 	}
@@ -301,13 +325,116 @@ func (ns NameSpace) stat(path string, f func(FileSystem, string) (os.FileInfo, e
 }
 
 func (ns NameSpace) Stat(path string) (os.FileInfo, error) {
-	return ns.stat(path, FileSystem.Stat)
+	return ns.stat(path, FileSystem.Stat, nil)
 }
 
 func (ns NameSpace) Lstat(path string) (os.FileInfo, error) {
-	return ns.stat(path, FileSystem.Lstat)
+	return ns.stat(path, FileSystem.Lstat, nil)
 }
 
+// Readlink implements the Readlinker interface.
+func (ns NameSpace) Readlink(path string) (string, error) {
+	var err error
+	for _, m := range ns.resolve(path) {
+		dst, err1 := Readlink(m.fs, m.translate(path))
+		if err1 == nil {
+			return dst, nil
+		}
+		if err == nil || os.IsNotExist(err) {
+			err = err1
+		}
+	}
+	if err == nil {
+		err = &os.PathError{Op: "readlink", Path: path, Err: os.ErrNotExist}
+	}
+	return "", err
+}
+
+// Hash implements the Hasher interface by asking the file system the file
+// is served from. ErrHashUnsupported is returned if that file system is not
+// a Hasher, so Hash reads the file.
+func (ns NameSpace) Hash(path, algo string) ([]byte, error) {
+	for _, m := range ns.resolve(path) {
+		tp := m.translate(path)
+		var (
+			sum []byte
+			err error
+		)
+		if h, ok := m.fs.(Hasher); ok {
+			sum, err = h.Hash(tp, algo)
+		} else if _, err = m.fs.Stat(tp); err == nil {
+			err = &os.PathError{Op: "hash", Path: path, Err: ErrHashUnsupported}
+		}
+		if !os.IsNotExist(err) {
+			return sum, err
+		}
+	}
+	return nil, &os.PathError{Op: "hash", Path: path, Err: os.ErrNotExist}
+}
+
+// NameSpaceOptions configures a NameSpace created by
+// NewNameSpaceWithOptions.
+type NameSpaceOptions struct {
+	// DirModTime is the modification time of the directories synthesized
+	// to reach mount points. It defaults to the time the process started,
+	// set it for deterministic metadata.
+	DirModTime time.Time
+	// DirMode is the permission bits of synthesized directories, it
+	// defaults to 0555.
+	DirMode os.FileMode
+}
+
+// An OptionsNameSpace is a NameSpace whose synthesized directories are
+// configured by NameSpaceOptions.
+type OptionsNameSpace struct {
+	NameSpace
+	opts NameSpaceOptions
+}
+
+// NewNameSpaceWithOptions is like NewNameSpace but configures the
+// directories synthesized to reach mount points with opts.
+func NewNameSpaceWithOptions(opts NameSpaceOptions) OptionsNameSpace {
+	return OptionsNameSpace{NewNameSpace(), opts}
+}
+
+func (ns OptionsNameSpace) Stat(path string) (os.FileInfo, error) {
+	return ns.stat(path, FileSystem.Stat, &ns.opts)
+}
+
+func (ns OptionsNameSpace) Lstat(path string) (os.FileInfo, error) {
+	return ns.stat(path, FileSystem.Lstat, &ns.opts)
+}
+
+func (ns OptionsNameSpace) ReadDir(path string) ([]os.FileInfo, error) {
+	return ns.readDir(path, &ns.opts)
+}
+
+// dirInfo returns the file info of a synthesized directory, opts may be
+// nil.
+func (opts *NameSpaceOptions) dirInfo(name string) os.FileInfo {
+	if opts == nil {
+		return dirInfo(name)
+	}
+	fi := nsDirInfo{dirInfo: dirInfo(name), modTime: startTime, mode: os.ModeDir | 0555}
+	if !opts.DirModTime.IsZero() {
+		fi.modTime = opts.DirModTime
+	}
+	if opts.DirMode != 0 {
+		fi.mode = os.ModeDir | opts.DirMode.Perm()
+	}
+	return fi
+}
+
+// nsDirInfo is a synthesized directory configured by NameSpaceOptions.
+type nsDirInfo struct {
+	dirInfo
+	modTime time.Time
+	mode    os.FileMode
+}
+
+func (d nsDirInfo) Mode() os.FileMode  { return d.mode }
+func (d nsDirInfo) ModTime() time.Time { return d.modTime }
+
 // dirInfo is a trivial implementation of os.FileInfo for a directory.
 type dirInfo string
 
@@ -333,6 +460,12 @@ var startTime = time.Now()
 // entry for it before returning.
 //
 func (ns NameSpace) ReadDir(path string) ([]os.FileInfo, error) {
+	return ns.readDir(path, nil)
+}
+
+// readDir implements ReadDir, synthesized directories are configured by
+// opts.
+func (ns NameSpace) readDir(path string, opts *NameSpaceOptions) ([]os.FileInfo, error) {
 	path = ns.clean(path)
 
 	var (
@@ -374,12 +507,20 @@ func (ns NameSpace) ReadDir(path string) ([]os.FileInfo, error) {
 			// Find next element after path in old.
 			elem := old[len(path):]
 			elem = strings.TrimPrefix(elem, "/")
-			if i := strings.Index(elem, "/"); i >= 0 {
+			i := strings.Index(elem, "/")
+			if i >= 0 {
 				elem = elem[:i]
 			}
 			if !haveName[elem] {
 				haveName[elem] = true
-				all = append(all, dirInfo(elem))
+				// single files are mounted directly in path.
+				if i < 0 {
+					if fi, err := ns.stat(old, FileSystem.Stat, opts); err == nil && !fi.IsDir() {
+						all = append(all, fi)
+						continue
+					}
+				}
+				all = append(all, opts.dirInfo(elem))
 			}
 		}
 	}
@@ -114,7 +114,6 @@ func TestBindSafe(t *testing.T) {
 
 	const (
 		nx = "file does not exist"
-		nd = "not a directory"
 		ok = ""
 	)
 	testCases := [][]string{
@@ -126,10 +125,10 @@ func TestBindSafe(t *testing.T) {
 		{"/1", ok},
 		{"/1/", ok},
 		{"/1/", ok},
-		{"/1/A/6", nd},
-		{"/1/B/6", nd},
+		{"/1/A/6", ok},
+		{"/1/B/6", ok},
 		{"/1/B/", ok},
-		{"/2", nd},
+		{"/2", ok},
 	}
 
 	ns := NewNameSpace()
@@ -162,6 +161,8 @@ data: test-fixtures/C/animals/cats/cats
 dir : /10
 file: /10/6
 data: test-fixtures/C/animals/cats/C-cats
+file: /11
+data: test-fixtures/C/animals/cats/cats
 dir : /2
 dir : /2/1
 file: /2/1/6
@@ -203,6 +204,10 @@ file: /7/A/6
 data: test-fixtures/C/animals/cats/cats
 dir : /7/B
 file: /7/B/6
+data: test-fixtures/C/animals/cats/C-cats
+file: /8
+data: test-fixtures/C/animals/cats/cats
+file: /9
 data: test-fixtures/C/animals/cats/C-cats`)
 }
 
@@ -341,3 +346,68 @@ dir : /new/dogs
 file: /new/dogs/fake-dog
 data: C/animals/cats/cats`)
 }
+
+func TestBindFile(t *testing.T) {
+	ns := NewNameSpace()
+	ns.Bind("/", OS(testPath("A")), "/", BindReplace)
+	ns.BindFile("/ships/cat", testPath("C/animals/cats/cats"), BindAfter)
+	bindOrDie(t, ns.BindFileSafe("/etc/app/config.yaml", testPath("C/animals/cats/C-cats"), BindReplace))
+	bindOrDie(t, ns.BindSafe("/nested", SafeOneFile(testPath("C/animals/cats/cats"), "a/b/cat"), "/", BindReplace))
+	if err := ns.BindFileSafe("/etc/dir", testPath("C/animals"), BindReplace); err == nil {
+		t.Fatal("expected error binding a directory as a file")
+	}
+	if err := ns.BindFileSafe("/", testPath("C/animals/cats/cats"), BindReplace); err == nil {
+		t.Fatal("expected error binding a file on /")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected BindFile on / to panic")
+			}
+		}()
+		ns.BindFile("/", testPath("C/animals/cats/cats"), BindReplace)
+	}()
+	for _, name := range []string{"", "/", "a/../..", "a/./b"} {
+		if _, err := SafeOneFile(testPath("C/animals/cats/cats"), name)(); err == nil {
+			t.Fatalf("expected error for the file name %q", name)
+		}
+	}
+
+	assertIsRegular(t, ns,
+		"/ships/cat",
+		"/etc/app/config.yaml",
+		"/nested/a/b/cat",
+	)
+	assertIsDir(t, ns,
+		"/etc/app",
+		"/nested/a",
+	)
+	assertOSPather(t, ns, map[string]string{
+		"/etc/app/config.yaml": testPath("C/animals/cats/C-cats"),
+		"/nested/a/b/cat":      testPath("C/animals/cats/cats"),
+	})
+	assertWalk(t, ns, `dir : /
+dir : /animals
+dir : /animals/dogs
+file: /animals/dogs/A-dogs
+data: A/animals/dogs/A-dogs
+file: /animals/dogs/dogs
+data: A/animals/dogs/dogs
+dir : /etc
+dir : /etc/app
+file: /etc/app/config.yaml
+data: C/animals/cats/C-cats
+dir : /nested
+dir : /nested/a
+dir : /nested/a/b
+file: /nested/a/b/cat
+data: C/animals/cats/cats
+dir : /ships
+dir : /ships/battleships
+file: /ships/battleships/A-battleships
+data: A/ships/battleships/A-battleships
+file: /ships/battleships/battleships
+data: A/ships/battleships/battleships
+file: /ships/cat
+data: C/animals/cats/cats`)
+}
@@ -0,0 +1,431 @@
+package ninep
+
+import (
+	"io"
+	"net"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Dial connects to the 9P2000 file server at the network address and
+// returns it as a vfs.FileSystem, see Client.
+func Dial(network, addr string) (vfs.FileSystem, error) {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := Client(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Client negotiates the protocol version on rwc, attaches to the root of the
+// file server and returns it as a read only vfs.FileSystem. The returned
+// FileSystem implements io.Closer which closes rwc. It is safe for
+// concurrent use.
+func Client(rwc io.ReadWriteCloser) (vfs.FileSystem, error) {
+	c := &client{
+		rwc:     rwc,
+		name:    "conn",
+		tags:    make(map[uint16]chan *fcall),
+		nextFid: rootFid + 1,
+	}
+	if nc, ok := rwc.(net.Conn); ok {
+		c.name = nc.RemoteAddr().String()
+	}
+
+	treq := &fcall{Type: msgTversion, Tag: noTag, Msize: defaultMsize, Version: Version}
+	if _, err := rwc.Write(treq.marshal()); err != nil {
+		return nil, errors.Wrap(err, "9p: version")
+	}
+	resp, err := readMsg(rwc, defaultMsize)
+	if err != nil {
+		return nil, errors.Wrap(err, "9p: version")
+	}
+	switch {
+	case resp.Type == msgRerror:
+		return nil, errors.Errorf("9p: version: %s", resp.Ename)
+	case resp.Type != msgRversion:
+		return nil, errors.Errorf("9p: version: unexpected message type %d", resp.Type)
+	case resp.Version != Version:
+		return nil, errors.Errorf("9p: server speaks %q, not %q", resp.Version, Version)
+	case resp.Msize < minMsize || resp.Msize > defaultMsize:
+		return nil, errors.Errorf("9p: bad msize %d", resp.Msize)
+	}
+	c.msize = resp.Msize
+	go c.readLoop()
+
+	if _, err := c.rpc(&fcall{Type: msgTattach, Fid: rootFid, Afid: noFid, Uname: "none"}); err != nil {
+		c.Close()
+		return nil, errors.Wrap(err, "9p: attach")
+	}
+	return c, nil
+}
+
+const rootFid uint32 = 0
+
+type client struct {
+	rwc   io.ReadWriteCloser
+	name  string
+	msize uint32
+
+	wmu sync.Mutex // serializes writes to rwc
+
+	mu       sync.Mutex
+	err      error // set when the connection is gone
+	tags     map[uint16]chan *fcall
+	nextTag  uint16
+	freeFids []uint32
+	nextFid  uint32
+}
+
+func (c *client) String() string {
+	return "9p(" + c.name + ")"
+}
+
+// Close closes the underlying connection.
+func (c *client) Close() error {
+	return c.rwc.Close()
+}
+
+// readLoop delivers responses to the waiting rpc calls until the connection
+// fails.
+func (c *client) readLoop() {
+	var err error
+	for {
+		var resp *fcall
+		resp, err = readMsg(c.rwc, c.msize)
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		ch, ok := c.tags[resp.Tag]
+		delete(c.tags, resp.Tag)
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.mu.Lock()
+	c.err = errors.Wrap(err, "9p: connection lost")
+	for tag, ch := range c.tags {
+		close(ch)
+		delete(c.tags, tag)
+	}
+	c.mu.Unlock()
+}
+
+// rpc sends req and waits for its response. Rerror responses are returned
+// as errors.
+func (c *client) rpc(req *fcall) (*fcall, error) {
+	ch := make(chan *fcall, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	for {
+		c.nextTag++
+		if c.nextTag == noTag {
+			c.nextTag = 0
+		}
+		if _, ok := c.tags[c.nextTag]; !ok {
+			break
+		}
+	}
+	req.Tag = c.nextTag
+	c.tags[req.Tag] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	_, err := c.rwc.Write(req.marshal())
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.tags, req.Tag)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	resp, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	if resp.Type == msgRerror {
+		return nil, errors.New(resp.Ename)
+	}
+	if resp.Type != req.Type+1 {
+		return nil, errors.Errorf("9p: unexpected message type %d", resp.Type)
+	}
+	return resp, nil
+}
+
+func (c *client) allocFid() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.freeFids); n > 0 {
+		f := c.freeFids[n-1]
+		c.freeFids = c.freeFids[:n-1]
+		return f
+	}
+	c.nextFid++
+	return c.nextFid - 1
+}
+
+func (c *client) freeFid(f uint32) {
+	c.mu.Lock()
+	c.freeFids = append(c.freeFids, f)
+	c.mu.Unlock()
+}
+
+func (c *client) clunk(f uint32) {
+	c.rpc(&fcall{Type: msgTclunk, Fid: f})
+	c.freeFid(f)
+}
+
+// pathError converts an error from the server into an *os.PathError,
+// mapping the well known error strings back to the os errors.
+func pathError(op, p string, err error) error {
+	switch err.Error() {
+	case errNotExist:
+		err = os.ErrNotExist
+	case errPermission:
+		err = os.ErrPermission
+	}
+	return &os.PathError{Op: op, Path: p, Err: err}
+}
+
+// walk returns a new fid for p.
+func (c *client) walk(op, p string) (uint32, error) {
+	var names []string
+	if p = pathpkg.Clean("/" + p); p != "/" {
+		names = strings.Split(p[1:], "/")
+	}
+	f := c.allocFid()
+	from := rootFid
+	for {
+		n := len(names)
+		if n > maxWalkElem {
+			n = maxWalkElem
+		}
+		resp, err := c.rpc(&fcall{Type: msgTwalk, Fid: from, Newfid: f, Wname: names[:n]})
+		if err == nil && len(resp.Wqid) != n {
+			err = errors.New(errNotExist)
+		}
+		if err != nil {
+			if from == f {
+				c.clunk(f)
+			} else {
+				c.freeFid(f)
+			}
+			return 0, pathError(op, p, err)
+		}
+		names = names[n:]
+		if len(names) == 0 {
+			return f, nil
+		}
+		from = f
+	}
+}
+
+func (c *client) stat(op string, f uint32, p string) (*fileInfo, error) {
+	resp, err := c.rpc(&fcall{Type: msgTstat, Fid: f})
+	if err != nil {
+		return nil, pathError(op, p, err)
+	}
+	ds, err := unmarshalDirs(resp.Stat)
+	if err == nil && len(ds) != 1 {
+		err = errors.Errorf("got %d entries", len(ds))
+	}
+	if err != nil {
+		return nil, pathError(op, p, err)
+	}
+	return newFileInfo(&ds[0]), nil
+}
+
+func (c *client) Lstat(p string) (os.FileInfo, error) {
+	return c.Stat(p)
+}
+
+func (c *client) Stat(p string) (os.FileInfo, error) {
+	f, err := c.walk("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.clunk(f)
+	return c.stat("stat", f, p)
+}
+
+func (c *client) ReadDir(p string) ([]os.FileInfo, error) {
+	f, err := c.walk("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.clunk(f)
+	resp, err := c.rpc(&fcall{Type: msgTopen, Fid: f, Mode: oREAD})
+	if err != nil {
+		return nil, pathError("readdir", p, err)
+	}
+	if resp.Qid.Type&qtDIR == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: errors.New(errNotDir)}
+	}
+	var fis []os.FileInfo
+	var offset uint64
+	for {
+		resp, err := c.rpc(&fcall{Type: msgTread, Fid: f, Offset: offset, Count: c.msize - ioHeaderSize})
+		if err != nil {
+			return nil, pathError("readdir", p, err)
+		}
+		if len(resp.Data) == 0 {
+			break
+		}
+		offset += uint64(len(resp.Data))
+		ds, err := unmarshalDirs(resp.Data)
+		if err != nil {
+			return nil, pathError("readdir", p, err)
+		}
+		for i := range ds {
+			fis = append(fis, newFileInfo(&ds[i]))
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+func (c *client) Open(p string) (vfs.ReadSeekCloser, error) {
+	f, err := c.walk("open", p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := c.stat("open", f, p)
+	if err != nil {
+		c.clunk(f)
+		return nil, err
+	}
+	if fi.IsDir() {
+		c.clunk(f)
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	resp, err := c.rpc(&fcall{Type: msgTopen, Fid: f, Mode: oREAD})
+	if err != nil {
+		c.clunk(f)
+		return nil, pathError("open", p, err)
+	}
+	iounit := resp.Iounit
+	if iounit == 0 || iounit > c.msize-ioHeaderSize {
+		iounit = c.msize - ioHeaderSize
+	}
+	return &file{c: c, fid: f, path: p, size: fi.size, iounit: iounit}, nil
+}
+
+// file is an open file on the server, reads are issued at the current
+// offset.
+type file struct {
+	c      *client
+	fid    uint32
+	path   string
+	size   int64
+	offset int64
+	iounit uint32
+	closed bool
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	count := f.iounit
+	if uint32(len(p)) < count {
+		count = uint32(len(p))
+	}
+	resp, err := f.c.rpc(&fcall{Type: msgTread, Fid: f.fid, Offset: uint64(f.offset), Count: count})
+	if err != nil {
+		return 0, pathError("read", f.path, err)
+	}
+	if len(resp.Data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, resp.Data)
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("invalid whence")}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("negative position")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.c.clunk(f.fid)
+	return nil
+}
+
+// fileInfo is the os.FileInfo for a 9P directory entry.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(d *dir) *fileInfo {
+	fi := &fileInfo{
+		name: d.Name,
+		size: int64(d.Length),
+		mode: os.FileMode(d.Mode & 0777),
+	}
+	switch {
+	case d.Mode&dmDIR != 0:
+		fi.mode |= os.ModeDir
+	case d.Mode&dmSYMLINK != 0:
+		fi.mode |= os.ModeSymlink
+	}
+	if d.Mtime != 0 {
+		fi.modTime = time.Unix(int64(d.Mtime), 0)
+	}
+	return fi
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
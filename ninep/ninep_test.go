@@ -0,0 +1,208 @@
+package ninep
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/vfstest"
+)
+
+// pipe serves fs over net.Pipe and returns a client for it.
+func pipe(t *testing.T, fs vfs.FileSystem) vfs.FileSystem {
+	t.Helper()
+	sc, cc := net.Pipe()
+	go ServeConn(sc, fs)
+	c, err := Client(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.(io.Closer).Close() })
+	return c
+}
+
+func TestClientServer(t *testing.T) {
+	ns := vfs.NewNameSpace()
+	ns.Bind("/", vfs.OS("../test-fixtures/B"), "/", vfs.BindReplace)
+	ns.Bind("/mem", vfs.Map(map[string]string{
+		"a/b.txt": "bbb",
+		"c.txt":   "c",
+		"empty":   "",
+	}), "/", vfs.BindReplace)
+
+	c := pipe(t, ns)
+	if err := vfstest.TestFS(c, "things/wood/table/table", "mem/a/b.txt", "mem/c.txt", "mem/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client can itself be bound into another NameSpace.
+	ns2 := vfs.NewNameSpace()
+	ns2.Bind("/remote", c, "/mem", vfs.BindReplace)
+	data, err := vfs.ReadFile(ns2, "/remote/a/b.txt")
+	if err != nil || string(data) != "bbb" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := pipe(t, vfs.ModeMap(vfs.Map(map[string]string{
+		"dir/file": "data",
+	}), map[string]os.FileMode{"dir/file": 0640}))
+
+	for _, p := range []string{"/missing", "/dir/missing", "/dir/file/below"} {
+		if _, err := c.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Stat(%q): expected not exist, got %v", p, err)
+		}
+		if _, err := c.Open(p); !os.IsNotExist(err) {
+			t.Errorf("Open(%q): expected not exist, got %v", p, err)
+		}
+	}
+	if _, err := c.Open("/dir"); err == nil {
+		t.Error("expected error opening a directory")
+	}
+	if _, err := c.ReadDir("/dir/file"); err == nil {
+		t.Error("expected error reading a file as a directory")
+	}
+	fi, err := c.Stat("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0640 || fi.Size() != 4 || fi.Name() != "file" {
+		t.Fatalf("unexpected file info %v %v %v", fi.Name(), fi.Mode(), fi.Size())
+	}
+	if fi, err := c.Stat("/dir/../dir/./file"); err != nil || fi.Name() != "file" {
+		t.Fatalf("unexpected result for unclean path: %v %v", fi, err)
+	}
+}
+
+func TestLarge(t *testing.T) {
+	m := map[string]string{}
+	// a directory listing which spans several reads.
+	for i := 0; i < 2000; i++ {
+		m[fmt.Sprintf("many/%04d-%s", i, strings.Repeat("x", 40))] = "x"
+	}
+	// a path deeper than a single walk.
+	deep := strings.Repeat("d/", maxWalkElem*2+3) + "file"
+	m[deep] = "deep"
+	// a file larger than a single read.
+	large := strings.Repeat("0123456789", 3*defaultMsize/10)
+	m["large"] = large
+	c := pipe(t, vfs.Map(m))
+
+	fis, err := c.ReadDir("/many")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2000 || !strings.HasPrefix(fis[1999].Name(), "1999-") {
+		t.Fatalf("unexpected listing of %d entries", len(fis))
+	}
+
+	data, err := vfs.ReadFile(c, deep)
+	if err != nil || string(data) != "deep" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	f, err := c.Open("/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err = ioutil.ReadAll(f)
+	if err != nil || string(data) != large {
+		t.Fatalf("unexpected content of %d bytes: %v", len(data), err)
+	}
+	if _, err := f.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(f)
+	if err != nil || string(data) != "56789" {
+		t.Fatalf("unexpected content after seek %q: %v", data, err)
+	}
+}
+
+// rawConn sends single requests to a server.
+type rawConn struct {
+	t  *testing.T
+	cc net.Conn
+}
+
+func (r *rawConn) rpc(req *fcall) *fcall {
+	r.t.Helper()
+	if _, err := r.cc.Write(req.marshal()); err != nil {
+		r.t.Fatal(err)
+	}
+	resp, err := readMsg(r.cc, defaultMsize)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if resp.Tag != req.Tag {
+		r.t.Fatalf("tag %d, want %d", resp.Tag, req.Tag)
+	}
+	return resp
+}
+
+func TestServerProtocol(t *testing.T) {
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go ServeConn(sc, vfs.Map(map[string]string{"a/b": "b"}))
+	r := &rawConn{t, cc}
+
+	resp := r.rpc(&fcall{Type: msgTversion, Tag: noTag, Msize: 1 << 20, Version: "9P2000.L"})
+	if resp.Type != msgRversion || resp.Version != Version || resp.Msize != defaultMsize {
+		t.Fatalf("unexpected version response %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTattach, Tag: 1, Fid: 1, Afid: 7}); resp.Type != msgRerror {
+		t.Fatalf("expected authentication to be rejected, got %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTattach, Tag: 1, Fid: 1, Afid: noFid}); resp.Type != msgRattach || resp.Qid.Type != qtDIR {
+		t.Fatalf("unexpected attach response %+v", resp)
+	}
+
+	// a partial walk returns the qids walked so far and leaves newfid unset.
+	resp = r.rpc(&fcall{Type: msgTwalk, Tag: 2, Fid: 1, Newfid: 2, Wname: []string{"a", "missing"}})
+	if resp.Type != msgRwalk || len(resp.Wqid) != 1 {
+		t.Fatalf("unexpected walk response %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTstat, Tag: 2, Fid: 2}); resp.Type != msgRerror || resp.Ename != errUnknownFid {
+		t.Fatalf("expected unknown fid, got %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTwalk, Tag: 2, Fid: 1, Newfid: 2, Wname: []string{"a", "..", "a", "b"}}); resp.Type != msgRwalk || len(resp.Wqid) != 4 {
+		t.Fatalf("unexpected walk response %+v", resp)
+	}
+
+	// modifying requests are refused.
+	if resp := r.rpc(&fcall{Type: msgTopen, Tag: 3, Fid: 2, Mode: oWRITE}); resp.Type != msgRerror || resp.Ename != errPermission {
+		t.Fatalf("expected open for writing to fail, got %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTremove, Tag: 3, Fid: 2}); resp.Type != msgRerror || resp.Ename != errPermission {
+		t.Fatalf("expected remove to fail, got %+v", resp)
+	}
+	// remove clunks the fid even though it failed.
+	if resp := r.rpc(&fcall{Type: msgTclunk, Tag: 3, Fid: 2}); resp.Type != msgRerror {
+		t.Fatalf("expected fid to be clunked by remove, got %+v", resp)
+	}
+
+	// directory reads must continue at the end of the previous read.
+	if resp := r.rpc(&fcall{Type: msgTopen, Tag: 4, Fid: 1, Mode: oREAD}); resp.Type != msgRopen {
+		t.Fatalf("unexpected open response %+v", resp)
+	}
+	resp = r.rpc(&fcall{Type: msgTread, Tag: 4, Fid: 1, Offset: 0, Count: 8192})
+	ds, err := unmarshalDirs(resp.Data)
+	if err != nil || len(ds) != 1 || ds[0].Name != "a" || ds[0].Mode&dmDIR == 0 {
+		t.Fatalf("unexpected directory read %+v: %v", ds, err)
+	}
+	if resp := r.rpc(&fcall{Type: msgTread, Tag: 4, Fid: 1, Offset: 3, Count: 8192}); resp.Type != msgRerror || resp.Ename != errBadOffset {
+		t.Fatalf("expected bad offset, got %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTread, Tag: 4, Fid: 1, Offset: uint64(len(resp.Data)), Count: 8192}); resp.Type != msgRread || len(resp.Data) != 0 {
+		t.Fatalf("expected end of directory, got %+v", resp)
+	}
+	if resp := r.rpc(&fcall{Type: msgTwalk, Tag: 5, Fid: 1, Newfid: 3}); resp.Type != msgRerror || resp.Ename != errIsOpen {
+		t.Fatalf("expected walk from an open fid to fail, got %+v", resp)
+	}
+}
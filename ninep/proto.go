@@ -0,0 +1,409 @@
+// Package ninep implements a read only 9P2000 file server for a
+// vfs.FileSystem and a 9P2000 client which is itself a vfs.FileSystem, so
+// that a NameSpace exported by one process can be bound into a NameSpace of
+// another.
+//
+// Only the messages needed for reading are supported: version, attach, walk,
+// open, read, stat, clunk and flush. Authentication is not supported and
+// all modifying requests are answered with an error.
+package ninep // import "github.com/thomasf/vfs/ninep"
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the only protocol version spoken by this package.
+const Version = "9P2000"
+
+const (
+	msgTversion = 100 + iota
+	msgRversion
+	msgTauth
+	msgRauth
+	msgTattach
+	msgRattach
+	msgTerror // illegal
+	msgRerror
+	msgTflush
+	msgRflush
+	msgTwalk
+	msgRwalk
+	msgTopen
+	msgRopen
+	msgTcreate
+	msgRcreate
+	msgTread
+	msgRread
+	msgTwrite
+	msgRwrite
+	msgTclunk
+	msgRclunk
+	msgTremove
+	msgRremove
+	msgTstat
+	msgRstat
+	msgTwstat
+	msgRwstat
+)
+
+const (
+	noTag uint16 = 0xffff
+	noFid uint32 = 0xffffffff
+
+	// maxWalkElem is the maximum number of names in a single walk.
+	maxWalkElem = 16
+	// ioHeaderSize is the size of a Rread header, a read may return at
+	// most msize-ioHeaderSize bytes.
+	ioHeaderSize = 24
+	// defaultMsize is the message size proposed by clients and the
+	// maximum accepted by servers.
+	defaultMsize = 64 * 1024
+	minMsize     = 256
+)
+
+// Open modes.
+const (
+	oREAD   = 0
+	oWRITE  = 1
+	oRDWR   = 2
+	oEXEC   = 3
+	oTRUNC  = 0x10
+	oRCLOSE = 0x40
+)
+
+// Qid types and directory mode bits.
+const (
+	qtDIR     = 0x80
+	qtSYMLINK = 0x02
+
+	dmDIR     = 0x80000000
+	dmSYMLINK = 0x02000000
+)
+
+// qid is the server's unique identification of a file.
+type qid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+// dir is the machine independent directory entry returned by stat.
+type dir struct {
+	Type   uint16
+	Dev    uint32
+	Qid    qid
+	Mode   uint32
+	Atime  uint32
+	Mtime  uint32
+	Length uint64
+	Name   string
+	UID    string
+	GID    string
+	MUID   string
+}
+
+// fcall is a single 9P message, which fields are used depends on Type.
+type fcall struct {
+	Type    uint8
+	Tag     uint16
+	Fid     uint32
+	Msize   uint32   // Tversion, Rversion
+	Version string   // Tversion, Rversion
+	Afid    uint32   // Tattach
+	Uname   string   // Tattach
+	Aname   string   // Tattach
+	Ename   string   // Rerror
+	Oldtag  uint16   // Tflush
+	Newfid  uint32   // Twalk
+	Wname   []string // Twalk
+	Wqid    []qid    // Rwalk
+	Qid     qid      // Rattach, Ropen
+	Iounit  uint32   // Ropen
+	Mode    uint8    // Topen
+	Offset  uint64   // Tread
+	Count   uint32   // Tread
+	Data    []byte   // Rread
+	Stat    []byte   // Rstat
+}
+
+var errShortMessage = errors.New("9p: short message")
+
+// buffer is used to marshal messages.
+type buffer []byte
+
+func (b *buffer) u8(v uint8)   { *b = append(*b, v) }
+func (b *buffer) u16(v uint16) { *b = append(*b, byte(v), byte(v>>8)) }
+func (b *buffer) u32(v uint32) {
+	*b = append(*b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+func (b *buffer) u64(v uint64) {
+	b.u32(uint32(v))
+	b.u32(uint32(v >> 32))
+}
+func (b *buffer) str(s string) {
+	b.u16(uint16(len(s)))
+	*b = append(*b, s...)
+}
+func (b *buffer) qid(q qid) {
+	b.u8(q.Type)
+	b.u32(q.Version)
+	b.u64(q.Path)
+}
+
+// reader is used to unmarshal messages, it records the first error.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errShortMessage
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() uint8 {
+	if v := r.next(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if v := r.next(2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if v := r.next(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if v := r.next(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (r *reader) str() string {
+	n := r.u16()
+	return string(r.next(int(n)))
+}
+
+func (r *reader) qid() qid {
+	return qid{Type: r.u8(), Version: r.u32(), Path: r.u64()}
+}
+
+// marshal returns the wire representation of f including the size prefix.
+func (f *fcall) marshal() []byte {
+	b := buffer(make([]byte, 4, 64))
+	b.u8(f.Type)
+	b.u16(f.Tag)
+	switch f.Type {
+	case msgTversion, msgRversion:
+		b.u32(f.Msize)
+		b.str(f.Version)
+	case msgTattach:
+		b.u32(f.Fid)
+		b.u32(f.Afid)
+		b.str(f.Uname)
+		b.str(f.Aname)
+	case msgRattach, msgRauth:
+		b.qid(f.Qid)
+	case msgRerror:
+		b.str(f.Ename)
+	case msgTflush:
+		b.u16(f.Oldtag)
+	case msgTwalk:
+		b.u32(f.Fid)
+		b.u32(f.Newfid)
+		b.u16(uint16(len(f.Wname)))
+		for _, n := range f.Wname {
+			b.str(n)
+		}
+	case msgRwalk:
+		b.u16(uint16(len(f.Wqid)))
+		for _, q := range f.Wqid {
+			b.qid(q)
+		}
+	case msgTopen:
+		b.u32(f.Fid)
+		b.u8(f.Mode)
+	case msgRopen:
+		b.qid(f.Qid)
+		b.u32(f.Iounit)
+	case msgTread:
+		b.u32(f.Fid)
+		b.u64(f.Offset)
+		b.u32(f.Count)
+	case msgRread:
+		b.u32(uint32(len(f.Data)))
+		b = append(b, f.Data...)
+	case msgTclunk, msgTstat, msgTremove:
+		b.u32(f.Fid)
+	case msgRstat:
+		b.u16(uint16(len(f.Stat)))
+		b = append(b, f.Stat...)
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// unmarshal parses the message body p, which starts with the type byte.
+func (f *fcall) unmarshal(p []byte) error {
+	r := &reader{b: p}
+	f.Type = r.u8()
+	f.Tag = r.u16()
+	switch f.Type {
+	case msgTversion, msgRversion:
+		f.Msize = r.u32()
+		f.Version = r.str()
+	case msgTauth:
+		f.Afid = r.u32()
+		f.Uname = r.str()
+		f.Aname = r.str()
+	case msgTattach:
+		f.Fid = r.u32()
+		f.Afid = r.u32()
+		f.Uname = r.str()
+		f.Aname = r.str()
+	case msgRattach, msgRauth:
+		f.Qid = r.qid()
+	case msgRerror:
+		f.Ename = r.str()
+	case msgTflush:
+		f.Oldtag = r.u16()
+	case msgTwalk:
+		f.Fid = r.u32()
+		f.Newfid = r.u32()
+		n := int(r.u16())
+		if n > maxWalkElem {
+			return errors.New("9p: too many names in walk")
+		}
+		for i := 0; i < n && r.err == nil; i++ {
+			f.Wname = append(f.Wname, r.str())
+		}
+	case msgRwalk:
+		n := int(r.u16())
+		if n > maxWalkElem {
+			return errors.New("9p: too many qids in walk")
+		}
+		for i := 0; i < n && r.err == nil; i++ {
+			f.Wqid = append(f.Wqid, r.qid())
+		}
+	case msgTopen:
+		f.Fid = r.u32()
+		f.Mode = r.u8()
+	case msgRopen:
+		f.Qid = r.qid()
+		f.Iounit = r.u32()
+	case msgTread:
+		f.Fid = r.u32()
+		f.Offset = r.u64()
+		f.Count = r.u32()
+	case msgRread:
+		n := r.u32()
+		f.Data = r.next(int(n))
+	case msgTclunk, msgTstat, msgTremove, msgTcreate, msgTwrite, msgTwstat:
+		// only the fid is needed to answer modifying requests.
+		f.Fid = r.u32()
+		return r.err
+	case msgRstat:
+		n := r.u16()
+		f.Stat = r.next(int(n))
+	case msgRflush, msgRclunk, msgRremove:
+	default:
+		return errors.Errorf("9p: unknown message type %d", f.Type)
+	}
+	return r.err
+}
+
+// readMsg reads a single message from r. Messages larger than msize are
+// rejected.
+func readMsg(r io.Reader, msize uint32) (*fcall, error) {
+	var sz [4]byte
+	if _, err := io.ReadFull(r, sz[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(sz[:])
+	if n < 7 || n > msize {
+		return nil, errors.Errorf("9p: bad message size %d", n)
+	}
+	p := make([]byte, n-4)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	f := &fcall{}
+	if err := f.unmarshal(p); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// marshal returns the wire representation of d including its size prefix.
+func (d *dir) marshal() []byte {
+	b := buffer(make([]byte, 2, 64))
+	b.u16(d.Type)
+	b.u32(d.Dev)
+	b.qid(d.Qid)
+	b.u32(d.Mode)
+	b.u32(d.Atime)
+	b.u32(d.Mtime)
+	b.u64(d.Length)
+	b.str(d.Name)
+	b.str(d.UID)
+	b.str(d.GID)
+	b.str(d.MUID)
+	binary.LittleEndian.PutUint16(b, uint16(len(b)-2))
+	return b
+}
+
+// unmarshalDirs parses a sequence of directory entries.
+func unmarshalDirs(p []byte) ([]dir, error) {
+	var ds []dir
+	for len(p) > 0 {
+		if len(p) < 2 {
+			return nil, errShortMessage
+		}
+		n := int(binary.LittleEndian.Uint16(p)) + 2
+		if len(p) < n {
+			return nil, errShortMessage
+		}
+		r := &reader{b: p[2:n]}
+		d := dir{
+			Type:   r.u16(),
+			Dev:    r.u32(),
+			Qid:    r.qid(),
+			Mode:   r.u32(),
+			Atime:  r.u32(),
+			Mtime:  r.u32(),
+			Length: r.u64(),
+			Name:   r.str(),
+			UID:    r.str(),
+			GID:    r.str(),
+			MUID:   r.str(),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		ds = append(ds, d)
+		p = p[n:]
+	}
+	return ds, nil
+}
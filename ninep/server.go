@@ -0,0 +1,390 @@
+package ninep
+
+import (
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	pathpkg "path"
+	"strings"
+
+	"github.com/thomasf/vfs"
+)
+
+// ListenAndServe listens on the network address and serves fs to every
+// connection, network is typically "tcp" or "unix".
+func ListenAndServe(network, addr string, fs vfs.FileSystem) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return Serve(l, fs)
+}
+
+// Serve accepts connections on l and serves fs on each of them in a new
+// goroutine.
+func Serve(l net.Listener, fs vfs.FileSystem) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(c, fs)
+	}
+}
+
+// ServeConn serves fs on a single connection until it is closed by the
+// client. The connection is closed when ServeConn returns. Requests are
+// handled in the order they arrive.
+//
+// Symbolic links are followed by the server, stat and directory reads
+// report the files they point to.
+func ServeConn(rwc io.ReadWriteCloser, fs vfs.FileSystem) error {
+	defer rwc.Close()
+	c := &conn{
+		fs:    fs,
+		rwc:   rwc,
+		msize: defaultMsize,
+		fids:  make(map[uint32]*fid),
+	}
+	defer c.clunkAll()
+	for {
+		req, err := readMsg(rwc, c.msize)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		resp := c.handle(req)
+		resp.Tag = req.Tag
+		if _, err := rwc.Write(resp.marshal()); err != nil {
+			return err
+		}
+	}
+}
+
+// conn is the server side state of a connection.
+type conn struct {
+	fs    vfs.FileSystem
+	rwc   io.ReadWriteCloser
+	msize uint32
+	fids  map[uint32]*fid
+}
+
+// fid is a file on the server referenced by the client.
+type fid struct {
+	path string
+	qid  qid
+	open bool
+	file vfs.ReadSeekCloser
+	// dir holds the marshaled entries of an open directory, dirIndex and
+	// dirOffset are the next entry and its offset in the read stream.
+	dir       [][]byte
+	dirIndex  int
+	dirOffset uint64
+}
+
+func (f *fid) clunk() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func (c *conn) clunkAll() {
+	for n, f := range c.fids {
+		f.clunk()
+		delete(c.fids, n)
+	}
+}
+
+// 9P errors are plain strings, the ones the client maps back to os errors
+// are those of os.ErrNotExist and os.ErrPermission.
+var (
+	errNotExist     = os.ErrNotExist.Error()
+	errPermission   = os.ErrPermission.Error()
+	errUnknownFid   = "unknown fid"
+	errFidInUse     = "fid already in use"
+	errNotDir       = "not a directory"
+	errIsOpen       = "fid is open"
+	errNotOpen      = "fid is not open"
+	errBadOffset    = "bad offset in directory read"
+	errBadName      = "bad name in walk"
+	errNoAuth       = "authentication not required"
+	errUnknownMsg   = "unsupported message"
+	errShortDirRead = "directory read too short"
+)
+
+func rerror(ename string) *fcall {
+	return &fcall{Type: msgRerror, Ename: ename}
+}
+
+// errorString converts a FileSystem error into a 9P error string.
+func errorString(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return errNotExist
+	case os.IsPermission(err):
+		return errPermission
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err.Error()
+	}
+	return err.Error()
+}
+
+// qidOf returns the qid for the file at p, the qid path is derived from the
+// file path and the version from its modification time.
+func qidOf(p string, fi os.FileInfo) qid {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	q := qid{Path: h.Sum64(), Version: unixTime(fi)}
+	switch {
+	case fi.IsDir():
+		q.Type = qtDIR
+	case fi.Mode()&os.ModeSymlink != 0:
+		q.Type = qtSYMLINK
+	}
+	return q
+}
+
+func unixTime(fi os.FileInfo) uint32 {
+	if fi.ModTime().IsZero() {
+		return 0
+	}
+	return uint32(fi.ModTime().Unix())
+}
+
+// dirOf converts fi into a 9P directory entry.
+func dirOf(p string, fi os.FileInfo) *dir {
+	d := &dir{
+		Qid:    qidOf(p, fi),
+		Mode:   uint32(fi.Mode().Perm()),
+		Mtime:  unixTime(fi),
+		Length: uint64(fi.Size()),
+		Name:   fi.Name(),
+	}
+	d.Atime = d.Mtime
+	if p == "/" {
+		d.Name = "/"
+	}
+	switch {
+	case fi.IsDir():
+		d.Mode |= dmDIR
+		d.Length = 0
+	case fi.Mode()&os.ModeSymlink != 0:
+		d.Mode |= dmSYMLINK
+	}
+	return d
+}
+
+func (c *conn) handle(req *fcall) *fcall {
+	switch req.Type {
+	case msgTversion:
+		return c.version(req)
+	case msgTauth:
+		return rerror(errNoAuth)
+	case msgTattach:
+		return c.attach(req)
+	case msgTflush:
+		// requests are handled in order so there is never anything to
+		// flush.
+		return &fcall{Type: msgRflush}
+	case msgTwalk:
+		return c.walk(req)
+	case msgTopen:
+		return c.open(req)
+	case msgTread:
+		return c.read(req)
+	case msgTclunk:
+		f, ok := c.fids[req.Fid]
+		if !ok {
+			return rerror(errUnknownFid)
+		}
+		f.clunk()
+		delete(c.fids, req.Fid)
+		return &fcall{Type: msgRclunk}
+	case msgTremove:
+		// remove always clunks the fid, even when it fails.
+		if f, ok := c.fids[req.Fid]; ok {
+			f.clunk()
+			delete(c.fids, req.Fid)
+		}
+		return rerror(errPermission)
+	case msgTstat:
+		return c.stat(req)
+	case msgTcreate, msgTwrite, msgTwstat:
+		return rerror(errPermission)
+	}
+	return rerror(errUnknownMsg)
+}
+
+func (c *conn) version(req *fcall) *fcall {
+	c.clunkAll()
+	msize := req.Msize
+	if msize > defaultMsize {
+		msize = defaultMsize
+	}
+	if msize < minMsize {
+		return rerror("msize too small")
+	}
+	c.msize = msize
+	version := Version
+	if !strings.HasPrefix(req.Version, Version) {
+		version = "unknown"
+	}
+	return &fcall{Type: msgRversion, Msize: msize, Version: version}
+}
+
+func (c *conn) attach(req *fcall) *fcall {
+	if req.Afid != noFid {
+		return rerror(errNoAuth)
+	}
+	if _, ok := c.fids[req.Fid]; ok {
+		return rerror(errFidInUse)
+	}
+	fi, err := c.fs.Stat("/")
+	if err != nil {
+		return rerror(errorString(err))
+	}
+	f := &fid{path: "/", qid: qidOf("/", fi)}
+	c.fids[req.Fid] = f
+	return &fcall{Type: msgRattach, Qid: f.qid}
+}
+
+func (c *conn) walk(req *fcall) *fcall {
+	f, ok := c.fids[req.Fid]
+	if !ok {
+		return rerror(errUnknownFid)
+	}
+	if f.open {
+		return rerror(errIsOpen)
+	}
+	if _, ok := c.fids[req.Newfid]; ok && req.Newfid != req.Fid {
+		return rerror(errFidInUse)
+	}
+	p, q := f.path, f.qid
+	var qids []qid
+	for i, name := range req.Wname {
+		var ename string
+		switch {
+		case q.Type&qtDIR == 0:
+			ename = errNotDir
+		case name == "" || name == "." || strings.Contains(name, "/"):
+			ename = errBadName
+		default:
+			next := pathpkg.Join(p, name)
+			fi, err := c.fs.Stat(next)
+			if err != nil {
+				ename = errorString(err)
+				break
+			}
+			p, q = next, qidOf(next, fi)
+			qids = append(qids, q)
+		}
+		if ename != "" {
+			if i == 0 {
+				return rerror(ename)
+			}
+			break
+		}
+	}
+	if len(qids) == len(req.Wname) {
+		c.fids[req.Newfid] = &fid{path: p, qid: q}
+	}
+	return &fcall{Type: msgRwalk, Wqid: qids}
+}
+
+func (c *conn) open(req *fcall) *fcall {
+	f, ok := c.fids[req.Fid]
+	if !ok {
+		return rerror(errUnknownFid)
+	}
+	if f.open {
+		return rerror(errIsOpen)
+	}
+	if mode := req.Mode & 3; (mode != oREAD && mode != oEXEC) || req.Mode&(oTRUNC|oRCLOSE) != 0 {
+		return rerror(errPermission)
+	}
+	if f.qid.Type&qtDIR != 0 {
+		fis, err := c.fs.ReadDir(f.path)
+		if err != nil {
+			return rerror(errorString(err))
+		}
+		for _, fi := range fis {
+			p := pathpkg.Join(f.path, fi.Name())
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if sfi, err := c.fs.Stat(p); err == nil {
+					fi = sfi
+				}
+			}
+			f.dir = append(f.dir, dirOf(p, fi).marshal())
+		}
+	} else {
+		file, err := c.fs.Open(f.path)
+		if err != nil {
+			return rerror(errorString(err))
+		}
+		f.file = file
+	}
+	f.open = true
+	return &fcall{Type: msgRopen, Qid: f.qid, Iounit: c.msize - ioHeaderSize}
+}
+
+func (c *conn) read(req *fcall) *fcall {
+	f, ok := c.fids[req.Fid]
+	if !ok {
+		return rerror(errUnknownFid)
+	}
+	if !f.open {
+		return rerror(errNotOpen)
+	}
+	count := req.Count
+	if max := c.msize - ioHeaderSize; count > max {
+		count = max
+	}
+
+	if f.file == nil {
+		// directory reads must continue where the previous read ended or
+		// start over at offset zero, entries are never split.
+		if req.Offset == 0 {
+			f.dirIndex, f.dirOffset = 0, 0
+		} else if req.Offset != f.dirOffset {
+			return rerror(errBadOffset)
+		}
+		var data []byte
+		for f.dirIndex < len(f.dir) && len(data)+len(f.dir[f.dirIndex]) <= int(count) {
+			data = append(data, f.dir[f.dirIndex]...)
+			f.dirIndex++
+		}
+		if len(data) == 0 && f.dirIndex < len(f.dir) {
+			return rerror(errShortDirRead)
+		}
+		f.dirOffset += uint64(len(data))
+		return &fcall{Type: msgRread, Data: data}
+	}
+
+	if _, err := f.file.Seek(int64(req.Offset), io.SeekStart); err != nil {
+		return rerror(errorString(err))
+	}
+	data := make([]byte, count)
+	n, err := io.ReadFull(f.file, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return rerror(errorString(err))
+	}
+	return &fcall{Type: msgRread, Data: data[:n]}
+}
+
+func (c *conn) stat(req *fcall) *fcall {
+	f, ok := c.fids[req.Fid]
+	if !ok {
+		return rerror(errUnknownFid)
+	}
+	fi, err := c.fs.Stat(f.path)
+	if err != nil {
+		return rerror(errorString(err))
+	}
+	return &fcall{Type: msgRstat, Stat: dirOf(f.path, fi).marshal()}
+}
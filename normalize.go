@@ -0,0 +1,151 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NormalizeOptions configures Normalize.
+type NormalizeOptions struct {
+	// ModTime is reported for every file and directory. It defaults to
+	// SOURCE_DATE_EPOCH if it is set and to the Unix epoch otherwise.
+	ModTime time.Time
+	// Clamp only replaces modification times later than ModTime, as
+	// recommended for SOURCE_DATE_EPOCH.
+	Clamp bool
+	// FileMode, ExecMode and DirMode are the permission bits of regular
+	// files, regular files with any execute bit set and directories. They
+	// default to 0644, 0755 and 0755.
+	FileMode, ExecMode, DirMode os.FileMode
+}
+
+// SourceDateEpoch returns the time in the SOURCE_DATE_EPOCH environment
+// variable, ok is false if it is not set.
+func SourceDateEpoch() (t time.Time, ok bool, err error) {
+	s := strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec < 0 {
+		return time.Time{}, false, errors.Errorf("invalid SOURCE_DATE_EPOCH %q", s)
+	}
+	return time.Unix(sec, 0).UTC(), true, nil
+}
+
+// Normalize wraps a FileSystem and reports canonical metadata for
+// reproducible builds: a fixed modification time, canonical permission bits
+// and no Sys value. Directory listings are sorted by name. An invalid
+// SOURCE_DATE_EPOCH is ignored, use SafeNormalize to report it.
+func Normalize(fs FileSystem, opts *NormalizeOptions) FileSystem {
+	var o NormalizeOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.ModTime.IsZero() {
+		o.ModTime = time.Unix(0, 0).UTC()
+		if t, ok, err := SourceDateEpoch(); ok && err == nil {
+			o.ModTime = t
+		}
+	}
+	if o.FileMode == 0 {
+		o.FileMode = 0644
+	}
+	if o.ExecMode == 0 {
+		o.ExecMode = 0755
+	}
+	if o.DirMode == 0 {
+		o.DirMode = 0755
+	}
+	return normalizeFS{fs, o}
+}
+
+// SafeNormalize is like Normalize but returns an error if ModTime is not
+// set and SOURCE_DATE_EPOCH is invalid.
+func SafeNormalize(fs FileSystem, opts *NormalizeOptions) FileSystemFunc {
+	return func() (FileSystem, error) {
+		if opts == nil || opts.ModTime.IsZero() {
+			if _, _, err := SourceDateEpoch(); err != nil {
+				return nil, err
+			}
+		}
+		return Normalize(fs, opts), nil
+	}
+}
+
+type normalizeFS struct {
+	FileSystem
+	opts NormalizeOptions
+}
+
+func (fs normalizeFS) String() string {
+	return fmt.Sprintf("normalize(%v)", fs.FileSystem.String())
+}
+
+func (fs normalizeFS) fileInfo(fi os.FileInfo) os.FileInfo {
+	mode := fi.Mode()
+	perm := fs.opts.FileMode
+	switch {
+	case mode.IsDir():
+		perm = fs.opts.DirMode
+	case mode&os.ModeSymlink != 0:
+		perm = 0777
+	case mode.IsRegular() && mode&0111 != 0:
+		perm = fs.opts.ExecMode
+	}
+	modTime := fs.opts.ModTime
+	if fs.opts.Clamp && fi.ModTime().Before(modTime) {
+		modTime = fi.ModTime().UTC()
+	}
+	return keepOSPath(fi, normalizedFI{
+		FileInfo: fi,
+		mode:     mode&^os.ModePerm | perm.Perm(),
+		modTime:  modTime,
+	})
+}
+
+func (fs normalizeFS) Lstat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(fi), nil
+}
+
+func (fs normalizeFS) Stat(p string) (os.FileInfo, error) {
+	fi, err := fs.FileSystem.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(fi), nil
+}
+
+func (fs normalizeFS) ReadDir(p string) ([]os.FileInfo, error) {
+	fis, err := fs.FileSystem.ReadDir(p)
+	if err != nil {
+		return fis, err
+	}
+	list := make([]os.FileInfo, len(fis))
+	for i, fi := range fis {
+		list[i] = fs.fileInfo(fi)
+	}
+	sort.Sort(byName(list))
+	return list, nil
+}
+
+// normalizedFI wraps a os.FileInfo with canonical metadata.
+type normalizedFI struct {
+	os.FileInfo
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi normalizedFI) Mode() os.FileMode  { return fi.mode }
+func (fi normalizedFI) ModTime() time.Time { return fi.modTime }
+func (fi normalizedFI) Sys() interface{}   { return nil }
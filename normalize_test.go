@@ -0,0 +1,123 @@
+package vfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "normalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "animals", "cats"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{"cats": 0600, "C-cats": 0750} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "animals", "cats", name), []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "animals", "cats", "cats"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	epoch := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	fs := Normalize(OS(dir), &NormalizeOptions{ModTime: epoch})
+	for name, mode := range map[string]os.FileMode{
+		"/":                    os.ModeDir | 0755,
+		"/animals":             os.ModeDir | 0755,
+		"/animals/cats/cats":   0644,
+		"/animals/cats/C-cats": 0755,
+	} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != mode || !fi.ModTime().Equal(epoch) || fi.Sys() != nil {
+			t.Fatalf("%s: unexpected metadata %v %v %v", name, fi.Mode(), fi.ModTime(), fi.Sys())
+		}
+	}
+	assertOSPather(t, fs, map[string]string{
+		"/animals/cats/cats": filepath.Join(dir, "animals", "cats", "cats"),
+	})
+
+	// clamping keeps earlier times.
+	fs = Normalize(OS(dir), &NormalizeOptions{ModTime: epoch.AddDate(100, 0, 0), Clamp: true})
+	fis, err := fs.ReadDir("/animals/cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 || fis[0].Name() != "C-cats" || !fis[1].ModTime().Equal(later) {
+		t.Fatalf("unexpected listing %v", fis)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1580702706")
+	fi, err := Normalize(Map(map[string]string{"a": "a"}), nil).Stat("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1580702706, 0); !fi.ModTime().Equal(want) || fi.Mode() != 0644 {
+		t.Fatalf("unexpected metadata %v %v", fi.Mode(), fi.ModTime())
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	assertNotSafe(t, SafeNormalize(Map(nil), nil))
+	assertIsSafe(t, SafeNormalize(Map(nil), &NormalizeOptions{ModTime: time.Now()}))
+	fi, err = Normalize(Map(map[string]string{"a": "a"}), nil).Stat("/a")
+	if err != nil || !fi.ModTime().Equal(time.Unix(0, 0)) {
+		t.Fatalf("unexpected modification time %v: %v", fi.ModTime(), err)
+	}
+
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+	if _, ok, err := SourceDateEpoch(); ok || err != nil {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+}
+
+func TestNameSpaceOptions(t *testing.T) {
+	epoch := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	ns := NewNameSpaceWithOptions(NameSpaceOptions{DirModTime: epoch, DirMode: 0755})
+	ns.Bind("/a/b/c", Map(map[string]string{"f": "f"}), "/", BindReplace)
+	if len(ns.NameSpace) != 2 {
+		t.Fatalf("expected only the mount points in the mount table, got %v", ns.NameSpace)
+	}
+
+	for _, name := range []string{"/a", "/a/b"} {
+		fi, err := ns.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(epoch) || fi.Mode() != os.ModeDir|0755 || !fi.IsDir() {
+			t.Fatalf("%s: unexpected metadata %v %v", name, fi.Mode(), fi.ModTime())
+		}
+	}
+	fis, err := ns.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || !fis[0].ModTime().Equal(epoch) {
+		t.Fatalf("unexpected listing %v", fis)
+	}
+	var buf bytes.Buffer
+	ns.Fprint(&buf)
+	if want := "name space {\n\t/:\n\t\temptyVFS(/) /\n\t/a/b/c:\n\t\tfilemap(1) /\n}\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+	assertWalk(t, ns, `dir : /
+dir : /a
+dir : /a/b
+dir : /a/b/c
+file: /a/b/c/f
+data: f`)
+}
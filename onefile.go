@@ -3,87 +3,98 @@ package vfs
 import (
 	"os"
 	pathpkg "path"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// OneFile contains a link to a single OS file at the root of the VFS. The
-// first argument is the full path to the local file, the second argument must
-// be a single file name without any directories or leading slash.
+// OneFile contains a link to a single OS file in the VFS. The first argument
+// is the full path to the local file, the second argument is the path of the
+// file in the VFS, either a single file name or a slash separated path such
+// as "etc/app/config.yaml" whose parent directories are synthesized.
 func OneFile(path, newname string) FileSystem {
 	return oneFileFileSystem{
 		path: path,
-		name: newname,
+		name: pathpkg.Clean("/" + newname),
 	}
 }
 
 func SafeOneFile(path, newname string) FileSystemFunc {
 	return func() (FileSystem, error) {
-		// todo , what is rules for newname?
-		// if strings.HasPrefix(new, "/") {
-		// 	return nil, errors.Errorf("mount paths may not contain a leading '/': %s", new)
-		// }
+		name := strings.TrimSpace(newname)
+		if name == "" || pathpkg.Clean("/"+name) == "/" {
+			return nil, errors.Errorf("%q is not a valid file name for %s", newname, path)
+		}
+		for _, elem := range strings.Split(strings.Trim(name, "/"), "/") {
+			if elem == "." || elem == ".." {
+				return nil, errors.Errorf("%q may not contain %q elements", newname, elem)
+			}
+		}
 		fi, err := os.Stat(path)
 		if err != nil {
 			return nil, errors.Wrapf(err, "%s is not a readable path", path)
 		}
 		if fi.IsDir() {
-			return nil, errors.Wrapf(err, "%s is a directory, not a file", path)
+			return nil, errors.Errorf("%s is a directory, not a file", path)
 		}
-		return oneFileFileSystem{
-			path: path,
-			name: newname,
-		}, nil
-
+		return OneFile(path, name), nil
 	}
 }
 
 type oneFileFileSystem struct {
 	path string
-	name string
+	name string // cleaned, rooted path of the file
 }
 
 func (fs oneFileFileSystem) String() string {
-	return "onefile(" + fs.path + ":" + fs.name + ")"
+	return "onefile(" + fs.path + ":" + strings.TrimPrefix(fs.name, "/") + ")"
 }
 
 func (fs oneFileFileSystem) Open(path string) (ReadSeekCloser, error) {
-	if path != pathpkg.Clean("/"+fs.name) {
+	if pathpkg.Clean("/"+path) != fs.name {
 		return nil, os.ErrNotExist
 	}
 	return os.Open(fs.path)
 }
 
 func (fs oneFileFileSystem) Lstat(path string) (os.FileInfo, error) {
-	if path == "/" {
-		return dirInfo("/"), nil
-	}
-	if path != pathpkg.Clean("/"+fs.name) {
-		return nil, os.ErrNotExist
-	}
-	fi, err := os.Lstat(fs.path)
-	return osPathFI{fi, fs.path}, err
+	return fs.stat(path, os.Lstat)
 }
 
 func (fs oneFileFileSystem) Stat(path string) (os.FileInfo, error) {
-	if path == "/" {
-		return dirInfo("/"), nil
-	}
-	if path != pathpkg.Clean("/"+fs.name) {
-		return nil, os.ErrNotExist
-	}
-	fi, err := os.Stat(fs.path)
-	return osPathFI{fi, fs.path}, err
+	return fs.stat(path, os.Stat)
 }
 
-func (fs oneFileFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
-	if path == "/" {
-		fi, err := os.Stat(fs.path)
+// stat implements the FileSystem Stat and Lstat methods, the parent
+// directories of the file are synthesized.
+func (fs oneFileFileSystem) stat(path string, f func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	if path == fs.name {
+		fi, err := f(fs.path)
 		if err != nil {
 			return nil, err
 		}
-		rfi := renamedFileInfo(fi, fs.name)
-		return []os.FileInfo{rfi}, nil
+		return osPathFI{renamedFI{fi, pathpkg.Base(fs.name)}, fs.path}, nil
+	}
+	if hasPathPrefix(fs.name, path) {
+		return dirInfo(pathpkg.Base(path)), nil
 	}
 	return nil, os.ErrNotExist
 }
+
+func (fs oneFileFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	if path == fs.name || !hasPathPrefix(fs.name, path) {
+		return nil, os.ErrNotExist
+	}
+	// the next element of the file path after path.
+	elem := strings.TrimPrefix(fs.name[len(path):], "/")
+	if i := strings.Index(elem, "/"); i >= 0 {
+		return []os.FileInfo{dirInfo(elem[:i])}, nil
+	}
+	fi, err := os.Stat(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	return []os.FileInfo{osPathFI{renamedFI{fi, elem}, fs.path}}, nil
+}
@@ -79,6 +79,10 @@ func (root osFS) Stat(path string) (os.FileInfo, error) {
 	return osPathFI{fi, p}, err
 }
 
+func (root osFS) Readlink(path string) (string, error) {
+	return os.Readlink(root.resolve(path))
+}
+
 func (root osFS) ReadDir(path string) ([]os.FileInfo, error) {
 	p := root.resolve(path)
 	fis, err := ioutil.ReadDir(p) // is sorted
@@ -86,7 +90,7 @@ func (root osFS) ReadDir(path string) ([]os.FileInfo, error) {
 		return fis, err
 	}
 	for i, v := range fis {
-		fis[i] = osPathFI{v, filepath.Join(v.Name())}
+		fis[i] = osPathFI{v, filepath.Join(p, v.Name())}
 	}
 	return fis, err
 }
@@ -0,0 +1,265 @@
+package remotefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Client returns a vfs.FileSystem for the remotefs server at baseURL. If c
+// is nil http.DefaultClient is used.
+func Client(baseURL string, c *http.Client) vfs.FileSystem {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &client{base: strings.TrimSuffix(baseURL, "/"), c: c}
+}
+
+// SafeClient is like Client but verifies that the root of the remote
+// FileSystem is a directory.
+func SafeClient(baseURL string, c *http.Client) vfs.FileSystemFunc {
+	return func() (vfs.FileSystem, error) {
+		fs := Client(baseURL, c)
+		fi, err := fs.Stat("/")
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s is not a readable remotefs server", baseURL)
+		}
+		if !fi.IsDir() {
+			return nil, errors.Errorf("the root of %s is not a directory", baseURL)
+		}
+		return fs, nil
+	}
+}
+
+type client struct {
+	base string
+	c    *http.Client
+}
+
+func (c *client) String() string {
+	return "remotefs(" + c.base + ")"
+}
+
+func (c *client) url(endpoint, p string) string {
+	return c.base + "/" + endpoint + "?" + url.Values{"path": {pathpkg.Clean("/" + p)}}.Encode()
+}
+
+// get issues a GET request for endpoint and returns the response if it has
+// one of the accepted status codes.
+func (c *client) get(op, endpoint, p string, header http.Header, accept ...int) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.url(endpoint, p), nil)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	for _, status := range accept {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	return nil, responseError(op, p, resp)
+}
+
+// responseError converts an error response into an *os.PathError.
+func responseError(op, p string, resp *http.Response) error {
+	var e Error
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(body, &e) != nil || e.Kind == "" {
+		e.Kind = kindOther
+		e.Message = "unexpected status " + resp.Status
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			e.Kind = kindNotExist
+		case http.StatusForbidden, http.StatusUnauthorized:
+			e.Kind = kindPermission
+		}
+	}
+	var err error
+	switch e.Kind {
+	case kindNotExist:
+		err = os.ErrNotExist
+	case kindPermission:
+		err = os.ErrPermission
+	default:
+		err = errors.New(e.Message)
+	}
+	return &os.PathError{Op: op, Path: p, Err: err}
+}
+
+func (c *client) getJSON(op, endpoint, p string, v interface{}) error {
+	resp, err := c.get(op, endpoint, p, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &os.PathError{Op: op, Path: p, Err: err}
+	}
+	return nil
+}
+
+func (c *client) Stat(p string) (os.FileInfo, error) {
+	var fi FileInfo
+	if err := c.getJSON("stat", "stat", p, &fi); err != nil {
+		return nil, err
+	}
+	return fileInfo{fi}, nil
+}
+
+func (c *client) Lstat(p string) (os.FileInfo, error) {
+	var fi FileInfo
+	if err := c.getJSON("lstat", "lstat", p, &fi); err != nil {
+		return nil, err
+	}
+	return fileInfo{fi}, nil
+}
+
+func (c *client) ReadDir(p string) ([]os.FileInfo, error) {
+	var list []FileInfo
+	if err := c.getJSON("readdir", "readdir", p, &list); err != nil {
+		return nil, err
+	}
+	fis := make([]os.FileInfo, len(list))
+	for i, fi := range list {
+		fis[i] = fileInfo{fi}
+	}
+	return fis, nil
+}
+
+// Open starts streaming the file, seeking closes the stream and the next
+// read issues a Range request from the new offset.
+func (c *client) Open(p string) (vfs.ReadSeekCloser, error) {
+	resp, err := c.get("open", "open", p, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		c:            c,
+		path:         p,
+		size:         resp.ContentLength,
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         resp.Body,
+	}
+	if f.size < 0 {
+		fi, err := c.Stat(p)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		f.size = fi.Size()
+	}
+	return f, nil
+}
+
+// file is an open remote file.
+type file struct {
+	c            *client
+	path         string
+	size         int64
+	lastModified string
+	offset       int64
+	body         io.ReadCloser // nil when a new request is needed
+	closed       bool
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		h := http.Header{}
+		h.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+		if f.lastModified != "" {
+			// fail instead of mixing the contents of two versions.
+			h.Set("If-Range", f.lastModified)
+		}
+		resp, err := f.c.get("read", "open", f.path, h, http.StatusOK, http.StatusPartialContent)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, &os.PathError{Op: "read", Path: f.path, Err: errors.New("file changed on the server")}
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF {
+		f.body.Close()
+		f.body = nil
+		if f.offset < f.size {
+			err = io.ErrUnexpectedEOF
+		} else if n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("invalid whence")}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("negative position")}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// fileInfo implements os.FileInfo for a decoded FileInfo.
+type fileInfo struct {
+	fi FileInfo
+}
+
+func (fi fileInfo) Name() string       { return fi.fi.Name }
+func (fi fileInfo) Size() int64        { return fi.fi.Size }
+func (fi fileInfo) Mode() os.FileMode  { return fi.fi.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.fi.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.fi.Mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,152 @@
+// Package remotefs serves a vfs.FileSystem to other processes over HTTP and
+// implements a client which is itself a vfs.FileSystem.
+//
+// The protocol consists of four GET endpoints below a base URL, each taking
+// the file path in the path query parameter:
+//
+//	stat     the JSON FileInfo of the file, symbolic links are followed
+//	lstat    the JSON FileInfo of the file
+//	readdir  a JSON array of FileInfo for the entries of a directory
+//	open     the contents of a regular file, Range requests are supported
+//
+// Errors are reported with a 4xx or 5xx status and a JSON Error body.
+package remotefs // import "github.com/thomasf/vfs/remotefs"
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/thomasf/vfs"
+)
+
+// FileInfo is the JSON representation of an os.FileInfo.
+type FileInfo struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// Error is the JSON body of an error response.
+type Error struct {
+	// Kind is "not_exist", "permission" or "other".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+const (
+	kindNotExist   = "not_exist"
+	kindPermission = "permission"
+	kindOther      = "other"
+)
+
+func newFileInfo(fi os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+}
+
+// New returns a handler which serves fs using the remotefs protocol. Mount
+// it with http.StripPrefix if the base URL has a path.
+func New(fs vfs.FileSystem) http.Handler {
+	return &handler{fs: fs}
+}
+
+type handler struct {
+	fs vfs.FileSystem
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, kindOther, "method not allowed")
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, kindOther, "missing path parameter")
+		return
+	}
+	p = pathpkg.Clean("/" + p)
+
+	switch strings.Trim(r.URL.Path, "/") {
+	case "stat":
+		fi, err := h.fs.Stat(p)
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+		writeJSON(w, newFileInfo(fi))
+	case "lstat":
+		fi, err := h.fs.Lstat(p)
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+		writeJSON(w, newFileInfo(fi))
+	case "readdir":
+		fis, err := h.fs.ReadDir(p)
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+		list := make([]FileInfo, 0, len(fis))
+		for _, fi := range fis {
+			list = append(list, newFileInfo(fi))
+		}
+		writeJSON(w, list)
+	case "open":
+		h.open(w, r, p)
+	default:
+		writeError(w, http.StatusNotFound, kindOther, "unknown endpoint")
+	}
+}
+
+func (h *handler) open(w http.ResponseWriter, r *http.Request, p string) {
+	fi, err := h.fs.Stat(p)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		writeError(w, http.StatusBadRequest, kindOther, "not a regular file")
+		return
+	}
+	f, err := h.fs.Open(p)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, kind, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Kind: kind, Message: msg})
+}
+
+func writeFSError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		writeError(w, http.StatusNotFound, kindNotExist, err.Error())
+	case os.IsPermission(err):
+		writeError(w, http.StatusForbidden, kindPermission, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, kindOther, err.Error())
+	}
+}
@@ -0,0 +1,197 @@
+package remotefs
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/vfstest"
+)
+
+func TestClientServer(t *testing.T) {
+	ns := vfs.NewNameSpace()
+	ns.Bind("/", vfs.OS("../test-fixtures/B"), "/", vfs.BindReplace)
+	ns.Bind("/mem", vfs.Map(map[string]string{
+		"a/b.txt": "bbb",
+		"empty":   "",
+	}), "/", vfs.BindReplace)
+	srv := httptest.NewServer(http.StripPrefix("/fs", New(ns)))
+	defer srv.Close()
+
+	c := Client(srv.URL+"/fs/", nil)
+	if err := vfstest.TestFS(c, "things/wood/table/table", "mem/a/b.txt", "mem/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SafeClient(srv.URL+"/fs", nil)(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SafeClient(srv.URL+"/other", nil)(); err == nil {
+		t.Fatal("expected SafeClient to fail for a bad base URL")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 7000, time.UTC)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(vfs.OS(dir)))
+	defer srv.Close()
+	c := Client(srv.URL, nil)
+
+	fi, err := c.Stat("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0640 || fi.Size() != 7 || !fi.ModTime().Equal(mtime) || fi.Name() != "link" {
+		t.Fatalf("unexpected stat %v %v %v %v", fi.Name(), fi.Mode(), fi.Size(), fi.ModTime())
+	}
+	lfi, err := c.Lstat("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lfi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected a symlink, got %v", lfi.Mode())
+	}
+	fis, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 || fis[0].Mode() != 0640 || !fis[0].ModTime().Equal(mtime) || fis[1].Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("unexpected listing %v", fis)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(New(denyFS{vfs.Map(map[string]string{"dir/file": "x", "secret": "s"})}))
+	defer srv.Close()
+	c := Client(srv.URL, nil)
+
+	if _, err := c.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := c.ReadDir("/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := c.Open("/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := c.Open("/secret"); !os.IsPermission(err) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+	if _, err := c.Open("/dir"); err == nil || os.IsNotExist(err) {
+		t.Errorf("expected error opening a directory, got %v", err)
+	}
+	if _, err := c.ReadDir("/dir/file"); err == nil {
+		t.Error("expected error reading a file as a directory")
+	}
+}
+
+// denyFS refuses to open /secret.
+type denyFS struct {
+	vfs.FileSystem
+}
+
+func (fs denyFS) Open(p string) (vfs.ReadSeekCloser, error) {
+	if p == "/secret" {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrPermission}
+	}
+	return fs.FileSystem.Open(p)
+}
+
+// rangeRecorder records the Range headers of the requests it passes on.
+type rangeRecorder struct {
+	h      http.Handler
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (rr *rangeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/open") {
+		rr.mu.Lock()
+		rr.ranges = append(rr.ranges, r.Header.Get("Range"))
+		rr.mu.Unlock()
+	}
+	rr.h.ServeHTTP(w, r)
+}
+
+func TestSeek(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rr := &rangeRecorder{h: New(vfs.OS(dir))}
+	srv := httptest.NewServer(rr)
+	defer srv.Close()
+	c := Client(srv.URL, nil)
+
+	f, err := c.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "012" {
+		t.Fatalf("unexpected read %q: %v", buf, err)
+	}
+	// seeking to the current offset keeps the stream.
+	if _, err := f.Seek(0, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "345" {
+		t.Fatalf("unexpected read %q: %v", buf, err)
+	}
+	if _, err := f.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil || string(data) != "89" {
+		t.Fatalf("unexpected read %q: %v", data, err)
+	}
+	if _, err := f.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "123" {
+		t.Fatalf("unexpected read %q: %v", buf, err)
+	}
+	if got := strings.Join(rr.ranges, ","); got != ",bytes=8-,bytes=1-" {
+		t.Fatalf("unexpected range requests %q", got)
+	}
+
+	// a file changed between requests is not mixed with the old contents.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(buf); err == nil {
+		t.Fatal("expected reading a changed file to fail")
+	}
+}
@@ -0,0 +1,514 @@
+// Package s3fs implements a read only vfs.FileSystem for a bucket in S3 or
+// any S3 compatible object storage.
+//
+// Object keys are mapped to paths by treating "/" as the directory
+// separator. Directories do not exist as objects, they are synthesized from
+// the common prefixes of a ListObjectsV2 request. When both an object "a"
+// and objects below "a/" exist the object wins.
+package s3fs // import "github.com/thomasf/vfs/s3fs"
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Config describes a bucket.
+type Config struct {
+	// Endpoint is the base URL of the service, it defaults to
+	// https://s3.<Region>.amazonaws.com.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+	// Prefix is prepended to all keys, it makes a "directory" of the
+	// bucket the root of the FileSystem.
+	Prefix string
+	// PathStyle uses <Endpoint>/<Bucket>/<key> URLs instead of virtual
+	// hosted <Bucket>.<Endpoint host>/<key> URLs.
+	PathStyle bool
+
+	// AccessKeyID and SecretAccessKey are used to sign requests, requests
+	// are anonymous if AccessKeyID is empty.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// MaxKeys limits the number of keys per list request, zero uses the
+	// service default.
+	MaxKeys int
+	// Client is used to make requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// New returns a FileSystem for the bucket described by cfg.
+func New(cfg Config) vfs.FileSystem {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	if cfg.Prefix != "" {
+		cfg.Prefix += "/"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	fs := &s3FS{cfg: cfg}
+	if cfg.AccessKeyID != "" {
+		fs.creds = &credentials{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+			region:          cfg.Region,
+		}
+	}
+	return fs
+}
+
+// Safe is like New but verifies that the bucket can be listed.
+func Safe(cfg Config) vfs.FileSystemFunc {
+	return func() (vfs.FileSystem, error) {
+		fs := New(cfg)
+		if _, err := fs.ReadDir("/"); err != nil {
+			return nil, errors.Wrapf(err, "s3 bucket %s is not readable", cfg.Bucket)
+		}
+		return fs, nil
+	}
+}
+
+type s3FS struct {
+	cfg   Config
+	creds *credentials
+}
+
+func (fs *s3FS) String() string {
+	return fmt.Sprintf("s3(%s/%s)", fs.cfg.Bucket, strings.TrimSuffix(fs.cfg.Prefix, "/"))
+}
+
+// key returns the object key for p.
+func (fs *s3FS) key(p string) string {
+	return fs.cfg.Prefix + strings.TrimPrefix(pathpkg.Clean("/"+p), "/")
+}
+
+func (fs *s3FS) url(key string, query url.Values) *url.URL {
+	u, err := url.Parse(fs.cfg.Endpoint)
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: fs.cfg.Endpoint}
+	}
+	p := "/" + key
+	if fs.cfg.PathStyle {
+		p = "/" + fs.cfg.Bucket
+		if key != "" {
+			p += "/" + key
+		}
+	} else {
+		u.Host = fs.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// do sends a signed request with an empty body.
+func (fs *s3FS) do(method string, u *url.URL, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if fs.creds != nil {
+		fs.creds.sign(req, time.Now())
+	}
+	return fs.cfg.Client.Do(req)
+}
+
+// s3Error is the XML body of an error response.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// responseError converts an error response into an *os.PathError.
+func responseError(op, p string, resp *http.Response) error {
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = os.ErrNotExist
+	case http.StatusForbidden, http.StatusUnauthorized:
+		err = os.ErrPermission
+	default:
+		var e s3Error
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			err = errors.Errorf("%s: %s", e.Code, e.Message)
+		} else {
+			err = errors.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	return &os.PathError{Op: op, Path: p, Err: err}
+}
+
+// head returns the file info of the object for p, or nil if there is no
+// such object.
+func (fs *s3FS) head(op, p string) (*fileInfo, error) {
+	resp, err := fs.do("HEAD", fs.url(fs.key(p), nil), nil)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(op, p, resp)
+	}
+	return objectInfo(pathpkg.Base(p), resp)
+}
+
+func objectInfo(name string, resp *http.Response) (*fileInfo, error) {
+	fi := &fileInfo{name: name, etag: resp.Header.Get("Etag")}
+	// the entity tags of these objects are not MD5 digests.
+	sse := resp.Header.Get("X-Amz-Server-Side-Encryption")
+	fi.encrypted = strings.HasPrefix(sse, "aws:kms") ||
+		resp.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != ""
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("bad Content-Length %q", resp.Header.Get("Content-Length"))
+	}
+	fi.size = size
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		fi.modTime, _ = http.ParseTime(lm)
+	}
+	return fi, nil
+}
+
+type listResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list lists the keys directly below the directory prefix, calling fn for
+// every page.
+func (fs *s3FS) list(op, p, prefix string, maxKeys int, fn func(*listResult) bool) error {
+	q := url.Values{
+		"list-type": {"2"},
+		"delimiter": {"/"},
+		"prefix":    {prefix},
+	}
+	if maxKeys > 0 {
+		q.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	for {
+		resp, err := fs.do("GET", fs.url("", q), nil)
+		if err != nil {
+			return &os.PathError{Op: op, Path: p, Err: err}
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(op, p, resp)
+			resp.Body.Close()
+			if os.IsNotExist(err) {
+				// a missing bucket is not the same as a missing file.
+				err = &os.PathError{Op: op, Path: p, Err: errors.Errorf("no such bucket %s", fs.cfg.Bucket)}
+			}
+			return err
+		}
+		var res listResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return &os.PathError{Op: op, Path: p, Err: err}
+		}
+		if !fn(&res) || !res.IsTruncated {
+			return nil
+		}
+		if res.NextContinuationToken == "" {
+			return &os.PathError{Op: op, Path: p, Err: errors.New("truncated list without continuation token")}
+		}
+		q.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+// dirPrefix returns the list prefix for the directory p.
+func (fs *s3FS) dirPrefix(p string) string {
+	if k := fs.key(p); k != fs.cfg.Prefix {
+		return k + "/"
+	}
+	return fs.cfg.Prefix
+}
+
+// isDir reports whether there are any keys below the directory p.
+func (fs *s3FS) isDir(op, p string) (bool, error) {
+	found := false
+	err := fs.list(op, p, fs.dirPrefix(p), 1, func(res *listResult) bool {
+		found = len(res.Contents) > 0 || len(res.CommonPrefixes) > 0
+		return false
+	})
+	return found, err
+}
+
+func (fs *s3FS) Lstat(p string) (os.FileInfo, error) {
+	return fs.Stat(p)
+}
+
+func (fs *s3FS) Stat(p string) (os.FileInfo, error) {
+	if fs.key(p) == fs.cfg.Prefix {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	fi, err := fs.head("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	if fi != nil {
+		return fi, nil
+	}
+	dir, err := fs.isDir("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	return &fileInfo{name: pathpkg.Base(p), dir: true}, nil
+}
+
+// Hash implements the vfs.Hasher interface, the entity tag of objects which
+// are neither uploaded in multiple parts nor encrypted with KMS or customer
+// provided keys is their MD5 digest. Objects encrypted with S3 managed keys
+// keep MD5 entity tags.
+func (fs *s3FS) Hash(p, algo string) ([]byte, error) {
+	fi, err := fs.head("hash", p)
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil {
+		return nil, &os.PathError{Op: "hash", Path: p, Err: os.ErrNotExist}
+	}
+	sum, err := hex.DecodeString(strings.Trim(fi.etag, `"`))
+	if algo != vfs.HashMD5 || err != nil || len(sum) != md5.Size || fi.encrypted {
+		return nil, &os.PathError{Op: "hash", Path: p, Err: vfs.ErrHashUnsupported}
+	}
+	return sum, nil
+}
+
+func (fs *s3FS) ReadDir(p string) ([]os.FileInfo, error) {
+	prefix := fs.dirPrefix(p)
+	entries := map[string]os.FileInfo{}
+	// found is set for empty directories with only a marker object.
+	found := false
+	err := fs.list("readdir", p, prefix, fs.cfg.MaxKeys, func(res *listResult) bool {
+		found = found || len(res.Contents) > 0 || len(res.CommonPrefixes) > 0
+		for _, cp := range res.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(cp.Prefix, prefix), "/")
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			if _, ok := entries[name]; !ok {
+				entries[name] = &fileInfo{name: name, dir: true}
+			}
+		}
+		for _, c := range res.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				// directory marker objects.
+				continue
+			}
+			entries[name] = &fileInfo{
+				name:    name,
+				size:    c.Size,
+				modTime: c.LastModified.Truncate(time.Second),
+				etag:    c.ETag,
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found && prefix != fs.cfg.Prefix {
+		fi, err := fs.head("readdir", p)
+		if err != nil {
+			return nil, err
+		}
+		if fi != nil {
+			return nil, &os.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+		}
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist}
+	}
+	fis := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		fis = append(fis, fi)
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+// Open returns a reader which issues ranged GetObject requests, reads fail
+// if the object is replaced while it is open.
+func (fs *s3FS) Open(p string) (vfs.ReadSeekCloser, error) {
+	if fs.key(p) == fs.cfg.Prefix {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	fi, err := fs.head("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil {
+		dir, err := fs.isDir("open", p)
+		if err != nil {
+			return nil, err
+		}
+		if dir {
+			return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+		}
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	return &object{fs: fs, path: p, size: fi.size, etag: fi.etag}, nil
+}
+
+// object is an open object, the body of the current GetObject request is
+// read until the object is seeked.
+type object struct {
+	fs     *s3FS
+	path   string
+	size   int64
+	etag   string
+	offset int64
+	body   io.ReadCloser // nil when a new request is needed
+	closed bool
+}
+
+func (o *object) Read(p []byte) (int, error) {
+	if o.closed {
+		return 0, os.ErrClosed
+	}
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		h := http.Header{}
+		h.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+		if o.etag != "" {
+			h.Set("If-Match", o.etag)
+		}
+		resp, err := o.fs.do("GET", o.fs.url(o.fs.key(o.path), nil), h)
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: o.path, Err: err}
+		}
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			if o.offset != 0 {
+				resp.Body.Close()
+				return 0, &os.PathError{Op: "read", Path: o.path, Err: errors.New("range request not supported")}
+			}
+		case http.StatusPreconditionFailed:
+			resp.Body.Close()
+			return 0, &os.PathError{Op: "read", Path: o.path, Err: errors.New("object changed")}
+		default:
+			err := responseError("read", o.path, resp)
+			resp.Body.Close()
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF {
+		o.body.Close()
+		o.body = nil
+		if o.offset < o.size {
+			err = io.ErrUnexpectedEOF
+		} else if n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (o *object) Seek(offset int64, whence int) (int64, error) {
+	if o.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: o.path, Err: errors.New("invalid whence")}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: o.path, Err: errors.New("negative position")}
+	}
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *object) Close() error {
+	if o.closed {
+		return os.ErrClosed
+	}
+	o.closed = true
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+// fileInfo describes an object or a synthesized directory.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	etag    string
+	dir     bool
+	// encrypted is set for objects encrypted with KMS or customer keys.
+	encrypted bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,270 @@
+// Package s3test implements an in-process fake of the subset of the S3 API
+// used by s3fs, for use in tests.
+//
+// Only path style requests for a single bucket are supported: HEAD and GET
+// of objects, including Range and If-Match, and ListObjectsV2 with prefix,
+// delimiter, max-keys and continuation-token.
+package s3test // import "github.com/thomasf/vfs/s3fs/s3test"
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake S3 server for a single bucket. Its URL is the endpoint to
+// use with path style requests.
+type Server struct {
+	*httptest.Server
+	Bucket string
+
+	// MaxKeys caps the number of keys returned by a single list request,
+	// which makes it easy to test pagination. Zero means 1000.
+	MaxKeys int
+	// Authorize, if set, is called for every request and requests for
+	// which it returns false are answered with AccessDenied.
+	Authorize func(r *http.Request) bool
+
+	mu       sync.Mutex
+	objects  map[string]*object
+	requests []string
+}
+
+type object struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+	header  http.Header
+}
+
+// NewServer starts a fake server for bucket, objects maps keys to their
+// contents. The caller should call Close when finished.
+func NewServer(bucket string, objects map[string]string) *Server {
+	s := &Server{Bucket: bucket, objects: map[string]*object{}}
+	for k, v := range objects {
+		s.Put(k, []byte(v), time.Time{})
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Put stores an object, a zero modTime means now.
+func (s *Server) Put(key string, data []byte, modTime time.Time) {
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	sum := md5.Sum(data)
+	s.mu.Lock()
+	s.objects[key] = &object{
+		data:    append([]byte(nil), data...),
+		modTime: modTime.UTC().Truncate(time.Second),
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+	}
+	s.mu.Unlock()
+}
+
+// SetHeader sets a header sent with the object stored under key, such as
+// x-amz-server-side-encryption.
+func (s *Server) SetHeader(key, name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.objects[key]; ok {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Set(name, value)
+	}
+}
+
+// Delete removes an object.
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+}
+
+// Requests returns the method and request URI of every request received so
+// far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: msg})
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	s.mu.Unlock()
+
+	if s.Authorize != nil && !s.Authorize(r) {
+		writeError(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != s.Bucket {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		return
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method != "GET" || r.URL.Query().Get("list-type") != "2" {
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported")
+			return
+		}
+		s.list(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.objects[parts[1]]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	for k, v := range o.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Etag", o.etag)
+	w.Header().Set("Content-Type", "binary/octet-stream")
+	http.ServeContent(w, r, "", o.modTime, bytes.NewReader(o.data))
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []contents
+	CommonPrefixes        []commonPrefix
+}
+
+type contents struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if s.MaxKeys > 0 {
+		maxKeys = s.MaxKeys
+	}
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	// the continuation token is the last key or common prefix returned.
+	var after string
+	if token := q.Get("continuation-token"); token != "" {
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+			return
+		}
+		after = string(b)
+	}
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := listBucketResult{
+		Name:              s.Bucket,
+		Prefix:            prefix,
+		Delimiter:         delim,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+	}
+	var last string
+	for _, k := range keys {
+		if after != "" && (k <= after || strings.HasSuffix(after, delim) && delim != "" && strings.HasPrefix(k, after)) {
+			continue
+		}
+		var cp string
+		if delim != "" {
+			if i := strings.Index(k[len(prefix):], delim); i >= 0 {
+				cp = k[:len(prefix)+i+len(delim)]
+			}
+		}
+		if cp != "" && cp == last {
+			continue
+		}
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if cp != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{cp})
+			last = cp
+		} else {
+			o := s.objects[k]
+			res.Contents = append(res.Contents, contents{
+				Key:          k,
+				LastModified: o.modTime.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         o.etag,
+				Size:         int64(len(o.data)),
+				StorageClass: "STANDARD",
+			})
+			last = k
+		}
+		res.KeyCount++
+	}
+	s.mu.Unlock()
+	if res.IsTruncated {
+		res.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(res)
+}
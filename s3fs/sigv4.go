@@ -0,0 +1,150 @@
+package s3fs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigAlgorithm = "AWS4-HMAC-SHA256"
+	sigService   = "s3"
+	amzDate      = "20060102T150405Z"
+	// emptyHash is the hex encoded SHA256 of an empty payload, every
+	// request sent by this package has an empty body.
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// credentials are used to sign requests with AWS Signature Version 4.
+type credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+// sign adds the headers required for AWS Signature Version 4 to req, which
+// must have an empty body. The host, range and x-amz-* headers are signed.
+func (c *credentials) sign(req *http.Request, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDate))
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	req.Header.Set("Authorization", c.authorization(req, t))
+}
+
+// authorization returns the Authorization header value for the already
+// dated request req.
+func (c *credentials) authorization(req *http.Request, t time.Time) string {
+	t = t.UTC()
+	date := t.Format("20060102")
+	scope := date + "/" + c.region + "/" + sigService + "/aws4_request"
+	canonical, signed := canonicalRequest(req)
+
+	h := sha256.Sum256([]byte(canonical))
+	toSign := sigAlgorithm + "\n" + t.Format(amzDate) + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, sigService)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	return sigAlgorithm + " Credential=" + c.accessKeyID + "/" + scope +
+		", SignedHeaders=" + signed + ", Signature=" + sig
+}
+
+// canonicalRequest returns the canonical form of req and the list of signed
+// headers.
+func canonicalRequest(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = emptyHash
+	}
+	return strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		canonicalQuery(req),
+		ch.String(),
+		signed,
+		payload,
+	}, "\n"), signed
+}
+
+func canonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes everything except the unreserved characters of
+// RFC 3986, slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,186 @@
+package statichttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// ManifestName is the name of the manifest below the base URL.
+const ManifestName = "vfs-manifest.json"
+
+// HashSHA256 is the only supported manifest hash algorithm.
+const HashSHA256 = "sha256"
+
+// Manifest describes all files and directories of a published tree.
+type Manifest struct {
+	Version int     `json:"version"`
+	Hash    string  `json:"hash"`
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a single file or directory, Path is relative to the base URL
+// and uses forward slashes. Hash is the hex encoded hash of the contents of
+// regular files and empty for directories.
+type Entry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Hash    string      `json:"hash,omitempty"`
+}
+
+// GenerateManifest walks the tree at root in fs and returns a manifest for
+// it. Symbolic links to regular files are recorded as the files they point
+// to, all other symbolic links and special files are left out.
+func GenerateManifest(fs vfs.FileSystem, root string) (*Manifest, error) {
+	root = pathpkg.Clean("/" + root)
+	m := &Manifest{Version: 1, Hash: HashSHA256}
+	err := vfs.Walk(root, fs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			fi, err = fs.Stat(p)
+			if err != nil || !fi.Mode().IsRegular() {
+				return nil
+			}
+		}
+		e := Entry{
+			Path:    strings.TrimPrefix(strings.TrimPrefix(p, root), "/"),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+		}
+		switch {
+		case fi.IsDir():
+		case fi.Mode().IsRegular():
+			sum, size, err := hashFile(fs, p)
+			if err != nil {
+				return err
+			}
+			e.Size, e.Hash = size, sum
+		default:
+			return nil
+		}
+		m.Entries = append(m.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func hashFile(fs vfs.FileSystem, p string) (string, int64, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "hashing %s", p)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// WriteManifest writes the manifest for the tree at root in fs to w, the
+// output is meant to be published as ManifestName next to the files.
+func WriteManifest(w io.Writer, fs vfs.FileSystem, root string) error {
+	m, err := GenerateManifest(fs, root)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ParseManifest reads a manifest written by WriteManifest.
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest")
+	}
+	if m.Version != 1 {
+		return nil, errors.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if m.Hash != HashSHA256 {
+		return nil, errors.Errorf("unsupported manifest hash %q", m.Hash)
+	}
+	return &m, nil
+}
+
+// index is the in memory tree of a manifest.
+type index struct {
+	entries  map[string]*Entry   // by absolute path
+	children map[string][]string // sorted names by absolute directory path
+}
+
+func newIndex(m *Manifest) (*index, error) {
+	idx := &index{
+		entries:  map[string]*Entry{"/": {Path: "", Mode: os.ModeDir | 0555}},
+		children: map[string][]string{},
+	}
+	for i := range m.Entries {
+		e := m.Entries[i]
+		p := pathpkg.Clean("/" + e.Path)
+		if p == "/" || p != "/"+e.Path {
+			return nil, errors.Errorf("invalid manifest path %q", e.Path)
+		}
+		if e.Mode.IsRegular() {
+			if _, err := hex.DecodeString(e.Hash); err != nil || len(e.Hash) != 2*sha256.Size {
+				return nil, errors.Errorf("invalid hash for %q", e.Path)
+			}
+		} else if !e.Mode.IsDir() {
+			return nil, errors.Errorf("unsupported file type %v for %q", e.Mode, e.Path)
+		}
+		if _, ok := idx.entries[p]; ok {
+			return nil, errors.Errorf("duplicate manifest path %q", e.Path)
+		}
+		idx.entries[p] = &e
+	}
+	for p := range idx.entries {
+		if p == "/" {
+			continue
+		}
+		// synthesize directories missing from the manifest.
+		for dir := pathpkg.Dir(p); ; dir = pathpkg.Dir(dir) {
+			de, ok := idx.entries[dir]
+			if !ok {
+				idx.entries[dir] = &Entry{Path: dir[1:], Mode: os.ModeDir | 0555}
+			} else if !de.Mode.IsDir() {
+				return nil, errors.Errorf("manifest path %q is below a file", p)
+			}
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	for p := range idx.entries {
+		if p != "/" {
+			dir := pathpkg.Dir(p)
+			idx.children[dir] = append(idx.children[dir], pathpkg.Base(p))
+		}
+	}
+	for _, names := range idx.children {
+		sort.Strings(names)
+	}
+	return idx, nil
+}
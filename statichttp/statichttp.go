@@ -0,0 +1,290 @@
+// Package statichttp implements a read only vfs.FileSystem for files
+// published on a plain static web server.
+//
+// Static servers have no directory listings so a manifest, written by
+// WriteManifest, describes the tree. Stat and ReadDir are answered from the
+// manifest and files are fetched with Range requests when they are read.
+// The contents of files read in full from the start are verified against
+// the hash in the manifest.
+package statichttp // import "github.com/thomasf/vfs/statichttp"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// New fetches the manifest from baseURL and returns a FileSystem for the
+// files below baseURL. If c is nil http.DefaultClient is used.
+func New(baseURL string, c *http.Client) (vfs.FileSystem, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	resp, err := c.Get(baseURL + "/" + ManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching manifest")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetching manifest: unexpected status %s", resp.Status)
+	}
+	m, err := ParseManifest(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return FromManifest(baseURL, m, c)
+}
+
+// Safe returns a FileSystemFunc which calls New.
+func Safe(baseURL string, c *http.Client) vfs.FileSystemFunc {
+	return func() (vfs.FileSystem, error) {
+		return New(baseURL, c)
+	}
+}
+
+// FromManifest returns a FileSystem for the files described by m below
+// baseURL.
+func FromManifest(baseURL string, m *Manifest, c *http.Client) (vfs.FileSystem, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	idx, err := newIndex(m)
+	if err != nil {
+		return nil, err
+	}
+	return &staticFS{base: strings.TrimSuffix(baseURL, "/"), c: c, idx: idx}, nil
+}
+
+type staticFS struct {
+	base string
+	c    *http.Client
+	idx  *index
+}
+
+func (fs *staticFS) String() string {
+	return "statichttp(" + fs.base + ")"
+}
+
+func (fs *staticFS) lookup(op, p string) (*Entry, string, error) {
+	p = pathpkg.Clean("/" + p)
+	e, ok := fs.idx.entries[p]
+	if !ok {
+		return nil, p, &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	}
+	return e, p, nil
+}
+
+func (fs *staticFS) Lstat(p string) (os.FileInfo, error) {
+	return fs.Stat(p)
+}
+
+func (fs *staticFS) Stat(p string) (os.FileInfo, error) {
+	e, p, err := fs.lookup("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: pathpkg.Base(p), e: e}, nil
+}
+
+// Hash implements the vfs.Hasher interface with the SHA-256 digests of the
+// manifest.
+func (fs *staticFS) Hash(p, algo string) ([]byte, error) {
+	e, p, err := fs.lookup("hash", p)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Mode.IsRegular() {
+		return nil, &os.PathError{Op: "hash", Path: p, Err: errors.New("not a regular file")}
+	}
+	if algo != vfs.HashSHA256 {
+		return nil, &os.PathError{Op: "hash", Path: p, Err: vfs.ErrHashUnsupported}
+	}
+	return hex.DecodeString(e.Hash)
+}
+
+func (fs *staticFS) ReadDir(p string) ([]os.FileInfo, error) {
+	e, p, err := fs.lookup("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	names := fs.idx.children[p]
+	fis := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		fis[i] = &fileInfo{name: name, e: fs.idx.entries[pathpkg.Join(p, name)]}
+	}
+	return fis, nil
+}
+
+func (fs *staticFS) Open(p string) (vfs.ReadSeekCloser, error) {
+	e, p, err := fs.lookup("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if e.Mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	u := url.URL{Path: p}
+	return &file{
+		fs:   fs,
+		path: p,
+		url:  fs.base + u.EscapedPath(),
+		e:    e,
+		h:    sha256.New(),
+	}, nil
+}
+
+// file is an open file, the body of the current request is read until the
+// file is seeked. The contents are hashed while they are read in sequence
+// from the start.
+type file struct {
+	fs     *staticFS
+	path   string
+	url    string
+	e      *Entry
+	offset int64
+	body   io.ReadCloser // nil when a new request is needed
+	closed bool
+
+	h      hash.Hash // nil when the reads are not sequential
+	hashed int64
+}
+
+func (f *file) request() error {
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return err
+	}
+	if f.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+	}
+	resp, err := f.fs.c.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && f.offset > 0:
+	case resp.StatusCode == http.StatusOK:
+		// the server ignored the range, skip to the offset.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, f.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return os.ErrNotExist
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		resp.Body.Close()
+		return os.ErrPermission
+	default:
+		resp.Body.Close()
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	f.body = resp.Body
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.offset >= f.e.Size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		if err := f.request(); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.path, Err: err}
+		}
+	}
+	if rest := f.e.Size - f.offset; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := f.body.Read(p)
+	if f.h != nil && f.hashed == f.offset {
+		f.h.Write(p[:n])
+		f.hashed += int64(n)
+	}
+	f.offset += int64(n)
+	if err == io.EOF {
+		f.body.Close()
+		f.body = nil
+		if f.offset < f.e.Size {
+			return n, &os.PathError{Op: "read", Path: f.path, Err: io.ErrUnexpectedEOF}
+		}
+		err = nil
+	}
+	if err == nil && f.offset == f.e.Size && f.h != nil && f.hashed == f.e.Size {
+		sum := hex.EncodeToString(f.h.Sum(nil))
+		f.h = nil
+		if sum != f.e.Hash {
+			return n, &os.PathError{Op: "read", Path: f.path, Err: errors.Errorf("hash mismatch, got %s want %s", sum, f.e.Hash)}
+		}
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.e.Size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("invalid whence")}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.New("negative position")}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	if offset == 0 {
+		// reading from the start verifies the hash again.
+		f.h, f.hashed = sha256.New(), 0
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+type fileInfo struct {
+	name string
+	e    *Entry
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.e.Size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.e.Mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi *fileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
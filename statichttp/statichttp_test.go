@@ -0,0 +1,197 @@
+package statichttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/httpfs"
+	"github.com/thomasf/vfs/vfstest"
+)
+
+// publish serves files together with the manifest generated from
+// published, which lets tests serve contents that differ from the manifest.
+func publish(t *testing.T, published, files vfs.FileSystem) (*httptest.Server, *rangeRecorder) {
+	var buf bytes.Buffer
+	if err := WriteManifest(&buf, published, "/"); err != nil {
+		t.Fatal(err)
+	}
+	ns := vfs.NewNameSpace()
+	ns.Bind("/", files, "/", vfs.BindReplace)
+	ns.Bind("/", vfs.Map(map[string]string{ManifestName: buf.String()}), "/", vfs.BindAfter)
+	rr := &rangeRecorder{h: httpfs.New(ns, nil)}
+	srv := httptest.NewServer(rr)
+	t.Cleanup(srv.Close)
+	return srv, rr
+}
+
+// rangeRecorder records the Range headers of file requests.
+type rangeRecorder struct {
+	h      http.Handler
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (rr *rangeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, ManifestName) {
+		rr.mu.Lock()
+		rr.ranges = append(rr.ranges, r.Header.Get("Range"))
+		rr.mu.Unlock()
+	}
+	rr.h.ServeHTTP(w, r)
+}
+
+func TestFS(t *testing.T) {
+	src := vfs.ModeMap(vfs.Map(map[string]string{
+		"a/b.txt":        "bbb",
+		"a/c/d.txt":      "d",
+		"space name.txt": "spaced",
+		"empty":          "",
+	}), map[string]os.FileMode{"a/b.txt": 0640, "a": os.ModeDir | 0750})
+	srv, _ := publish(t, src, src)
+
+	fs, err := New(srv.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vfstest.TestFS(fs, "a/b.txt", "a/c/d.txt", "space name.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0640 || fi.Size() != 3 {
+		t.Fatalf("unexpected file info %v %v", fi.Mode(), fi.Size())
+	}
+	if fi, err := fs.Stat("/a"); err != nil || fi.Mode() != os.ModeDir|0750 {
+		t.Fatalf("unexpected directory info %v: %v", fi, err)
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	if _, err := Safe(srv.URL+"/missing", nil)(); err == nil {
+		t.Fatal("expected error without a manifest")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv, rr := publish(t,
+		vfs.Map(map[string]string{"file": "0123456789", "gone": "x"}),
+		vfs.Map(map[string]string{"file": "0123456789"}),
+	)
+	fs, err := New(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+	if _, err := f.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "78" {
+		t.Fatalf("unexpected content %q: %v", buf, err)
+	}
+	if got := strings.Join(rr.ranges, ","); got != ",bytes=7-" {
+		t.Fatalf("unexpected range requests %q", got)
+	}
+
+	if _, err := vfs.ReadFile(fs, "/gone"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist for a file missing on the server, got %v", err)
+	}
+}
+
+func TestHashMismatch(t *testing.T) {
+	srv, _ := publish(t,
+		vfs.Map(map[string]string{"file": "original"}),
+		vfs.Map(map[string]string{"file": "modified"}),
+	)
+	fs, err := New(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vfs.ReadFile(fs, "/file"); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	// the digest is the one of the manifest.
+	sum, err := vfs.Hash(fs, "/file", vfs.HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256([]byte("original")); !bytes.Equal(sum, want[:]) {
+		t.Fatalf("unexpected digest %x", sum)
+	}
+	// partial reads can not be verified.
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "odified" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m, err := GenerateManifest(vfs.Map(map[string]string{"x/y/z": "zz"}), "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 2 || m.Entries[0].Path != "y" || m.Entries[1].Path != "y/z" || m.Entries[1].Size != 2 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+
+	for _, bad := range []Manifest{
+		{Version: 1, Hash: HashSHA256, Entries: []Entry{{Path: "/abs", Mode: os.ModeDir}}},
+		{Version: 1, Hash: HashSHA256, Entries: []Entry{{Path: "a/../b", Mode: os.ModeDir}}},
+		{Version: 1, Hash: HashSHA256, Entries: []Entry{{Path: "a", Mode: 0644, Hash: "nothex"}}},
+		{Version: 1, Hash: HashSHA256, Entries: []Entry{{Path: "a", Mode: os.ModeDir}, {Path: "a", Mode: os.ModeDir}}},
+		{Version: 1, Hash: HashSHA256, Entries: []Entry{{Path: "a", Mode: 0644, Hash: m.Entries[1].Hash}, {Path: "a/b", Mode: os.ModeDir}}},
+	} {
+		if _, err := FromManifest("http://example.com", &bad, nil); err == nil {
+			t.Errorf("expected invalid manifest %+v to be rejected", bad)
+		}
+	}
+	if _, err := ParseManifest(strings.NewReader(`{"version": 2, "hash": "sha256"}`)); err == nil {
+		t.Error("expected unknown version to be rejected")
+	}
+
+	// directories missing from the manifest are synthesized.
+	fs, err := FromManifest("http://example.com", &Manifest{Version: 1, Hash: HashSHA256, Entries: []Entry{
+		{Path: "a/b/c", Mode: 0644, ModTime: mtime, Size: 2, Hash: m.Entries[1].Hash},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/", "/a", "/a/b"} {
+		fis, err := fs.ReadDir(dir)
+		if err != nil || len(fis) != 1 || (dir != "/a/b") != fis[0].IsDir() {
+			t.Fatalf("unexpected listing of %s %v: %v", dir, fis, err)
+		}
+	}
+	if fi, err := fs.Stat("/a/b/c"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected file info %v: %v", fi, err)
+	}
+}
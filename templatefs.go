@@ -0,0 +1,261 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateOptions configures Template.
+type TemplateOptions struct {
+	// Suffix marks the files which are rendered, it is stripped from their
+	// names. It defaults to ".tmpl".
+	Suffix string
+	// Data returns the data a file is rendered with, path is the name of
+	// the rendered file. The data is nil if Data is not set.
+	Data func(path string) (interface{}, error)
+	// Funcs are added to the functions of every template.
+	Funcs template.FuncMap
+	// Include is the FileSystem the include function reads absolute names
+	// from, it defaults to the wrapped FileSystem. Set it to a NameSpace to
+	// include files from anywhere in it.
+	Include FileSystem
+}
+
+// maxTemplateIncludes limits the nesting of included templates.
+const maxTemplateIncludes = 32
+
+// Template wraps fs and renders every regular file ending in the template
+// suffix through text/template when it is read. Rendered files are shown
+// with the suffix stripped, a template is left visible when a file with
+// the stripped name exists.
+//
+// Templates can use {{include "name"}} to insert the contents of another
+// file. Absolute names are read from the Include FileSystem. Relative names
+// are resolved against the directory of the including template and read
+// from the same FileSystem as it, so they keep working when the template
+// tree is mounted elsewhere. Included files which end in the suffix are
+// rendered with the same data.
+//
+// Stat and ReadDir render templates to report their size, the size of the
+// template itself is reported if rendering fails. Open returns the
+// template error, which includes the file name and line.
+func Template(fs FileSystem, opts *TemplateOptions) FileSystem {
+	if opts == nil {
+		opts = &TemplateOptions{}
+	}
+	tfs := &templateFS{fs: fs, opts: *opts}
+	if tfs.opts.Suffix == "" {
+		tfs.opts.Suffix = ".tmpl"
+	}
+	if tfs.opts.Include == nil {
+		tfs.opts.Include = fs
+	}
+	return tfs
+}
+
+type templateFS struct {
+	fs   FileSystem
+	opts TemplateOptions
+}
+
+func (fs *templateFS) String() string {
+	return fmt.Sprintf("template(%s)", fs.fs.String())
+}
+
+// resolve returns the template rendered as path, or ok false if path is not
+// a rendered file.
+func (fs *templateFS) resolve(path string) (string, os.FileInfo, bool) {
+	if _, err := fs.fs.Lstat(path); err == nil || !os.IsNotExist(err) {
+		return "", nil, false
+	}
+	tpath := path + fs.opts.Suffix
+	fi, err := fs.fs.Stat(tpath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return tpath, fi, true
+}
+
+// hidden reports whether the existing file path is hidden because it is
+// shown rendered.
+func (fs *templateFS) hidden(path string) bool {
+	if !strings.HasSuffix(path, fs.opts.Suffix) || len(pathpkg.Base(path)) <= len(fs.opts.Suffix) {
+		return false
+	}
+	_, _, ok := fs.resolve(strings.TrimSuffix(path, fs.opts.Suffix))
+	return ok
+}
+
+// render executes the template at tpath for the file path.
+func (fs *templateFS) render(path, tpath string) ([]byte, error) {
+	var data interface{}
+	if fs.opts.Data != nil {
+		var err error
+		if data, err = fs.opts.Data(path); err != nil {
+			return nil, err
+		}
+	}
+	return fs.execute(templateRef{path: tpath}, data, nil)
+}
+
+// templateRef is a file read by a template, from the Include FileSystem if
+// include is set and from the wrapped FileSystem otherwise.
+type templateRef struct {
+	include bool
+	path    string
+}
+
+// execute renders the template t, stack holds the templates which include
+// it.
+func (fs *templateFS) execute(t templateRef, data interface{}, stack []templateRef) ([]byte, error) {
+	for _, r := range stack {
+		if r == t {
+			var paths []string
+			for _, r := range append(stack, t) {
+				paths = append(paths, r.path)
+			}
+			return nil, errors.Errorf("include cycle: %s", strings.Join(paths, " -> "))
+		}
+	}
+	if len(stack) >= maxTemplateIncludes {
+		return nil, errors.Errorf("includes nested too deeply in %s", stack[0].path)
+	}
+	src, err := ReadFile(fs.refFS(t), t.path)
+	if err != nil {
+		return nil, err
+	}
+	stack = append(stack[:len(stack):len(stack)], t)
+	include := func(name string) (string, error) {
+		r := templateRef{include: true, path: name}
+		if !pathpkg.IsAbs(name) {
+			r = templateRef{include: t.include, path: pathpkg.Join(pathpkg.Dir(t.path), name)}
+		}
+		if strings.HasSuffix(r.path, fs.opts.Suffix) {
+			b, err := fs.execute(r, data, stack)
+			return string(b), err
+		}
+		b, err := ReadFile(fs.refFS(r), r.path)
+		return string(b), err
+	}
+	tmpl, err := template.New(t.path).
+		Funcs(template.FuncMap{"include": include}).
+		Funcs(fs.opts.Funcs).
+		Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// refFS returns the FileSystem r is read from.
+func (fs *templateFS) refFS(r templateRef) FileSystem {
+	if r.include {
+		return fs.opts.Include
+	}
+	return fs.fs
+}
+
+func (fs *templateFS) Open(path string) (ReadSeekCloser, error) {
+	path = pathpkg.Clean("/" + path)
+	if fs.hidden(path) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	tpath, _, ok := fs.resolve(path)
+	if !ok {
+		return fs.fs.Open(path)
+	}
+	b, err := fs.render(path, tpath)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return nopCloser{bytes.NewReader(b)}, nil
+}
+
+func (fs *templateFS) stat(op, path string, f func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	if fs.hidden(path) {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	tpath, tfi, ok := fs.resolve(path)
+	if !ok {
+		return f(path)
+	}
+	return fs.renderedInfo(path, tpath, tfi), nil
+}
+
+func (fs *templateFS) Stat(path string) (os.FileInfo, error) {
+	return fs.stat("stat", path, fs.fs.Stat)
+}
+
+func (fs *templateFS) Lstat(path string) (os.FileInfo, error) {
+	return fs.stat("lstat", path, fs.fs.Lstat)
+}
+
+func (fs *templateFS) ReadDir(path string) ([]os.FileInfo, error) {
+	path = pathpkg.Clean("/" + path)
+	fis, err := fs.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(fis))
+	for _, fi := range fis {
+		names[fi.Name()] = true
+	}
+	list := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), fs.opts.Suffix)
+		if name == fi.Name() || name == "" || names[name] {
+			list = append(list, fi)
+			continue
+		}
+		tpath := pathpkg.Join(path, fi.Name())
+		tfi, err := fs.fs.Stat(tpath)
+		if err != nil || !tfi.Mode().IsRegular() {
+			list = append(list, fi)
+			continue
+		}
+		list = append(list, fs.renderedInfo(pathpkg.Join(path, name), tpath, tfi))
+	}
+	sort.Sort(byName(list))
+	return list, nil
+}
+
+func (fs *templateFS) renderedInfo(path, tpath string, tfi os.FileInfo) os.FileInfo {
+	size := tfi.Size()
+	if b, err := fs.render(path, tpath); err == nil {
+		size = int64(len(b))
+	}
+	return renderedFI{
+		name:    pathpkg.Base(path),
+		size:    size,
+		mode:    tfi.Mode(),
+		modTime: tfi.ModTime(),
+	}
+}
+
+// renderedFI is the file info of a rendered template.
+type renderedFI struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi renderedFI) Name() string       { return fi.name }
+func (fi renderedFI) Size() int64        { return fi.size }
+func (fi renderedFI) Mode() os.FileMode  { return fi.mode }
+func (fi renderedFI) ModTime() time.Time { return fi.modTime }
+func (fi renderedFI) IsDir() bool        { return false }
+func (fi renderedFI) Sys() interface{}   { return nil }
@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplate(t *testing.T) {
+	fs := Template(Map(map[string]string{
+		"etc/app.conf.tmpl":  "name={{.Name}}\npath={{path}}\n{{include \"header.tmpl\"}}",
+		"etc/header.tmpl":    "# {{upper .Name}}",
+		"etc/plain.txt":      "plain {{.Name}}",
+		"etc/raw.tmpl":       "{{include \"plain.txt\"}}",
+		"taken.tmpl":         "{{.Name}}",
+		"taken":              "real file",
+		"broken/bad.tmpl":    "line 1\n{{.Name}\n",
+		"broken/exec.tmpl":   "line 1\nline 2 {{index .Name 100}}\n",
+		"broken/cycle1.tmpl": "{{include \"cycle2.tmpl\"}}",
+		"broken/cycle2.tmpl": "{{include \"cycle1.tmpl\"}}",
+	}), &TemplateOptions{
+		Data: func(path string) (interface{}, error) {
+			return map[string]string{"Name": "app"}, nil
+		},
+		Funcs: template.FuncMap{
+			"upper": strings.ToUpper,
+			"path":  func() string { return "set" },
+		},
+	})
+
+	for name, content := range map[string]string{
+		"/etc/app.conf":  "name=app\npath=set\n# APP",
+		"/etc/header":    "# APP",
+		"/etc/raw":       "plain {{.Name}}",
+		"/etc/plain.txt": "plain {{.Name}}",
+		"/taken":         "real file",
+		"/taken.tmpl":    "{{.Name}}",
+	} {
+		data, err := ReadFile(fs, name)
+		if err != nil || string(data) != content {
+			t.Fatalf("%s: unexpected content %q: %v", name, data, err)
+		}
+		fi, err := fs.Stat(name)
+		if err != nil || fi.Size() != int64(len(content)) {
+			t.Fatalf("%s: unexpected size %v: %v", name, fi, err)
+		}
+	}
+	if _, err := fs.Stat("/etc/app.conf.tmpl"); !os.IsNotExist(err) {
+		t.Fatalf("expected the template to be hidden, got %v", err)
+	}
+	fis, err := fs.ReadDir("/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+		if fi.Name() == "app.conf" && fi.Size() != int64(len("name=app\npath=set\n# APP")) {
+			t.Fatalf("unexpected size in listing %d", fi.Size())
+		}
+	}
+	if got := strings.Join(names, ","); got != "app.conf,header,plain.txt,raw" {
+		t.Fatalf("unexpected listing %s", got)
+	}
+
+	for name, msg := range map[string]string{
+		"/broken/bad":    "/broken/bad.tmpl:2",
+		"/broken/exec":   "/broken/exec.tmpl:2",
+		"/broken/cycle1": "include cycle",
+	} {
+		_, err := fs.Open(name)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected error containing %q, got %v", name, msg, err)
+		}
+		// a failed render falls back to the size of the template.
+		if _, err := fs.Stat(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTemplateIncludeNameSpace(t *testing.T) {
+	ns := NewNameSpace()
+	ns.Bind("/shared", Map(map[string]string{"footer.txt": "-- footer"}), "/", BindReplace)
+	ns.Bind("/site", Template(Map(map[string]string{
+		"index.html.tmpl": "body\n{{include \"/shared/footer.txt\"}}",
+	}), &TemplateOptions{Include: ns}), "/", BindReplace)
+
+	data, err := ReadFile(ns, "/site/index.html")
+	if err != nil || string(data) != "body\n-- footer" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+}
+
+func TestTemplateIncludeRelative(t *testing.T) {
+	ns := NewNameSpace()
+	ns.Bind("/shared", Map(map[string]string{
+		"footer.tmpl": "-- {{.}}\n{{include \"license.txt\"}}",
+		"license.txt": "MIT",
+	}), "/", BindReplace)
+	ns.Bind("/site", Template(Map(map[string]string{
+		"pages/index.html.tmpl": "{{include \"../partials/head.tmpl\"}}\n{{include \"/shared/footer.tmpl\"}}",
+		"partials/head.tmpl":    "<h1>{{.}}</h1>",
+	}), &TemplateOptions{
+		Data:    func(path string) (interface{}, error) { return path, nil },
+		Include: ns,
+	}), "/", BindReplace)
+
+	data, err := ReadFile(ns, "/site/pages/index.html")
+	if want := "<h1>/pages/index.html</h1>\n-- /pages/index.html\nMIT"; err != nil || string(data) != want {
+		t.Fatalf("got %q, want %q: %v", data, want, err)
+	}
+}
@@ -0,0 +1,311 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A TreeNode is a file or directory of a hash tree computed by TreeHash.
+//
+// The hash of a regular file is the SHA-256 digest of its contents and
+// that of a symbolic link the digest of its destination, other special
+// files hash like empty files. The hash of a directory is the SHA-256
+// digest of a line per child sorted by name: the type (dir, file, link or
+// special), the permission bits in octal and the name followed by a NUL
+// byte and the hash of the child. Modification times are not hashed.
+type TreeNode struct {
+	Path     string
+	Mode     os.FileMode
+	Hash     []byte
+	Children []*TreeNode // sorted by name, nil for files
+
+	fi os.FileInfo
+}
+
+// TreeHashOptions configures TreeHash.
+type TreeHashOptions struct {
+	// Workers is the number of files hashed in parallel, it defaults to
+	// the number of CPUs.
+	Workers int
+	// Cache, if set, remembers file digests between calls so only files
+	// whose size or modification time changed are read again.
+	Cache *TreeCache
+}
+
+// TreeHash computes the hash tree of the directory tree at root in fs from
+// the bottom up. File contents are hashed with Hash, so digests stored by
+// Hasher file systems are used. Like Walk, TreeHash does not follow
+// symbolic links.
+func TreeHash(fs FileSystem, root string, opts *TreeHashOptions) (*TreeNode, error) {
+	var o TreeHashOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	root = pathpkg.Clean("/" + root)
+	fi, err := fs.Lstat(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "tree hash")
+	}
+	var files []*TreeNode
+	tree, err := treeNodes(fs, root, fi, &files)
+	if err != nil {
+		return nil, errors.Wrap(err, "tree hash")
+	}
+	if err := hashTreeFiles(fs, files, &o); err != nil {
+		return nil, errors.Wrap(err, "tree hash")
+	}
+	hashTreeDirs(tree)
+	if o.Cache != nil {
+		o.Cache.prune(root, files)
+	}
+	return tree, nil
+}
+
+// treeNodes reads the tree at p and appends the nodes which are not
+// directories to files.
+func treeNodes(fs FileSystem, p string, fi os.FileInfo, files *[]*TreeNode) (*TreeNode, error) {
+	n := &TreeNode{Path: p, Mode: fi.Mode(), fi: fi}
+	if !fi.IsDir() {
+		*files = append(*files, n)
+		return n, nil
+	}
+	fis, err := fs.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byName(fis))
+	n.Children = make([]*TreeNode, 0, len(fis))
+	for _, cfi := range fis {
+		c, err := treeNodes(fs, pathpkg.Join(p, cfi.Name()), cfi, files)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, c)
+	}
+	return n, nil
+}
+
+// hashTreeFiles hashes files using a bounded number of workers, the first
+// error stops the remaining work.
+func hashTreeFiles(fs FileSystem, files []*TreeNode, o *TreeHashOptions) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		jobs     = make(chan *TreeNode)
+	)
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+				if err := hashTreeFile(fs, n, o.Cache); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, n := range files {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+func hashTreeFile(fs FileSystem, n *TreeNode, cache *TreeCache) error {
+	switch {
+	case n.Mode.IsRegular():
+		if sum := cache.lookup(n.Path, n.fi); sum != nil {
+			n.Hash = sum
+			return nil
+		}
+		sum, err := Hash(fs, n.Path, HashSHA256)
+		if err != nil {
+			return err
+		}
+		n.Hash = sum
+		cache.store(n.Path, n.fi, sum)
+	case n.Mode&os.ModeSymlink != 0:
+		dest, err := Readlink(fs, n.Path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte(dest))
+		n.Hash = sum[:]
+	default:
+		sum := sha256.Sum256(nil)
+		n.Hash = sum[:]
+	}
+	return nil
+}
+
+// hashTreeDirs computes the hashes of the directories of n from the bottom
+// up.
+func hashTreeDirs(n *TreeNode) {
+	if n.Children == nil {
+		return
+	}
+	h := sha256.New()
+	for _, c := range n.Children {
+		hashTreeDirs(c)
+		fmt.Fprintf(h, "%s %04o %s\x00", treeKind(c.Mode), uint32(c.Mode.Perm()), pathpkg.Base(c.Path))
+		h.Write(c.Hash)
+	}
+	n.Hash = h.Sum(nil)
+}
+
+func treeKind(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	case mode&os.ModeSymlink != 0:
+		return "link"
+	}
+	return "special"
+}
+
+// Find returns the node of the tree n for path, or nil if there is none.
+func (n *TreeNode) Find(path string) *TreeNode {
+	path = pathpkg.Clean("/" + path)
+	for n != nil && n.Path != path {
+		if !hasPathPrefix(path, n.Path) {
+			return nil
+		}
+		var next *TreeNode
+		for _, c := range n.Children {
+			if hasPathPrefix(path, c.Path) {
+				next = c
+				break
+			}
+		}
+		n = next
+	}
+	return n
+}
+
+// WriteTreeHash writes the hash tree n to w for inspection, a line per node
+// with the hex encoded hash, the kind, the permission bits and the path.
+func WriteTreeHash(w io.Writer, n *TreeNode) error {
+	var buf bytes.Buffer
+	var write func(n *TreeNode)
+	write = func(n *TreeNode) {
+		fmt.Fprintf(&buf, "%x %-4s %04o %s\n", n.Hash, treeKind(n.Mode), uint32(n.Mode.Perm()), n.Path)
+		for _, c := range n.Children {
+			write(c)
+		}
+	}
+	write(n)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// A TreeCache remembers the digests of files computed by TreeHash, keyed
+// on their path, size and modification time like HashCache. Files without a
+// modification time are never cached. A cache must only be used with one
+// FileSystem, it can be saved with WriteTreeCache to be reused by later
+// runs.
+type TreeCache struct {
+	sums *hashStore
+}
+
+// treeCacheEntry is a cached digest as written by WriteTreeCache.
+type treeCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"sha256"`
+}
+
+// NewTreeCache returns an empty TreeCache.
+func NewTreeCache() *TreeCache {
+	return &TreeCache{sums: newHashStore()}
+}
+
+// ReadTreeCache reads a cache written by WriteTreeCache.
+func ReadTreeCache(r io.Reader) (*TreeCache, error) {
+	var files map[string]treeCacheEntry
+	if err := json.NewDecoder(r).Decode(&files); err != nil {
+		return nil, errors.Wrap(err, "reading tree cache")
+	}
+	c := NewTreeCache()
+	for p, e := range files {
+		sum, err := hex.DecodeString(e.Hash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, errors.Errorf("reading tree cache: invalid digest for %q", p)
+		}
+		c.sums.sums[hashKey{p, HashSHA256}] = hashEntry{size: e.Size, modTime: e.ModTime, sum: sum}
+	}
+	return c, nil
+}
+
+// WriteTreeCache writes c as JSON.
+func WriteTreeCache(w io.Writer, c *TreeCache) error {
+	c.sums.mu.Lock()
+	files := make(map[string]treeCacheEntry, len(c.sums.sums))
+	for k, e := range c.sums.sums {
+		files[k.path] = treeCacheEntry{Size: e.size, ModTime: e.modTime, Hash: hex.EncodeToString(e.sum)}
+	}
+	c.sums.mu.Unlock()
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (c *TreeCache) lookup(p string, fi os.FileInfo) []byte {
+	if c == nil {
+		return nil
+	}
+	return c.sums.lookup(hashKey{p, HashSHA256}, fi)
+}
+
+func (c *TreeCache) store(p string, fi os.FileInfo, sum []byte) {
+	if c == nil {
+		return
+	}
+	c.sums.store(hashKey{p, HashSHA256}, fi, sum)
+}
+
+// prune forgets the files below root which are not in files anymore.
+func (c *TreeCache) prune(root string, files []*TreeNode) {
+	seen := make(map[string]bool, len(files))
+	for _, n := range files {
+		seen[n.Path] = true
+	}
+	c.sums.mu.Lock()
+	defer c.sums.mu.Unlock()
+	for k := range c.sums.sums {
+		if hasPathPrefix(k.path, root) && !seen[k.path] {
+			delete(c.sums.sums, k)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func treeHashOrDie(t *testing.T, fs FileSystem, root string, opts *TreeHashOptions) *TreeNode {
+	t.Helper()
+	n, err := TreeHash(fs, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestTreeHash(t *testing.T) {
+	files := map[string]string{
+		"a.txt":       "a",
+		"src/b.go":    "package b",
+		"src/c/d.go":  "package d",
+		"docs/README": "readme",
+	}
+	fs := Map(files)
+	tree := treeHashOrDie(t, fs, "/", nil)
+
+	var buf bytes.Buffer
+	if err := WriteTreeHash(&buf, tree); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	var paths []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		paths = append(paths, fields[len(fields)-1])
+	}
+	if got := strings.Join(paths, ","); got != "/,/a.txt,/docs,/docs/README,/src,/src/b.go,/src/c,/src/c/d.go" {
+		t.Fatalf("unexpected tree:\n%s", buf.String())
+	}
+	if want := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb file 0444 /a.txt"; lines[1] != want {
+		t.Fatalf("got %q, want %q", lines[1], want)
+	}
+
+	// the hash does not depend on the number of workers.
+	for _, workers := range []int{1, 3, 16} {
+		n := treeHashOrDie(t, fs, "/", &TreeHashOptions{Workers: workers})
+		if !bytes.Equal(n.Hash, tree.Hash) {
+			t.Fatalf("%d workers: got %x, want %x", workers, n.Hash, tree.Hash)
+		}
+	}
+
+	// changes propagate to the parent directories only.
+	files["src/c/d.go"] = "package e"
+	changed := treeHashOrDie(t, Map(files), "/", nil)
+	for path, same := range map[string]bool{
+		"/":           false,
+		"/src":        false,
+		"/src/c":      false,
+		"/src/c/d.go": false,
+		"/src/b.go":   true,
+		"/docs":       true,
+	} {
+		if got := bytes.Equal(changed.Find(path).Hash, tree.Find(path).Hash); got != same {
+			t.Errorf("%s: same hash %v, want %v", path, got, same)
+		}
+	}
+	files["src/c/d.go"] = "package d"
+
+	// modes and names are part of the hash.
+	moded := treeHashOrDie(t, ModeMap(fs, map[string]os.FileMode{"a.txt": 0755}), "/", nil)
+	if bytes.Equal(moded.Hash, tree.Hash) {
+		t.Fatal("expected mode change to change the hash")
+	}
+	files["b.txt"] = files["a.txt"]
+	delete(files, "a.txt")
+	if renamed := treeHashOrDie(t, Map(files), "/", nil); bytes.Equal(renamed.Hash, tree.Hash) {
+		t.Fatal("expected rename to change the hash")
+	}
+
+	// a subtree hashes like the same tree bound at the root.
+	ns := NewNameSpace()
+	ns.Bind("/", Map(map[string]string{"b.go": "package b", "c/d.go": "package d"}), "/", BindReplace)
+	if sub := treeHashOrDie(t, ns, "/", nil); !bytes.Equal(sub.Hash, tree.Find("/src").Hash) {
+		t.Fatalf("got %x, want %x", sub.Hash, tree.Find("/src").Hash)
+	}
+
+	if tree.Find("/src/missing") != nil || tree.Find("/a.txt/x") != nil {
+		t.Fatal("expected no node for missing paths")
+	}
+	if _, err := TreeHash(fs, "/missing", nil); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestTreeHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	write := func(name, content string, modTime time.Time) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "a", now)
+	write("sub/b", "b", now)
+	write("sub/c", "c", now)
+
+	cfs := &countingFS{FileSystem: OS(dir)}
+	cache := NewTreeCache()
+	opts := &TreeHashOptions{Workers: 2, Cache: cache}
+	first := treeHashOrDie(t, cfs, "/", opts)
+	if cfs.opens != 3 {
+		t.Fatalf("hashed %d files, want 3", cfs.opens)
+	}
+	if again := treeHashOrDie(t, cfs, "/", opts); !bytes.Equal(again.Hash, first.Hash) || cfs.opens != 3 {
+		t.Fatalf("expected cached digests, %d opens", cfs.opens)
+	}
+
+	write("sub/b", "B", now.Add(time.Second))
+	changed := treeHashOrDie(t, cfs, "/", opts)
+	if cfs.opens != 4 {
+		t.Fatalf("hashed %d files, want only the changed one", cfs.opens-3)
+	}
+	if bytes.Equal(changed.Hash, first.Hash) {
+		t.Fatal("expected the hash to change")
+	}
+	uncached := treeHashOrDie(t, OS(dir), "/", nil)
+	if !bytes.Equal(changed.Hash, uncached.Hash) {
+		t.Fatalf("cached hash %x differs from %x", changed.Hash, uncached.Hash)
+	}
+
+	// the cache survives a round trip and forgets removed files.
+	if err := os.Remove(filepath.Join(dir, "sub", "c")); err != nil {
+		t.Fatal(err)
+	}
+	treeHashOrDie(t, cfs, "/", opts)
+	var buf bytes.Buffer
+	if err := WriteTreeCache(&buf, cache); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "/sub/c") {
+		t.Fatalf("removed file still cached:\n%s", buf.String())
+	}
+	loaded, err := ReadTreeCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opens := cfs.opens
+	n := treeHashOrDie(t, cfs, "/", &TreeHashOptions{Cache: loaded})
+	if cfs.opens != opens {
+		t.Fatalf("hashed %d files with a loaded cache", cfs.opens-opens)
+	}
+	if sum := n.Find("/a").Hash; hex.EncodeToString(sum) != "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" {
+		t.Fatalf("unexpected digest %x", sum)
+	}
+	if _, err := ReadTreeCache(strings.NewReader(`{"/a": {"sha256": "xx"}}`)); err == nil {
+		t.Fatal("expected error for invalid digest")
+	}
+}
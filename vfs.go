@@ -10,6 +10,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+
+	"github.com/pkg/errors"
 )
 
 // The FileSystem interface specifies the methods godoc is using
@@ -53,6 +55,39 @@ type OSPather interface {
 	OSPath() string
 }
 
+// Readlinker is implemented by file systems that can return the destination
+// of symbolic links.
+type Readlinker interface {
+	Readlink(path string) (string, error)
+}
+
+// Invalidator is implemented by file systems which cache data read from the
+// file system they wrap. Invalidate drops what is cached for path, so a
+// file watcher can report changes.
+type Invalidator interface {
+	Invalidate(path string)
+}
+
+// Readlink returns the destination of the symbolic link named by path in fs.
+// If fs is not a Readlinker, the link is read from the OS path of the file
+// if its FileInfo implements OSPather.
+func Readlink(fs FileSystem, path string) (string, error) {
+	if rl, ok := fs.(Readlinker); ok {
+		return rl.Readlink(path)
+	}
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: errors.New("not a symbolic link")}
+	}
+	if op, ok := fi.(OSPather); ok {
+		return os.Readlink(op.OSPath())
+	}
+	return "", &os.PathError{Op: "readlink", Path: path, Err: errors.Errorf("%s does not support symbolic links", fs)}
+}
+
 // Opener is a minimal virtual filesystem that can only open regular files.
 type Opener interface {
 	Open(name string) (ReadSeekCloser, error)
@@ -0,0 +1,269 @@
+// Package vfstest implements support for testing implementations of
+// vfs.FileSystem, similar to what testing/fstest does for io/fs.
+package vfstest // import "github.com/thomasf/vfs/vfstest"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// notExistName is looked up in every directory to verify that missing files
+// are reported as os.IsNotExist errors.
+const notExistName = "vfstest-does-not-exist"
+
+// TestFS tests a vfs.FileSystem implementation. It walks the entire tree of
+// files in fsys starting at "/", opening and checking that each file behaves
+// correctly. It also checks that the file system contains at least the
+// expected files and directories. Expected paths are slash separated, the
+// leading slash is optional.
+//
+// The checks include that:
+//
+//   - Stat, Lstat and ReadDir agree on names, sizes and file types.
+//   - IsDir and Mode().IsDir() are consistent.
+//   - ReadDir returns entries sorted by name without duplicates.
+//   - Open fails for directories and ReadDir fails for regular files.
+//   - Read returns exactly Size bytes and Seek works from all origins.
+//   - missing paths are reported with os.IsNotExist errors.
+//   - OSPath values, when provided, point to existing OS files.
+//
+// If TestFS finds any misbehaviors, it returns an error reporting all of
+// them.
+func TestFS(fsys vfs.FileSystem, expected ...string) error {
+	t := &fsTester{
+		fsys: fsys,
+		seen: make(map[string]bool),
+	}
+	t.checkRoot()
+	for _, name := range expected {
+		name = pathpkg.Clean("/" + name)
+		if !t.seen[name] {
+			t.errorf("expected but not found: %s", name)
+		}
+	}
+	if len(t.errs) == 0 {
+		return nil
+	}
+	return errors.Errorf("TestFS found errors in %s:\n%s", fsys, strings.Join(t.errs, "\n"))
+}
+
+// fsTester holds the state of a single TestFS run.
+type fsTester struct {
+	fsys vfs.FileSystem
+	errs []string
+	seen map[string]bool
+}
+
+func (t *fsTester) errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Sprintf(format, args...))
+}
+
+func (t *fsTester) checkRoot() {
+	fi, err := t.fsys.Stat("/")
+	if err != nil {
+		t.errorf("/: Stat: %v", err)
+		return
+	}
+	if !fi.IsDir() || !fi.Mode().IsDir() {
+		t.errorf("/: Stat: not a directory: IsDir=%v Mode=%v", fi.IsDir(), fi.Mode())
+		return
+	}
+	t.seen["/"] = true
+	t.checkDir("/")
+}
+
+// checkDir checks the directory dir and recurses into its subdirectories.
+func (t *fsTester) checkDir(dir string) {
+	if rc, err := t.fsys.Open(dir); err == nil {
+		rc.Close()
+		t.errorf("%s: Open: succeeded on a directory", dir)
+	}
+
+	missing := pathpkg.Join(dir, notExistName)
+	if _, err := t.fsys.Stat(missing); !os.IsNotExist(err) {
+		t.errorf("%s: Stat: want os.IsNotExist error, got %v", missing, err)
+	}
+	if _, err := t.fsys.Lstat(missing); !os.IsNotExist(err) {
+		t.errorf("%s: Lstat: want os.IsNotExist error, got %v", missing, err)
+	}
+
+	list, err := t.fsys.ReadDir(dir)
+	if err != nil {
+		t.errorf("%s: ReadDir: %v", dir, err)
+		return
+	}
+	if !sort.SliceIsSorted(list, func(i, j int) bool { return list[i].Name() < list[j].Name() }) {
+		t.errorf("%s: ReadDir: entries are not sorted by name: %s", dir, names(list))
+	}
+	have := make(map[string]bool, len(list))
+	for _, fi := range list {
+		name := fi.Name()
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			t.errorf("%s: ReadDir: invalid entry name %q", dir, name)
+			continue
+		}
+		if have[name] {
+			t.errorf("%s: ReadDir: duplicate entry %q", dir, name)
+			continue
+		}
+		have[name] = true
+
+		p := pathpkg.Join(dir, name)
+		t.seen[p] = true
+		t.checkInfo(p, "ReadDir", fi)
+		t.checkEntry(p, fi)
+		switch {
+		case fi.IsDir():
+			t.checkDir(p)
+		case fi.Mode().IsRegular():
+			t.checkFile(p, fi)
+		}
+	}
+}
+
+// checkEntry compares the ReadDir entry for p with the results of Lstat and
+// Stat.
+func (t *fsTester) checkEntry(p string, entry os.FileInfo) {
+	lfi, err := t.fsys.Lstat(p)
+	if err != nil {
+		t.errorf("%s: Lstat: %v", p, err)
+		return
+	}
+	t.checkInfo(p, "Lstat", lfi)
+	t.compareInfo(p, "Lstat", entry, lfi)
+	if entry.Mode()&os.ModeSymlink != 0 {
+		return
+	}
+	fi, err := t.fsys.Stat(p)
+	if err != nil {
+		t.errorf("%s: Stat: %v", p, err)
+		return
+	}
+	t.checkInfo(p, "Stat", fi)
+	t.compareInfo(p, "Stat", entry, fi)
+}
+
+// checkInfo checks that a single FileInfo is internally consistent.
+func (t *fsTester) checkInfo(p, op string, fi os.FileInfo) {
+	if fi.IsDir() != fi.Mode().IsDir() {
+		t.errorf("%s: %s: IsDir=%v but Mode=%v", p, op, fi.IsDir(), fi.Mode())
+	}
+	if fi.Mode().IsRegular() && fi.Size() < 0 {
+		t.errorf("%s: %s: negative size %d", p, op, fi.Size())
+	}
+	if op != "ReadDir" && fi.Name() != pathpkg.Base(p) {
+		t.errorf("%s: %s: Name()=%q, want %q", p, op, fi.Name(), pathpkg.Base(p))
+	}
+	if opr, ok := fi.(vfs.OSPather); ok {
+		if _, err := os.Lstat(opr.OSPath()); err != nil {
+			t.errorf("%s: %s: OSPath %q: %v", p, op, opr.OSPath(), err)
+		}
+	}
+}
+
+// compareInfo reports differences between a ReadDir entry and a Stat or
+// Lstat result for the same path.
+func (t *fsTester) compareInfo(p, op string, entry, fi os.FileInfo) {
+	if entry.Name() != fi.Name() {
+		t.errorf("%s: ReadDir and %s disagree on Name: %q != %q", p, op, entry.Name(), fi.Name())
+	}
+	if entry.IsDir() != fi.IsDir() {
+		t.errorf("%s: ReadDir and %s disagree on IsDir: %v != %v", p, op, entry.IsDir(), fi.IsDir())
+	}
+	if entry.Mode()&os.ModeType != fi.Mode()&os.ModeType {
+		t.errorf("%s: ReadDir and %s disagree on file type: %v != %v", p, op, entry.Mode(), fi.Mode())
+	}
+	if entry.Mode().IsRegular() && entry.Size() != fi.Size() {
+		t.errorf("%s: ReadDir and %s disagree on Size: %d != %d", p, op, entry.Size(), fi.Size())
+	}
+	eop, eok := entry.(vfs.OSPather)
+	fop, fok := fi.(vfs.OSPather)
+	if eok && fok && eop.OSPath() != fop.OSPath() {
+		t.errorf("%s: ReadDir and %s disagree on OSPath: %q != %q", p, op, eop.OSPath(), fop.OSPath())
+	}
+}
+
+// checkFile reads the regular file p and exercises Seek.
+func (t *fsTester) checkFile(p string, fi os.FileInfo) {
+	if _, err := t.fsys.ReadDir(p); err == nil {
+		t.errorf("%s: ReadDir: succeeded on a regular file", p)
+	}
+
+	f, err := t.fsys.Open(p)
+	if err != nil {
+		t.errorf("%s: Open: %v", p, err)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.errorf("%s: Close: %v", p, err)
+		}
+	}()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.errorf("%s: Read: %v", p, err)
+		return
+	}
+	size := int64(len(data))
+	if size != fi.Size() {
+		t.errorf("%s: Read returned %d bytes, Size is %d", p, size, fi.Size())
+	}
+
+	if n, err := f.Seek(0, io.SeekEnd); err != nil || n != size {
+		t.errorf("%s: Seek(0, SeekEnd) = %d, %v; want %d, nil", p, n, err, size)
+		return
+	}
+	if n, err := f.Seek(0, io.SeekStart); err != nil || n != 0 {
+		t.errorf("%s: Seek(0, SeekStart) = %d, %v; want 0, nil", p, n, err)
+		return
+	}
+	t.checkRead(p, f, data)
+
+	if size < 2 {
+		return
+	}
+	mid := size / 2
+	if n, err := f.Seek(mid, io.SeekStart); err != nil || n != mid {
+		t.errorf("%s: Seek(%d, SeekStart) = %d, %v; want %d, nil", p, mid, n, err, mid)
+		return
+	}
+	if n, err := f.Seek(0, io.SeekCurrent); err != nil || n != mid {
+		t.errorf("%s: Seek(0, SeekCurrent) = %d, %v; want %d, nil", p, n, err, mid)
+		return
+	}
+	t.checkRead(p, f, data[mid:])
+	if n, err := f.Seek(-mid, io.SeekEnd); err != nil || n != size-mid {
+		t.errorf("%s: Seek(%d, SeekEnd) = %d, %v; want %d, nil", p, -mid, n, err, size-mid)
+		return
+	}
+	t.checkRead(p, f, data[size-mid:])
+}
+
+func (t *fsTester) checkRead(p string, r io.Reader, want []byte) {
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.errorf("%s: Read after Seek: %v", p, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.errorf("%s: Read after Seek returned %q, want %q", p, got, want)
+	}
+}
+
+func names(list []os.FileInfo) string {
+	var s []string
+	for _, fi := range list {
+		s = append(s, fi.Name())
+	}
+	return strings.Join(s, " ")
+}
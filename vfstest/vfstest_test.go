@@ -0,0 +1,123 @@
+package vfstest
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thomasf/vfs"
+)
+
+func fixture(path string) string {
+	return "../test-fixtures/" + path
+}
+
+func TestBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		fs       vfs.FileSystem
+		expected []string
+	}{
+		{
+			name: "map",
+			fs: vfs.Map(map[string]string{
+				"foo/bar/three.txt": "333",
+				"foo/bar.txt":       "22",
+				"top.txt":           "top.txt file",
+				"empty":             "",
+			}),
+			expected: []string{"foo", "foo/bar", "foo/bar/three.txt", "foo/bar.txt", "top.txt", "empty"},
+		},
+		{
+			name: "empty map",
+			fs:   vfs.Map(map[string]string{}),
+		},
+		{
+			name: "filemap",
+			fs: vfs.FileMap(map[string]string{
+				"1/2/a": fixture("C/animals/cats/cats"),
+				"1/b":   fixture("C/animals/cats/C-cats"),
+				"c":     fixture("B/things/wood/tree/tree"),
+			}),
+			expected: []string{"1", "1/2", "1/2/a", "1/b", "c"},
+		},
+		{
+			name: "empty filemap",
+			fs:   vfs.FileMap(map[string]string{}),
+		},
+		{
+			name:     "os",
+			fs:       vfs.OS(fixture("B")),
+			expected: []string{"things/wood/table/table", "things/wood/tree/B-tree"},
+		},
+		{
+			name:     "onefile",
+			fs:       vfs.OneFile(fixture("C/animals/cats/cats"), "kitten"),
+			expected: []string{"kitten"},
+		},
+		{
+			name:     "exclude",
+			fs:       vfs.Exclude(vfs.OS(fixture("B")), "/things/wood/tree"),
+			expected: []string{"things/wood/table/table"},
+		},
+		{
+			name: "modemap",
+			fs: vfs.ModeMap(vfs.Map(map[string]string{"a/b": "b"}), map[string]os.FileMode{
+				"a/b": 0600,
+				"a":   os.ModeDir | 0700,
+			}),
+			expected: []string{"a/b"},
+		},
+		{
+			name: "empty namespace",
+			fs:   vfs.NewNameSpace(),
+		},
+		{
+			name: "namespace",
+			fs: func() vfs.FileSystem {
+				ns := vfs.NewNameSpace()
+				ns.Bind("/", vfs.OS(fixture("A/animals")), "/", vfs.BindAfter)
+				ns.Bind("/", vfs.OS(fixture("B/animals")), "/", vfs.BindAfter)
+				ns.Bind("/wood", vfs.OS(fixture("B/things/wood")), "/", vfs.BindAfter)
+				ns.Bind("/1/2/3", vfs.OneFile(fixture("C/animals/cats/cats"), "cat"), "/", vfs.BindAfter)
+				ns.Bind("/m", vfs.Map(map[string]string{"x/y": "xy"}), "/", vfs.BindAfter)
+				return ns
+			}(),
+			expected: []string{"dogs/A-dogs", "dogs/B-dogs", "wood/tree/tree", "1/2/3/cat", "m/x/y"},
+		},
+	}
+	for _, tt := range tests {
+		if err := TestFS(tt.fs, tt.expected...); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+// brokenFS wraps a FileSystem and returns ReadDir entries in reverse order.
+type brokenFS struct {
+	vfs.FileSystem
+}
+
+func (fs brokenFS) ReadDir(path string) ([]os.FileInfo, error) {
+	fis, err := fs.FileSystem.ReadDir(path)
+	for i, j := 0, len(fis)-1; i < j; i, j = i+1, j-1 {
+		fis[i], fis[j] = fis[j], fis[i]
+	}
+	return fis, err
+}
+
+func TestFSReportsErrors(t *testing.T) {
+	fs := brokenFS{vfs.Map(map[string]string{"a": "a", "b": "b"})}
+	err := TestFS(fs, "a", "c")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{
+		"ReadDir: entries are not sorted by name: b a",
+		"expected but not found: /c",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
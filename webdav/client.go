@@ -0,0 +1,235 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thomasf/vfs"
+)
+
+// Client returns a read only vfs.FileSystem for the WebDAV collection at
+// baseURL. If c is nil http.DefaultClient is used. Open reads the whole file
+// into memory.
+func Client(baseURL string, c *http.Client) vfs.FileSystem {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &client{base: strings.TrimSuffix(baseURL, "/"), c: c}
+}
+
+// SafeClient is like Client but verifies that baseURL is a WebDAV
+// collection.
+func SafeClient(baseURL string, c *http.Client) vfs.FileSystemFunc {
+	return func() (vfs.FileSystem, error) {
+		fs := Client(baseURL, c)
+		fi, err := fs.Stat("/")
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s is not a readable WebDAV collection", baseURL)
+		}
+		if !fi.IsDir() {
+			return nil, errors.Errorf("%s is not a WebDAV collection", baseURL)
+		}
+		return fs, nil
+	}
+}
+
+type client struct {
+	base string
+	c    *http.Client
+}
+
+func (c *client) String() string {
+	return "webdav(" + c.base + ")"
+}
+
+func (c *client) url(p string) string {
+	u := url.URL{Path: pathpkg.Clean("/" + p)}
+	return c.base + u.EscapedPath()
+}
+
+// pathError converts a HTTP status into an *os.PathError.
+func pathError(op, p string, resp *http.Response) error {
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = os.ErrNotExist
+	case http.StatusForbidden, http.StatusUnauthorized:
+		err = os.ErrPermission
+	default:
+		err = errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return &os.PathError{Op: op, Path: p, Err: err}
+}
+
+func (c *client) Open(p string) (vfs.ReadSeekCloser, error) {
+	resp, err := c.c.Get(c.url(p))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, pathError("open", p, resp)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		// directories are served as listings, use PROPFIND to tell them
+		// apart from HTML files.
+		fi, err := c.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+		}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (c *client) Lstat(p string) (os.FileInfo, error) {
+	return c.Stat(p)
+}
+
+func (c *client) Stat(p string) (os.FileInfo, error) {
+	fis, err := c.propfind("stat", p, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) != 1 {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: errors.Errorf("got %d responses", len(fis))}
+	}
+	return fis[0], nil
+}
+
+func (c *client) ReadDir(p string) ([]os.FileInfo, error) {
+	fis, err := c.propfind("readdir", p, "1")
+	if err != nil {
+		return nil, err
+	}
+	self := pathpkg.Clean("/" + p)
+	var list []os.FileInfo
+	isDir := false
+	for _, fi := range fis {
+		if fi.(*fileInfo).path == self {
+			isDir = fi.IsDir()
+			continue
+		}
+		list = append(list, fi)
+	}
+	if !isDir {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+
+type multistatusResponse struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func (c *client) propfind(op, p, depth string) ([]os.FileInfo, error) {
+	req, err := http.NewRequest("PROPFIND", c.url(p), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, pathError(op, p, resp)
+	}
+	var ms multistatusResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+
+	base, err := url.Parse(c.base)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: err}
+	}
+	prefix := strings.TrimSuffix(base.Path, "/")
+	var fis []os.FileInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, &os.PathError{Op: op, Path: p, Err: err}
+		}
+		fi := &fileInfo{path: pathpkg.Clean("/" + strings.TrimPrefix(href.Path, prefix))}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			fi.dir = ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != "" {
+				fi.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			if ps.Prop.LastModified != "" {
+				fi.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// fileInfo is the os.FileInfo for a PROPFIND response.
+type fileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string {
+	if fi.path == "/" {
+		return "/"
+	}
+	return pathpkg.Base(fi.path)
+}
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
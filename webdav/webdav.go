@@ -0,0 +1,311 @@
+// Package webdav implements a read only WebDAV server for a vfs.FileSystem
+// and a client which exposes a WebDAV collection as a vfs.FileSystem.
+//
+// Only the methods needed for browsing are supported: OPTIONS, PROPFIND,
+// GET and HEAD. Everything else is answered with 405 Method Not Allowed,
+// which makes file managers mount the share as read only.
+package webdav // import "github.com/thomasf/vfs/webdav"
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strconv"
+	"strings"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/httpfs"
+)
+
+const allowedMethods = "OPTIONS, PROPFIND, GET, HEAD"
+
+// Options configures a Handler.
+type Options struct {
+	// Prefix is the path the handler is mounted at, it is prepended to all
+	// hrefs in PROPFIND responses. Use it together with http.StripPrefix.
+	Prefix string
+}
+
+// New returns a read only WebDAV handler for fs.
+func New(fs vfs.FileSystem, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &handler{
+		fs:     fs,
+		prefix: strings.TrimSuffix(opts.Prefix, "/"),
+		get:    httpfs.New(fs, &httpfs.Options{Listing: true}),
+	}
+}
+
+type handler struct {
+	fs     vfs.FileSystem
+	prefix string
+	get    http.Handler
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", allowedMethods)
+		w.Header().Set("DAV", "1")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		h.propfind(w, r)
+	case "GET", "HEAD":
+		h.get.ServeHTTP(w, r)
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// propfindRequest is the body of a PROPFIND request, an empty body is
+// treated as allprop.
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+func (h *handler) propfind(w http.ResponseWriter, r *http.Request) {
+	name := pathpkg.Clean("/" + r.URL.Path)
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		http.Error(w, http.StatusText(statusOf(err)), statusOf(err))
+		return
+	}
+
+	var req propfindRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid PROPFIND body", http.StatusBadRequest)
+			return
+		}
+	}
+	var names []xml.Name
+	if req.Prop != nil {
+		for _, n := range req.Prop.Names {
+			names = append(names, n.XMLName)
+		}
+	}
+
+	var responses []response
+	add := func(p string, fi os.FileInfo) {
+		if req.PropName != nil {
+			responses = append(responses, h.propNames(p, fi))
+		} else {
+			responses = append(responses, h.props(p, fi, names))
+		}
+	}
+
+	depth := r.Header.Get("Depth")
+	switch depth {
+	case "0":
+		add(name, fi)
+	case "1":
+		add(name, fi)
+		if fi.IsDir() {
+			fis, err := h.fs.ReadDir(name)
+			if err != nil {
+				http.Error(w, http.StatusText(statusOf(err)), statusOf(err))
+				return
+			}
+			for _, cfi := range fis {
+				add(pathpkg.Join(name, cfi.Name()), cfi)
+			}
+		}
+	case "", "infinity":
+		err := vfs.Walk(name, h.fs, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			add(p, fi)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, http.StatusText(statusOf(err)), statusOf(err))
+			return
+		}
+	default:
+		http.Error(w, "invalid Depth header", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := enc.Encode(multistatus{XmlnsD: "DAV:", Responses: responses}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(buf.Bytes())
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	XmlnsD    string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href      string     `xml:"D:href"`
+	Propstats []propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	Props []property
+}
+
+// property is a single property element, the name includes the namespace
+// prefix.
+type property struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+// liveProps are the DAV: properties reported by the server.
+var liveProps = []string{
+	"displayname",
+	"getcontentlength",
+	"getcontenttype",
+	"getetag",
+	"getlastmodified",
+	"resourcetype",
+}
+
+func (h *handler) href(p string, fi os.FileInfo) string {
+	u := url.URL{Path: h.prefix + p}
+	s := u.EscapedPath()
+	if fi.IsDir() && !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s
+}
+
+// liveProp returns the value of the DAV: property name of the file p as XML
+// or false if fi does not have it.
+func (h *handler) liveProp(p, name string, fi os.FileInfo) (string, bool) {
+	switch name {
+	case "displayname":
+		return escape(fi.Name()), true
+	case "resourcetype":
+		if fi.IsDir() {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "getlastmodified":
+		if fi.ModTime().IsZero() {
+			return "", false
+		}
+		return fi.ModTime().UTC().Format(http.TimeFormat), true
+	case "getcontentlength":
+		if fi.IsDir() {
+			return "", false
+		}
+		return strconv.FormatInt(fi.Size(), 10), true
+	case "getcontenttype":
+		if fi.IsDir() {
+			return "", false
+		}
+		ct := mime.TypeByExtension(pathpkg.Ext(fi.Name()))
+		if ct == "" {
+			return "", false
+		}
+		return escape(ct), true
+	case "getetag":
+		if fi.IsDir() {
+			return "", false
+		}
+		// the same entity tag as GET responses.
+		etag := httpfs.FileETag(h.fs, p, fi)
+		if etag == "" {
+			return "", false
+		}
+		return escape(etag), true
+	}
+	return "", false
+}
+
+// props returns the response for a PROPFIND with the requested names or all
+// properties if names is empty.
+func (h *handler) props(p string, fi os.FileInfo, names []xml.Name) response {
+	var found, missing []property
+	if len(names) == 0 {
+		for _, name := range liveProps {
+			if v, ok := h.liveProp(p, name, fi); ok {
+				found = append(found, property{XMLName: xml.Name{Local: "D:" + name}, Inner: v})
+			}
+		}
+	}
+	for _, n := range names {
+		if n.Space == "DAV:" {
+			if v, ok := h.liveProp(p, n.Local, fi); ok {
+				found = append(found, property{XMLName: xml.Name{Local: "D:" + n.Local}, Inner: v})
+				continue
+			}
+		}
+		missing = append(missing, property{XMLName: n})
+	}
+	resp := response{Href: h.href(p, fi)}
+	if len(found) > 0 || len(missing) == 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Prop: prop{found}, Status: "HTTP/1.1 200 OK"})
+	}
+	if len(missing) > 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Prop: prop{missing}, Status: "HTTP/1.1 404 Not Found"})
+	}
+	return resp
+}
+
+// propNames returns the response for a propname PROPFIND.
+func (h *handler) propNames(p string, fi os.FileInfo) response {
+	var props []property
+	for _, name := range liveProps {
+		if _, ok := h.liveProp(p, name, fi); ok {
+			props = append(props, property{XMLName: xml.Name{Local: "D:" + name}})
+		}
+	}
+	return response{
+		Href:      h.href(p, fi),
+		Propstats: []propstat{{Prop: prop{props}, Status: "HTTP/1.1 200 OK"}},
+	}
+}
+
+func statusOf(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,172 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thomasf/vfs"
+	"github.com/thomasf/vfs/vfstest"
+)
+
+func testNameSpace() vfs.NameSpace {
+	ns := vfs.NewNameSpace()
+	ns.Bind("/os", vfs.OS("../test-fixtures/B"), "/", vfs.BindReplace)
+	ns.Bind("/map", vfs.Map(map[string]string{
+		"a.txt":          "a",
+		"with space/b&c": "bc",
+	}), "/", vfs.BindReplace)
+	return ns
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(New(testNameSpace(), nil))
+	defer srv.Close()
+
+	fs, err := SafeClient(srv.URL, nil)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vfstest.TestFS(fs,
+		"os/things/wood/tree/tree",
+		"map/a.txt",
+		"map/with space/b&c",
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/nope"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/dav/", http.StripPrefix("/dav", New(testNameSpace(), &Options{Prefix: "/dav"})))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	fs := Client(srv.URL+"/dav/", nil)
+	fis, err := fs.ReadDir("/map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 || fis[0].Name() != "a.txt" || fis[1].Name() != "with space" || !fis[1].IsDir() {
+		t.Fatalf("unexpected entries %v", fis)
+	}
+	data, err := vfs.ReadFile(fs, "/map/with space/b&c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bc" {
+		t.Fatalf("unexpected data %q", data)
+	}
+}
+
+func propfind(t *testing.T, h http.Handler, target, depth, body string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest("PROPFIND", target, strings.NewReader(body))
+	if depth != "" {
+		r.Header.Set("Depth", depth)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestPropfind(t *testing.T) {
+	h := New(testNameSpace(), nil)
+
+	for _, tt := range []struct {
+		depth string
+		hrefs int
+	}{
+		{"0", 1},
+		{"1", 3},
+		{"infinity", 4},
+		{"", 4},
+	} {
+		code, body := propfind(t, h, "/map/", tt.depth, "")
+		if code != http.StatusMultiStatus {
+			t.Fatalf("depth %q: status %d", tt.depth, code)
+		}
+		var ms multistatusResponse
+		if err := xml.Unmarshal([]byte(body), &ms); err != nil {
+			t.Fatal(err)
+		}
+		if len(ms.Responses) != tt.hrefs {
+			t.Fatalf("depth %q: got %d responses:\n%s", tt.depth, len(ms.Responses), body)
+		}
+	}
+
+	code, body := propfind(t, h, "/map/a.txt", "0", `<?xml version="1.0"?>
+<propfind xmlns="DAV:" xmlns:X="urn:x"><prop><getcontentlength/><resourcetype/><X:color/></prop></propfind>`)
+	if code != http.StatusMultiStatus {
+		t.Fatalf("status %d", code)
+	}
+	for _, want := range []string{
+		`<D:href>/map/a.txt</D:href>`,
+		`<D:getcontentlength>1</D:getcontentlength>`,
+		`<D:resourcetype></D:resourcetype>`,
+		`<color xmlns="urn:x"></color>`,
+		`<D:status>HTTP/1.1 404 Not Found</D:status>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected response to contain %q:\n%s", want, body)
+		}
+	}
+
+	code, body = propfind(t, h, "/map/", "0", `<propfind xmlns="DAV:"><propname/></propfind>`)
+	if code != http.StatusMultiStatus || !strings.Contains(body, "<D:resourcetype></D:resourcetype>") || strings.Contains(body, "collection") {
+		t.Fatalf("unexpected propname response %d:\n%s", code, body)
+	}
+
+	if code, _ := propfind(t, h, "/nope", "0", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+	if code, _ := propfind(t, h, "/", "2", ""); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+	if code, _ := propfind(t, h, "/", "0", "<not xml"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+}
+
+func TestETag(t *testing.T) {
+	h := New(vfs.HashCache(vfs.OS("../test-fixtures/B")), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/things/wood/tree/tree", nil))
+	etag := w.Header().Get("Etag")
+	if strings.HasPrefix(etag, "W/") || etag == "" {
+		t.Fatalf("expected a strong entity tag, got %q", etag)
+	}
+	code, body := propfind(t, h, "/things/wood/tree/tree", "0", `<propfind xmlns="DAV:"><prop><getetag/></prop></propfind>`)
+	if want := "<D:getetag>" + strings.Replace(etag, `"`, "&#34;", -1) + "</D:getetag>"; code != http.StatusMultiStatus || !strings.Contains(body, want) {
+		t.Fatalf("expected response to contain %q:\n%s", want, body)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	h := New(testNameSpace(), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("DAV") != "1" || w.Header().Get("Allow") != allowedMethods {
+		t.Fatalf("unexpected OPTIONS response %d %v", w.Code, w.Header())
+	}
+	for _, m := range []string{"PUT", "DELETE", "MKCOL", "PROPPATCH", "LOCK", "MOVE"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(m, "/map/a.txt", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: expected 405, got %d", m, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/os/things/wood/tree/tree", nil))
+	data, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || string(data) != "B/things/wood/tree/tree" {
+		t.Fatalf("unexpected GET response %d %q", w.Code, data)
+	}
+}